@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// AdminSecretHeader carries the shared secret required by the admin endpoints
+const AdminSecretHeader = "X-Admin-Secret"
+
+type RecomputeResponse struct {
+	Updated int `json:"updated"`
+}
+
+// requireAdmin rejects requests that don't carry the admin secret. The admin
+// endpoints are disabled entirely when no secret is configured.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(AdminSecretHeader)
+		if s.adminSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(s.adminSecret)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "Invalid admin secret")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RecomputeHandler rescores every stored receipt, so cached points follow a
+// ruleset change
+func (s *Server) RecomputeHandler(w http.ResponseWriter, r *http.Request) {
+	updated := s.store.RecomputeAll(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecomputeResponse{Updated: updated})
+}
+
+type ResetResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// ResetHandler empties the store, so test suites can start from a clean
+// server without restarting it
+func (s *Server) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	cleared := s.store.Clear(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ResetResponse{Cleared: cleared})
+}
+
+// RulesHandler returns the RuleSet new receipts are scored with
+func (s *Server) RulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(currentRules())
+}
+
+// UpdateRulesHandler validates and installs a new RuleSet. Rules missing from
+// the body keep their default values. Points already stored are not rescored
+// until the recompute endpoint is called.
+func (s *Server) UpdateRulesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	rules, err := decodeRuleSet(r.Body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid ruleset: "+strings.TrimPrefix(err.Error(), "json: "))
+		return
+	}
+	setActiveRules(rules)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rules)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecompute(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	server.adminSecret = "s3cret"
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	first := store.AddReceipt(context.Background(), validReceipt())
+	second := store.AddReceipt(context.Background(), validReceipt())
+	original, _ := store.GetPoints(context.Background(), first)
+
+	// Change the rules after the receipts were scored
+	defer setActiveRules(currentRules())
+	rules := currentRules()
+	rules.EvenDayPoints += 100
+	rules.OddDayPoints += 100
+	setActiveRules(rules)
+
+	// Test case 1: The secret is required
+	for _, secret := range []string{"", "wrong"} {
+		req, _ := http.NewRequest("POST", "/admin/recompute", nil)
+		if secret != "" {
+			req.Header.Set(AdminSecretHeader, secret)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, "Invalid admin secret", decodeError(t, rr).Error)
+	}
+	points, _ := store.GetPoints(context.Background(), first)
+	assert.Equal(t, original, points)
+
+	// Test case 2: Stored receipts are rescored with the current rules
+	req, _ := http.NewRequest("POST", "/admin/recompute", nil)
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response RecomputeResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Updated)
+
+	for _, id := range []string{first, second} {
+		points, _ := store.GetPoints(context.Background(), id)
+		assert.Equal(t, original+100, points)
+		breakdown, _ := store.GetBreakdown(context.Background(), id)
+		assert.Equal(t, points, breakdownTotal(breakdown))
+	}
+
+	// Test case 3: Admin endpoints are disabled without a configured secret
+	server.adminSecret = ""
+	req, _ = http.NewRequest("POST", "/admin/recompute", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestReset(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	server.adminSecret = "s3cret"
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	first := store.AddReceipt(context.Background(), validReceipt())
+	store.AddReceipt(context.Background(), validReceipt())
+	_, _, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+
+	// Test case 1: The secret is required
+	req, _ := http.NewRequest("POST", "/admin/reset", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 3)
+
+	// Test case 2: Every receipt is removed and counted
+	req, _ = http.NewRequest("POST", "/admin/reset", nil)
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ResetResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 3, response.Cleared)
+
+	assert.Empty(t, store.ReceiptIDs(context.Background()))
+	_, exists := store.GetPoints(context.Background(), first)
+	assert.False(t, exists)
+	assert.Equal(t, 0, store.Stats(context.Background()).Receipts)
+	_, replayed, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+
+	// Test case 3: Receipts added after a reset are counted by the next one
+	req, _ = http.NewRequest("POST", "/admin/reset", nil)
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Cleared)
+}
+
+func TestUpdateRules(t *testing.T) {
+	defer setActiveRules(currentRules())
+
+	store := NewReceiptStore()
+	server := NewServer(store)
+	server.adminSecret = "s3cret"
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	first := store.AddReceipt(context.Background(), validReceipt())
+	original, _ := store.GetPoints(context.Background(), first)
+
+	// Test case 1: Both endpoints require the secret
+	for _, method := range []string{"GET", "PUT"} {
+		req, _ := http.NewRequest(method, "/admin/rules", strings.NewReader(`{"oddDayPoints": 106}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	assert.Equal(t, DefaultRuleSet(), currentRules())
+
+	// Test case 2: GET returns the current rules
+	req, _ := http.NewRequest("GET", "/admin/rules", nil)
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var rules RuleSet
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rules))
+	assert.Equal(t, DefaultRuleSet(), rules)
+
+	// Test case 3: Invalid rulesets are rejected and the rules are unchanged
+	for _, body := range []string{`{"oddDayPoint": 106}`, `{"timeWindowStart": "16:00", "timeWindowEnd": "14:00"}`, `not json`} {
+		req, _ = http.NewRequest("PUT", "/admin/rules", strings.NewReader(body))
+		req.Header.Set(AdminSecretHeader, "s3cret")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.True(t, strings.HasPrefix(decodeError(t, rr).Error, "Invalid ruleset"))
+	}
+	assert.Equal(t, DefaultRuleSet(), currentRules())
+
+	// Test case 4: The new rules score the next receipt
+	req, _ = http.NewRequest("PUT", "/admin/rules", strings.NewReader(`{"oddDayPoints": 106, "evenDayPoints": 100}`))
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	expected := DefaultRuleSet()
+	expected.OddDayPoints = 106
+	expected.EvenDayPoints = 100
+	assert.Equal(t, expected, currentRules())
+
+	body, _ := json.Marshal(validReceipt())
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	points, _ := store.GetPoints(context.Background(), response.ID)
+	assert.Equal(t, original+100, points)
+
+	// Test case 5: Points computed before the swap are unchanged
+	points, _ = store.GetPoints(context.Background(), first)
+	assert.Equal(t, original, points)
+
+	// Test case 6: Each breakdown still sums to the points served for it
+	for _, id := range []string{first, response.ID} {
+		req, _ = http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var pointsResponse PointsResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pointsResponse))
+
+		req, _ = http.NewRequest("GET", "/receipts/"+id+"/points/breakdown", nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var breakdown []PointsBreakdown
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &breakdown))
+		assert.Equal(t, pointsResponse.Points, breakdownTotal(breakdown), id)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// AggregateResult is the score of one receipt in an aggregate request
+type AggregateResult struct {
+	Index     int               `json:"index"`
+	Points    int               `json:"points"`
+	Breakdown []PointsBreakdown `json:"breakdown"`
+}
+
+type AggregateResponse struct {
+	TotalPoints int               `json:"totalPoints"`
+	Receipts    []AggregateResult `json:"receipts"`
+}
+
+// AggregateReceiptsHandler scores an array of receipts and sums their points
+// without storing any of them. Unlike the batch endpoint, one invalid
+// receipt fails the whole request.
+func (s *Server) AggregateReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	var receipts []Receipt
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&receipts); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid receipt aggregate format")
+		return
+	}
+
+	// Validate everything before scoring so the response names the first
+	// invalid receipt
+	for index := range receipts {
+		if err := prepareReceipt(&receipts[index]); err != nil {
+			response := validationErrorResponse(err)
+			response.Error = fmt.Sprintf("Invalid receipt %d: %s", index, response.Error)
+			for i := range response.Errors {
+				response.Errors[i].Field = fmt.Sprintf("[%d].%s", index, response.Errors[i].Field)
+			}
+			writeErrorResponse(w, response)
+			return
+		}
+	}
+
+	rules := currentRules()
+	response := AggregateResponse{Receipts: make([]AggregateResult, 0, len(receipts))}
+	for index, receipt := range receipts {
+		points, breakdown := calculatePointsDetailed(receipt, rules)
+		response.TotalPoints += points
+		response.Receipts = append(response.Receipts, AggregateResult{Index: index, Points: points, Breakdown: breakdown})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateReceipts(t *testing.T) {
+	store := NewReceiptStore()
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+
+	aggregate := func(receipts []Receipt) *httptest.ResponseRecorder {
+		reqBody, _ := json.Marshal(receipts)
+		req, _ := http.NewRequest("POST", "/receipts/aggregate", bytes.NewBuffer(reqBody))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	morning := validReceipt()
+	morning.PurchaseTime = "08:13"
+	receipts := []Receipt{validReceipt(), morning}
+
+	// Test case 1: Points are summed, with each receipt's breakdown
+	rr := aggregate(receipts)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response AggregateResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	total := 0
+	for index, receipt := range receipts {
+		points, breakdown := calculatePointsDetailed(receipt, currentRules())
+		assert.Equal(t, AggregateResult{Index: index, Points: points, Breakdown: breakdown}, response.Receipts[index])
+		total += points
+	}
+	assert.Equal(t, total, response.TotalPoints)
+
+	// Test case 2: Nothing is stored
+	assert.Equal(t, 0, store.Stats(context.Background()).Receipts)
+
+	// Test case 3: One invalid receipt fails the request and is named by index
+	morning.PurchaseDate = ""
+	rr = aggregate([]Receipt{validReceipt(), morning})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	errResponse := decodeError(t, rr)
+	assert.Equal(t, "Invalid receipt 1: Missing required receipt fields", errResponse.Error)
+	assert.Equal(t, []FieldError{{Field: "[1].purchaseDate", Message: "Missing required receipt fields"}}, errResponse.Errors)
+
+	// Test case 4: A body that isn't an array of receipts
+	req, _ := http.NewRequest("POST", "/receipts/aggregate", bytes.NewBufferString(`{"retailer": "Target"}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid receipt aggregate format", decodeError(t, rr).Error)
+}
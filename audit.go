@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditBuffer is how many entries may wait to be written before further
+// entries are dropped
+const auditBuffer = 1024
+
+// AuditEntry records how the points of a stored receipt were calculated:
+// which rules were applied and what each contributed
+type AuditEntry struct {
+	ReceiptID      string            `json:"receiptId"`
+	RuleSetVersion string            `json:"ruleSetVersion"`
+	Points         int               `json:"points"`
+	Breakdown      []PointsBreakdown `json:"breakdown"`
+	CalculatedAt   time.Time         `json:"calculatedAt"`
+}
+
+// AuditLogger appends an AuditEntry per scored receipt to a sink, one JSON
+// object per line. Entries are written in the background so a slow or failing
+// sink never delays or fails a request; entries that don't fit in the buffer
+// are dropped and logged.
+type AuditLogger struct {
+	entries chan AuditEntry
+	done    chan struct{}
+	closer  io.Closer
+	once    sync.Once
+}
+
+// NewAuditLogger writes audit entries to out until Close is called
+func NewAuditLogger(out io.Writer) *AuditLogger {
+	al := &AuditLogger{
+		entries: make(chan AuditEntry, auditBuffer),
+		done:    make(chan struct{}),
+	}
+	go al.run(out)
+	return al
+}
+
+// OpenAuditLog appends audit entries to the file at path, creating it if
+// needed, or writes them to stdout when path is empty
+func OpenAuditLog(path string) (*AuditLogger, error) {
+	if path == "" {
+		return NewAuditLogger(os.Stdout), nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	al := NewAuditLogger(file)
+	al.closer = file
+	return al, nil
+}
+
+func (al *AuditLogger) run(out io.Writer) {
+	defer close(al.done)
+	encoder := json.NewEncoder(out)
+	for entry := range al.entries {
+		if err := encoder.Encode(entry); err != nil {
+			slog.Error("failed to write audit entry", "receiptId", entry.ReceiptID, "error", err)
+		}
+	}
+}
+
+// Record queues the entry to be written without waiting for the sink
+func (al *AuditLogger) Record(entry AuditEntry) {
+	select {
+	case al.entries <- entry:
+	default:
+		slog.Warn("audit log is backed up, dropping entry", "receiptId", entry.ReceiptID)
+	}
+}
+
+// Close writes the entries still queued and closes the audit file. Nothing
+// may be recorded afterwards.
+func (al *AuditLogger) Close() error {
+	var err error
+	al.once.Do(func() {
+		close(al.entries)
+		<-al.done
+		if al.closer != nil {
+			err = al.closer.Close()
+		}
+	})
+	return err
+}
+
+// auditStore records an audit entry for every receipt it scores, whether
+// newly stored or recomputed. Entries carry the points and rules the store
+// scored with, so they match what was stored even if the rules change
+// meanwhile.
+type auditStore struct {
+	Store
+	audit *AuditLogger
+}
+
+// RecomputeAll audits the receipts rescored by a recompute that succeeded
+func (as *auditStore) RecomputeAll(ctx context.Context) int {
+	var scored []ScoredReceipt
+	count := as.Store.RecomputeAll(withScoredReporter(ctx, func(report ScoredReceipt) {
+		scored = append(scored, report)
+	}))
+	if count > 0 {
+		for _, report := range scored {
+			as.audit.record(report)
+		}
+	}
+	return count
+}
+
+// record audits one scored receipt. The breakdown is calculated again with
+// the rules the store used, so it adds up to the stored points.
+func (al *AuditLogger) record(scored ScoredReceipt) {
+	_, breakdown := calculatePointsDetailed(scored.Receipt, scored.Rules)
+	al.Record(AuditEntry{
+		ReceiptID:      scored.ID,
+		RuleSetVersion: scored.Rules.Version(),
+		Points:         scored.Points,
+		Breakdown:      breakdown,
+		CalculatedAt:   time.Now().UTC(),
+	})
+}
+
+// AuditStore wraps the store so that every scored receipt is audited
+func (al *AuditLogger) AuditStore(store Store) Store {
+	return &auditStore{Store: onStored(store, al.record), audit: al}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	assert.NoError(t, err)
+	backend := NewReceiptStore()
+	backend.dedup = true
+	store := audit.AuditStore(backend)
+
+	// Test case 1: Storing a receipt audits its points and breakdown
+	id := store.AddReceipt(context.Background(), validReceipt())
+
+	// Test case 2: A deduplicated receipt wasn't scored again
+	store.AddReceipt(context.Background(), validReceipt())
+
+	// Test case 3: Recomputing audits every stored receipt again
+	store.RecomputeAll(context.Background())
+
+	// Test case 4: Replayed idempotent receipts weren't scored again
+	changed := validReceipt()
+	changed.PurchaseTime = "08:13"
+	store.AddReceiptIdempotent(context.Background(), "key-1", changed)
+	store.AddReceiptIdempotent(context.Background(), "key-1", changed)
+
+	// Close writes the queued entries
+	assert.NoError(t, audit.Close())
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Len(t, entries, 3)
+
+	points, breakdown := calculatePointsDetailed(validReceipt(), currentRules())
+	entry := entries[0]
+	assert.Equal(t, id, entry.ReceiptID)
+	assert.Equal(t, currentRules().Version(), entry.RuleSetVersion)
+	assert.Len(t, entry.RuleSetVersion, 12)
+	assert.Equal(t, points, entry.Points)
+	assert.Equal(t, breakdown, entry.Breakdown)
+	assert.WithinDuration(t, time.Now(), entry.CalculatedAt, time.Minute)
+	assert.Equal(t, id, entries[1].ReceiptID)
+	assert.NotEqual(t, id, entries[2].ReceiptID)
+}
+
+func TestAuditLoggerNeverBlocks(t *testing.T) {
+	// Test case 1: Entries beyond the buffer are dropped while the sink is stuck
+	sink := &blockingWriter{release: make(chan struct{})}
+	audit := NewAuditLogger(sink)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < auditBuffer+10; i++ {
+			audit.Record(AuditEntry{ReceiptID: "id"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording blocked on a stuck sink")
+	}
+	close(sink.release)
+	assert.NoError(t, audit.Close())
+}
+
+// blockingWriter holds every write until release is closed
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (bw *blockingWriter) Write(b []byte) (int, error) {
+	<-bw.release
+	return len(b), nil
+}
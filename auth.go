@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authExemptPaths are served without a token so liveness probes keep working
+var authExemptPaths = map[string]bool{
+	"/healthz": true,
+}
+
+// AuthMiddleware requires an "Authorization: Bearer <token>" header matching
+// token. Authentication is disabled when token is empty.
+func AuthMiddleware(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="receipt-processor"`)
+			writeJSONError(w, http.StatusUnauthorized, "Missing or invalid bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+	handler := AuthMiddleware("s3cret", router)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"missing token", "/receipts", "", http.StatusUnauthorized},
+		{"wrong token", "/receipts", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "/receipts", "Basic s3cret", http.StatusUnauthorized},
+		{"correct token", "/receipts", "Bearer s3cret", http.StatusOK},
+		{"healthz bypasses auth", "/healthz", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, "Missing or invalid bearer token", decodeError(t, rr).Error)
+				assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+
+	// Without a configured token every request is allowed
+	req, _ := http.NewRequest("GET", "/receipts", nil)
+	rr := httptest.NewRecorder()
+	AuthMiddleware("", router).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names used by the Bolt store
+var (
+	receiptsBucket    = []byte("receipts")
+	pointsBucket      = []byte("points")
+	breakdownsBucket  = []byte("breakdowns")
+	idempotencyBucket = []byte("idempotency")
+	hashesBucket      = []byte("hashes")
+
+	// addedBucket maps each id to when the receipt was stored, in RFC 3339
+	addedBucket = []byte("added")
+)
+
+// boltBuckets lists every bucket the store uses
+var boltBuckets = [][]byte{receiptsBucket, pointsBucket, breakdownsBucket, idempotencyBucket, hashesBucket, addedBucket}
+
+// Persistent storage backed by a local bbolt file
+type BoltReceiptStore struct {
+	db *bolt.DB
+
+	// dedup makes AddReceipt return the existing id for identical receipts
+	dedup bool
+
+	// idGenerator creates the id of each new receipt
+	idGenerator IDGenerator
+}
+
+func NewBoltReceiptStore(path string) (*BoltReceiptStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltReceiptStore{db: db, idGenerator: UUIDGenerator{}}, nil
+}
+
+func (bs *BoltReceiptStore) Close() error {
+	return bs.db.Close()
+}
+
+// AddReceipt stores the receipt and its points, returning an empty id if the
+// write fails.
+func (bs *BoltReceiptStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	var id string
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = bs.putReceipt(ctx, tx, receipt)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to store receipt", "error", err)
+		return ""
+	}
+
+	return id
+}
+
+func (bs *BoltReceiptStore) AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (string, bool, error) {
+	var id string
+	replayed := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(idempotencyBucket)
+
+		// A key whose receipt has since been deleted is treated as unused
+		if existing := keys.Get([]byte(key)); existing != nil {
+			if storedJSON := tx.Bucket(receiptsBucket).Get(existing); storedJSON != nil {
+				var stored Receipt
+				if err := json.Unmarshal(storedJSON, &stored); err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(stored, receipt) {
+					return ErrIdempotencyConflict
+				}
+				id = string(existing)
+				replayed = true
+				return nil
+			}
+		}
+
+		var err error
+		id, err = bs.putReceipt(ctx, tx, receipt)
+		if err != nil {
+			return err
+		}
+		return keys.Put([]byte(key), []byte(id))
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, replayed, nil
+}
+
+// putReceipt stores a receipt and its points under a new id, or returns the
+// id of an identical receipt when dedup is enabled
+func (bs *BoltReceiptStore) putReceipt(ctx context.Context, tx *bolt.Tx, receipt Receipt) (string, error) {
+	if existing := tx.Bucket(hashesBucket).Get([]byte(receiptHash(receipt))); existing != nil && bs.dedup {
+		return string(existing), nil
+	}
+
+	id := bs.idGenerator.Generate()
+	if err := bs.writeReceipt(ctx, tx, id, receipt); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// writeReceipt scores a receipt and stores it and its points under id
+func (bs *BoltReceiptStore) writeReceipt(ctx context.Context, tx *bolt.Tx, id string, receipt Receipt) error {
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	if err := tx.Bucket(hashesBucket).Put([]byte(receiptHash(receipt)), []byte(id)); err != nil {
+		return err
+	}
+
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(receiptsBucket).Put([]byte(id), receiptJSON); err != nil {
+		return err
+	}
+	if err := putAddedAt(tx, id); err != nil {
+		return err
+	}
+	if err := putScore(tx, id, points, breakdown); err != nil {
+		return err
+	}
+	reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+	return nil
+}
+
+// putScore stores the points of the receipt under id and their breakdown
+func putScore(tx *bolt.Tx, id string, points int, breakdown []PointsBreakdown) error {
+	pointsJSON, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(pointsBucket).Put([]byte(id), pointsJSON); err != nil {
+		return err
+	}
+	return tx.Bucket(breakdownsBucket).Put([]byte(id), breakdownJSON)
+}
+
+// putAddedAt records that the receipt under id was stored now
+func putAddedAt(tx *bolt.Tx, id string) error {
+	return tx.Bucket(addedBucket).Put([]byte(id), []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+}
+
+func (bs *BoltReceiptStore) AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(receiptsBucket).Get([]byte(id)) != nil {
+			return ErrReceiptExists
+		}
+		return bs.writeReceipt(ctx, tx, id, receipt)
+	})
+}
+
+func (bs *BoltReceiptStore) GetPoints(ctx context.Context, id string) (int, bool) {
+	var value []byte
+	bs.db.View(func(tx *bolt.Tx) error {
+		value = copyBytes(tx.Bucket(pointsBucket).Get([]byte(id)))
+		return nil
+	})
+	if value == nil {
+		return 0, false
+	}
+
+	var points int
+	if err := json.Unmarshal(value, &points); err != nil {
+		slog.Error("failed to decode points", "id", id, "error", err)
+		return 0, false
+	}
+	return points, true
+}
+
+func (bs *BoltReceiptStore) GetBreakdown(ctx context.Context, id string) ([]PointsBreakdown, bool) {
+	var value []byte
+	bs.db.View(func(tx *bolt.Tx) error {
+		value = copyBytes(tx.Bucket(breakdownsBucket).Get([]byte(id)))
+		return nil
+	})
+	if value == nil {
+		return nil, false
+	}
+
+	var breakdown []PointsBreakdown
+	if err := json.Unmarshal(value, &breakdown); err != nil {
+		slog.Error("failed to decode points breakdown", "id", id, "error", err)
+		return nil, false
+	}
+	return breakdown, true
+}
+
+func (bs *BoltReceiptStore) GetReceipt(ctx context.Context, id string) (Receipt, bool) {
+	var value []byte
+	bs.db.View(func(tx *bolt.Tx) error {
+		value = copyBytes(tx.Bucket(receiptsBucket).Get([]byte(id)))
+		return nil
+	})
+	if value == nil {
+		return Receipt{}, false
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(value, &receipt); err != nil {
+		slog.Error("failed to decode receipt", "id", id, "error", err)
+		return Receipt{}, false
+	}
+	return receipt, true
+}
+
+func (bs *BoltReceiptStore) FindReceipt(ctx context.Context, receipt Receipt) (string, bool) {
+	var id string
+	bs.db.View(func(tx *bolt.Tx) error {
+		existing := tx.Bucket(hashesBucket).Get([]byte(receiptHash(receipt)))
+		if existing != nil && tx.Bucket(receiptsBucket).Get(existing) != nil {
+			id = string(existing)
+		}
+		return nil
+	})
+	return id, id != ""
+}
+
+func (bs *BoltReceiptStore) DeleteReceipt(ctx context.Context, id string) bool {
+	deleted := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		receipts := tx.Bucket(receiptsBucket)
+		receiptJSON := receipts.Get([]byte(id))
+		if receiptJSON == nil {
+			return nil
+		}
+
+		var receipt Receipt
+		if err := json.Unmarshal(receiptJSON, &receipt); err != nil {
+			return err
+		}
+		hashes := tx.Bucket(hashesBucket)
+		hash := []byte(receiptHash(receipt))
+		if string(hashes.Get(hash)) == id {
+			if err := hashes.Delete(hash); err != nil {
+				return err
+			}
+		}
+
+		if err := receipts.Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(pointsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(breakdownsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(addedBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to delete receipt", "id", id, "error", err)
+		return false
+	}
+	return deleted
+}
+
+func (bs *BoltReceiptStore) ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int) {
+	summaries, err := bs.summaries()
+	if err != nil {
+		slog.Error("failed to list receipts", "error", err)
+		return []ReceiptSummary{}, 0
+	}
+	summaries = filterSummaries(summaries, filter)
+
+	return paginateSummaries(summaries, limit, offset), len(summaries)
+}
+
+func (bs *BoltReceiptStore) TopReceipts(ctx context.Context, n int) []ReceiptSummary {
+	summaries, err := bs.summaries()
+	if err != nil {
+		slog.Error("failed to rank receipts", "error", err)
+		return []ReceiptSummary{}
+	}
+
+	return topSummaries(summaries, n)
+}
+
+// summaries returns a summary of every stored receipt, in key order
+func (bs *BoltReceiptStore) summaries() ([]ReceiptSummary, error) {
+	summaries := []ReceiptSummary{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		points := tx.Bucket(pointsBucket)
+		return tx.Bucket(receiptsBucket).ForEach(func(id, value []byte) error {
+			var receipt Receipt
+			if err := json.Unmarshal(value, &receipt); err != nil {
+				return err
+			}
+			var receiptPoints int
+			if err := json.Unmarshal(points.Get(id), &receiptPoints); err != nil {
+				return err
+			}
+			summaries = append(summaries, newReceiptSummary(string(id), receipt, receiptPoints))
+			return nil
+		})
+	})
+	return summaries, err
+}
+
+// ChangesSince skips receipts stored before the added bucket existed, since
+// when they were stored is unknown
+func (bs *BoltReceiptStore) ChangesSince(ctx context.Context, since time.Time) []ReceiptSummary {
+	changes := []ReceiptSummary{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		receipts := tx.Bucket(receiptsBucket)
+		points := tx.Bucket(pointsBucket)
+		return tx.Bucket(addedBucket).ForEach(func(id, value []byte) error {
+			addedAt, err := time.Parse(time.RFC3339Nano, string(value))
+			if err != nil {
+				return err
+			}
+			if !addedAt.After(since) {
+				return nil
+			}
+
+			var receipt Receipt
+			if err := json.Unmarshal(receipts.Get(id), &receipt); err != nil {
+				return err
+			}
+			var receiptPoints int
+			if err := json.Unmarshal(points.Get(id), &receiptPoints); err != nil {
+				return err
+			}
+			changes = append(changes, newReceiptChange(string(id), receipt, receiptPoints, addedAt))
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("failed to list receipt changes", "error", err)
+		return []ReceiptSummary{}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func (bs *BoltReceiptStore) RecomputeAll(ctx context.Context) int {
+	rules := currentRules()
+	updated := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(receiptsBucket).ForEach(func(id, value []byte) error {
+			var receipt Receipt
+			if err := json.Unmarshal(value, &receipt); err != nil {
+				return err
+			}
+			receiptPoints, breakdown := calculatePointsDetailed(receipt, rules)
+			if err := putScore(tx, string(id), receiptPoints, breakdown); err != nil {
+				return err
+			}
+			reportScored(ctx, ScoredReceipt{ID: string(id), Receipt: receipt, Points: receiptPoints, Rules: rules})
+			updated++
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("failed to recompute points", "error", err)
+		return 0
+	}
+	return updated
+}
+
+func (bs *BoltReceiptStore) Stats(ctx context.Context) StatsResponse {
+	stats := newStatsResponse()
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		points := tx.Bucket(pointsBucket)
+		return tx.Bucket(receiptsBucket).ForEach(func(id, value []byte) error {
+			var receipt Receipt
+			if err := json.Unmarshal(value, &receipt); err != nil {
+				return err
+			}
+			var receiptPoints int
+			if err := json.Unmarshal(points.Get(id), &receiptPoints); err != nil {
+				return err
+			}
+			stats.add(receipt, receiptPoints)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("failed to compute stats", "error", err)
+		return newStatsResponse()
+	}
+	return stats
+}
+
+func (bs *BoltReceiptStore) ReceiptIDs(ctx context.Context) []string {
+	ids := []string{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(receiptsBucket).ForEach(func(id, value []byte) error {
+			ids = append(ids, string(id))
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("failed to list receipt ids", "error", err)
+		return []string{}
+	}
+	return ids
+}
+
+func (bs *BoltReceiptStore) ImportReceipt(ctx context.Context, id string, receipt Receipt, points int, breakdown []PointsBreakdown) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		receipts := tx.Bucket(receiptsBucket)
+		hashes := tx.Bucket(hashesBucket)
+
+		// Drop the dedup entry of a receipt being replaced
+		if previousJSON := receipts.Get([]byte(id)); previousJSON != nil {
+			var previous Receipt
+			if err := json.Unmarshal(previousJSON, &previous); err != nil {
+				return err
+			}
+			if hash := []byte(receiptHash(previous)); string(hashes.Get(hash)) == id {
+				if err := hashes.Delete(hash); err != nil {
+					return err
+				}
+			}
+		}
+
+		receiptJSON, err := json.Marshal(receipt)
+		if err != nil {
+			return err
+		}
+
+		if err := hashes.Put([]byte(receiptHash(receipt)), []byte(id)); err != nil {
+			return err
+		}
+		if err := receipts.Put([]byte(id), receiptJSON); err != nil {
+			return err
+		}
+		if err := putAddedAt(tx, id); err != nil {
+			return err
+		}
+		return putScore(tx, id, points, breakdown)
+	})
+}
+
+// Clear deletes and recreates every bucket in a single transaction
+func (bs *BoltReceiptStore) Clear(ctx context.Context) int {
+	cleared := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(receiptsBucket).ForEach(func(id, value []byte) error {
+			cleared++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, bucket := range boltBuckets {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to clear receipts", "error", err)
+		return 0
+	}
+	return cleared
+}
+
+// Ping checks that the database is open and its buckets exist
+func (bs *BoltReceiptStore) Ping(ctx context.Context) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(receiptsBucket) == nil || tx.Bucket(pointsBucket) == nil {
+			return errors.New("receipt buckets are missing")
+		}
+		return nil
+	})
+}
+
+// copyBytes copies a value out of a Bolt transaction, since values returned by
+// Get are only valid while the transaction is open.
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage:
+  receipt-processor [serve]                     start the HTTP server (default)
+  receipt-processor score [-breakdown] FILE     print the points for a receipt file, or - for stdin
+`
+
+// runScore implements the score subcommand and returns the process exit code.
+// Receipts go through the same validation and scoring as the HTTP API, with
+// the rules and limits loaded from CONFIG_FILE and the environment.
+func runScore(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("score", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	breakdown := flags.Bool("breakdown", false, "print the points awarded by each rule")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	if err := applyScoringConfig(config); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var receipt Receipt
+	decoder := json.NewDecoder(input)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&receipt); err != nil {
+		fmt.Fprintf(stderr, "Invalid receipt format: %v\n", err)
+		return 1
+	}
+	if err := prepareReceipt(&receipt); err != nil {
+		_, message := validationStatus(err)
+		fmt.Fprintln(stderr, message)
+		return 1
+	}
+
+	points, lines := calculatePointsDetailed(receipt, currentRules())
+	if *breakdown {
+		for _, line := range lines {
+			fmt.Fprintln(stdout, line.Description)
+		}
+		fmt.Fprintf(stdout, "Total Points: %d\n", points)
+		return 0
+	}
+
+	fmt.Fprintln(stdout, points)
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunScore(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", "")
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+
+	// Test case 1: Print the points for a valid receipt
+	code := runScore([]string{"examples/morning-receipt.json"}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "15\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	// Test case 2: Print the breakdown as well
+	stdout.Reset()
+	code = runScore([]string{"-breakdown", "examples/morning-receipt.json"}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "9 points - retailer name has 9 alphanumeric characters\n"+
+		"5 points - 2 items (1 pairs @ 5 points each)\n"+
+		"1 points - \"Dasani\" is 6 characters (a multiple of 3)\n"+
+		"Total Points: 15\n", stdout.String())
+
+	// Test case 3: Invalid receipts exit non-zero with the validation error
+	path := filepath.Join(dir, "invalid.json")
+	os.WriteFile(path, []byte(`{"retailer": "Target", "purchaseTime": "13:01", "items": [], "total": "1.00"}`), 0600)
+
+	stdout.Reset()
+	code = runScore([]string{path}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "Missing required receipt fields\n", stderr.String())
+
+	// Test case 4: Missing file and missing argument
+	stderr.Reset()
+	code = runScore([]string{filepath.Join(dir, "missing.json")}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.NotEmpty(t, stderr.String())
+
+	code = runScore([]string{}, &stdout, &stderr)
+	assert.Equal(t, 2, code)
+}
+
+func TestRunScoreConfig(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", "")
+	defer setActiveRules(currentRules())
+	defer func(limits ValidationLimits) { validationLimits = limits }(validationLimits)
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+
+	// Test case 1: Rules come from RULES_PATH, as they do for the server
+	rulesPath := filepath.Join(dir, "rules.json")
+	os.WriteFile(rulesPath, []byte(`{"retailerCharPoints": 2}`), 0600)
+	t.Setenv("RULES_PATH", rulesPath)
+
+	code := runScore([]string{"examples/morning-receipt.json"}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "24\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	// Test case 2: Validation limits come from the config file
+	configPath := filepath.Join(dir, "config.yaml")
+	os.WriteFile(configPath, []byte("limits:\n  maxItems: 1\n"), 0600)
+	t.Setenv("CONFIG_FILE", configPath)
+
+	stdout.Reset()
+	code = runScore([]string{"examples/morning-receipt.json"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "Receipt must contain at most 1 items\n", stderr.String())
+
+	// Test case 3: An invalid configuration is reported
+	stderr.Reset()
+	t.Setenv("RULES_PATH", filepath.Join(dir, "missing.json"))
+	code = runScore([]string{"examples/morning-receipt.json"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "Invalid configuration")
+}
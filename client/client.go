@@ -0,0 +1,126 @@
+// Package client is a Go client for the receipt processor API.
+//
+//	c := client.New("http://localhost:8080", nil)
+//	id, err := c.ProcessReceipt(ctx, receipt)
+//	points, err := c.GetPoints(ctx, id)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Receipt and Item mirror the JSON accepted by POST /receipts/process
+type Receipt struct {
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Items        []Item `json:"items"`
+	Total        string `json:"total"`
+	Currency     string `json:"currency,omitempty"`
+}
+
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+// Error is returned for any response outside the 2xx range. Message is the
+// server's error message, or the raw body when it isn't a JSON error.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("receipt processor returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls a receipt processor at a base URL such as
+// "http://localhost:8080"
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// New creates a Client. A nil httpClient uses http.DefaultClient.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient}
+}
+
+// ProcessReceipt submits a receipt and returns the id it was stored under
+func (c *Client) ProcessReceipt(ctx context.Context, receipt Receipt) (string, error) {
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/receipts/process", body, &response); err != nil {
+		return "", err
+	}
+	return response.ID, nil
+}
+
+// GetPoints returns the points awarded to a processed receipt. An unknown id
+// is reported as an *Error with StatusCode 404.
+func (c *Client) GetPoints(ctx context.Context, id string) (int, error) {
+	var response struct {
+		Points int `json:"points"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/receipts/"+url.PathEscape(id)+"/points", nil, &response); err != nil {
+		return 0, err
+	}
+	return response.Points, nil
+}
+
+// do sends a request and decodes a successful JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeError builds an *Error from a failed response
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var response struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &response); err == nil && response.Error != "" {
+		message = response.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
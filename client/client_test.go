@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	c := New(server.URL+"/", server.Client())
+
+	tests := []struct {
+		status  int
+		body    string
+		message string
+	}{
+		// Test case 1: JSON errors use the server's message
+		{http.StatusUnauthorized, `{"error": "Missing or invalid bearer token", "status": 401}`, "Missing or invalid bearer token"},
+		// Test case 2: Other bodies are used as they are
+		{http.StatusBadGateway, "upstream unavailable\n", "upstream unavailable"},
+		// Test case 3: Empty bodies fall back to the status text
+		{http.StatusServiceUnavailable, "", "Service Unavailable"},
+	}
+
+	for _, tt := range tests {
+		status, body = tt.status, tt.body
+		_, err := c.GetPoints(context.Background(), "some-id")
+
+		var apiErr *Error
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, tt.status, apiErr.StatusCode)
+		assert.Equal(t, tt.message, apiErr.Message)
+	}
+
+	// Test case 4: Transport errors are returned unchanged
+	server.Close()
+	_, err := c.ProcessReceipt(context.Background(), Receipt{})
+	var apiErr *Error
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &apiErr))
+}
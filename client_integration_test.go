@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"receipt-processor/client"
+)
+
+func TestClientAgainstServer(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := client.New(server.URL, server.Client())
+	receipt := client.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []client.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	// Test case 1: A processed receipt's points can be fetched by id
+	id, err := c.ProcessReceipt(context.Background(), receipt)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	points, err := c.GetPoints(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, 109, points)
+
+	// Test case 2: Validation failures carry the status and message
+	receipt.Total = "9.01"
+	_, err = c.ProcessReceipt(context.Background(), receipt)
+	var apiErr *client.Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "Total does not match sum of items", apiErr.Message)
+
+	// Test case 3: Unknown ids are a 404
+	_, err = c.GetPoints(context.Background(), "missing-id")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "No receipt found for that id", apiErr.Message)
+}
@@ -0,0 +1,218 @@
+// Command loadtest drives a running receipt processor with concurrent
+// clients that POST generated receipts and then GET their points, and
+// reports latency percentiles and error rates for each request type.
+//
+//	go run ./cmd/loadtest -url http://localhost:8080 -concurrency 20 -duration 30s
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Request types reported separately
+const (
+	opProcess = "process"
+	opPoints  = "points"
+)
+
+var retailers = []string{"Target", "Walgreens", "M&M Corner Market", "Corner Store", "Trader Joes"}
+
+// item and receipt mirror the server's request format
+type item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+type receipt struct {
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Items        []item `json:"items"`
+	Total        string `json:"total"`
+}
+
+// result is the outcome of one request
+type result struct {
+	op      string
+	latency time.Duration
+	err     bool
+}
+
+// summary aggregates the results of one request type
+type summary struct {
+	Requests  int
+	Errors    int
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+	ErrorRate float64
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "base URL of the receipt processor")
+	concurrency := flag.Int("concurrency", 10, "number of concurrent clients")
+	duration := flag.Duration("duration", 10*time.Second, "how long to generate load")
+	token := flag.String("token", "", "bearer token, when the server sets API_TOKEN")
+	flag.Parse()
+
+	if *concurrency <= 0 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "concurrency and duration must be positive")
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	results := make(chan result, *concurrency*2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			runClient(ctx, client, *url, *token, rand.New(rand.NewSource(seed)), results)
+		}(time.Now().UnixNano() + int64(i))
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	latencies := map[string][]time.Duration{}
+	errors := map[string]int{}
+	for r := range results {
+		latencies[r.op] = append(latencies[r.op], r.latency)
+		if r.err {
+			errors[r.op]++
+		}
+	}
+
+	fmt.Printf("%d clients for %s against %s\n\n", *concurrency, *duration, *url)
+	fmt.Printf("%-8s %10s %8s %8s %10s %10s %10s\n", "request", "count", "errors", "rate", "p50", "p95", "p99")
+	for _, op := range []string{opProcess, opPoints} {
+		s := summarize(latencies[op], errors[op])
+		fmt.Printf("%-8s %10d %8d %7.2f%% %10s %10s %10s\n", op, s.Requests, s.Errors, s.ErrorRate*100,
+			s.P50.Round(time.Microsecond), s.P95.Round(time.Microsecond), s.P99.Round(time.Microsecond))
+	}
+}
+
+// runClient processes receipts and fetches their points until ctx is done
+func runClient(ctx context.Context, client *http.Client, baseURL, token string, rng *rand.Rand, results chan<- result) {
+	for ctx.Err() == nil {
+		body, _ := json.Marshal(generateReceipt(rng))
+
+		var processed struct {
+			ID string `json:"id"`
+		}
+		latency, err := send(ctx, client, "POST", baseURL+"/receipts/process", token, body, &processed)
+		if ctx.Err() != nil {
+			return
+		}
+		results <- result{op: opProcess, latency: latency, err: err != nil || processed.ID == ""}
+		if err != nil || processed.ID == "" {
+			continue
+		}
+
+		var points struct {
+			Points *int `json:"points"`
+		}
+		latency, err = send(ctx, client, "GET", baseURL+"/receipts/"+processed.ID+"/points", token, nil, &points)
+		if ctx.Err() != nil {
+			return
+		}
+		results <- result{op: opPoints, latency: latency, err: err != nil || points.Points == nil}
+	}
+}
+
+// send issues one request and decodes a 200 response into out, returning how
+// long the request took
+func send(ctx context.Context, client *http.Client, method, url, token string, body []byte, out any) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return time.Since(start), fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	return time.Since(start), err
+}
+
+// generateReceipt returns a random receipt that passes validation
+func generateReceipt(rng *rand.Rand) receipt {
+	items := make([]item, 1+rng.Intn(6))
+	totalCents := 0
+	for i := range items {
+		cents := 1 + rng.Intn(5000)
+		totalCents += cents
+		items[i] = item{
+			ShortDescription: fmt.Sprintf("Item %d", rng.Intn(1000)),
+			Price:            formatCents(cents),
+		}
+	}
+
+	purchasedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(rng.Intn(365*24*60)) * time.Minute)
+	return receipt{
+		Retailer:     retailers[rng.Intn(len(retailers))],
+		PurchaseDate: purchasedAt.Format("2006-01-02"),
+		PurchaseTime: purchasedAt.Format("15:04"),
+		Items:        items,
+		Total:        formatCents(totalCents),
+	}
+}
+
+func formatCents(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// summarize computes the error rate and latency percentiles of one request type
+func summarize(latencies []time.Duration, errors int) summary {
+	s := summary{Requests: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return s
+	}
+
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s.P50 = percentile(sorted, 50)
+	s.P95 = percentile(sorted, 95)
+	s.P99 = percentile(sorted, 99)
+	s.ErrorRate = float64(errors) / float64(len(latencies))
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		// Reverse order to check that the latencies are sorted
+		latencies[i] = time.Duration(100-i) * time.Millisecond
+	}
+
+	// Test case 1: Nearest-rank percentiles and error rate
+	s := summarize(latencies, 5)
+	assert.Equal(t, 100, s.Requests)
+	assert.Equal(t, 50*time.Millisecond, s.P50)
+	assert.Equal(t, 95*time.Millisecond, s.P95)
+	assert.Equal(t, 99*time.Millisecond, s.P99)
+	assert.InDelta(t, 0.05, s.ErrorRate, 1e-9)
+
+	// Test case 2: No requests
+	assert.Equal(t, summary{}, summarize(nil, 0))
+}
+
+func TestGenerateReceipt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		r := generateReceipt(rng)
+
+		totalCents := 0
+		for _, item := range r.Items {
+			var dollars, cents int
+			_, err := fmt.Sscanf(item.Price, "%d.%02d", &dollars, &cents)
+			assert.NoError(t, err)
+			totalCents += dollars*100 + cents
+		}
+		assert.Equal(t, formatCents(totalCents), r.Total)
+		assert.NotEmpty(t, r.Items)
+	}
+}
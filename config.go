@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Store backends selectable in the config file
+const (
+	backendMemory = "memory"
+	backendBolt   = "bolt"
+	backendRedis  = "redis"
+)
+
+// StoreConfig selects and configures the receipt store
+type StoreConfig struct {
+	Backend  string `yaml:"backend"`
+	DBPath   string `yaml:"dbPath"`
+	RedisURL string `yaml:"redisURL"`
+	Dedup    bool   `yaml:"dedup"`
+
+	// TTL only applies to the in-memory store; zero keeps receipts forever
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxReceipts caps the in-memory store, evicting the oldest receipts
+	// first; zero is unlimited
+	MaxReceipts int `yaml:"maxReceipts"`
+
+	// SnapshotPath persists the in-memory store to a JSON file every
+	// SnapshotInterval and on shutdown, and restores it at startup
+	SnapshotPath     string        `yaml:"snapshotPath"`
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+}
+
+// Config holds every server setting. It is loaded from an optional YAML file
+// and then overridden by environment variables.
+type Config struct {
+	ListenAddr          string            `yaml:"listenAddr"`
+	TLS                 TLSConfig         `yaml:"tls"`
+	Store               StoreConfig       `yaml:"store"`
+	Rules               RuleSet           `yaml:"rules"`
+	RuleSets            map[string]string `yaml:"ruleSets"`
+	Limits              ValidationLimits  `yaml:"limits"`
+	RateLimitRPS        int64             `yaml:"rateLimitRPS"`
+	RateLimitBurst      int64             `yaml:"rateLimitBurst"`
+	RequestTimeout      time.Duration     `yaml:"requestTimeout"`
+	MaxBodyBytes        int64             `yaml:"maxBodyBytes"`
+	LeaderboardMaxLimit int64             `yaml:"leaderboardMaxLimit"`
+	CORSAllowedOrigins  []string          `yaml:"corsAllowedOrigins"`
+	APIToken            string            `yaml:"apiToken"`
+	AdminSecret         string            `yaml:"adminSecret"`
+	WebhookURL          string            `yaml:"webhookURL"`
+
+	// TrustedProxies are the IP addresses or CIDR ranges of the proxies whose
+	// X-Forwarded-For header identifies clients for rate limiting
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// LogLevel is the least severe level logged: debug, info, warn or error.
+	// At debug every processed receipt is logged with its breakdown.
+	LogLevel string `yaml:"logLevel"`
+
+	// AuditPath is the file scoring audit entries are appended to. They are
+	// written to stdout when it is empty.
+	AuditPath string `yaml:"auditPath"`
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:          ":8080",
+		Store:               StoreConfig{Backend: backendMemory, SnapshotInterval: defaultSnapshotInterval},
+		Rules:               DefaultRuleSet(),
+		Limits:              DefaultValidationLimits(),
+		RateLimitRPS:        defaultRateLimitRPS,
+		RateLimitBurst:      defaultRateLimitBurst,
+		RequestTimeout:      defaultRequestTimeout,
+		MaxBodyBytes:        defaultMaxBodyBytes,
+		LeaderboardMaxLimit: defaultMaxLeaderboardLimit,
+		CORSAllowedOrigins:  []string{"*"},
+		LogLevel:            "info",
+	}
+}
+
+// LoadConfig starts from the defaults, applies the YAML file at path when
+// one is given, and then applies environment variables, which take
+// precedence over the file.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// applyEnv overrides settings with any environment variables that are set
+func (config *Config) applyEnv() error {
+	if value := os.Getenv("LISTEN_ADDR"); value != "" {
+		config.ListenAddr = value
+	}
+
+	// Redis wins when both persistent stores are configured
+	if value := os.Getenv("RECEIPT_DB_PATH"); value != "" {
+		config.Store.Backend = backendBolt
+		config.Store.DBPath = value
+	}
+	if value := os.Getenv("REDIS_URL"); value != "" {
+		config.Store.Backend = backendRedis
+		config.Store.RedisURL = value
+	}
+	if value := os.Getenv("DEDUP_RECEIPTS"); value != "" {
+		config.Store.Dedup = value == "true"
+	}
+
+	if value := os.Getenv("SNAPSHOT_PATH"); value != "" {
+		config.Store.SnapshotPath = value
+	}
+
+	var err error
+	if config.Store.TTL, err = envDuration("RECEIPT_TTL", config.Store.TTL, true); err != nil {
+		return err
+	}
+	if config.Store.SnapshotInterval, err = envDuration("SNAPSHOT_INTERVAL", config.Store.SnapshotInterval, false); err != nil {
+		return err
+	}
+	if config.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", config.RequestTimeout, false); err != nil {
+		return err
+	}
+
+	// A ruleset file replaces the rules from the config file entirely
+	if value := os.Getenv("RULES_PATH"); value != "" {
+		if config.Rules, err = LoadRuleSet(value); err != nil {
+			return err
+		}
+	}
+
+	for name, setting := range map[string]*int64{
+		"RATE_LIMIT_RPS":        &config.RateLimitRPS,
+		"RATE_LIMIT_BURST":      &config.RateLimitBurst,
+		"MAX_BODY_BYTES":        &config.MaxBodyBytes,
+		"LEADERBOARD_MAX_LIMIT": &config.LeaderboardMaxLimit,
+	} {
+		if *setting, err = envInt64(name, *setting); err != nil {
+			return err
+		}
+	}
+
+	for name, setting := range map[string]*int{
+		"MAX_RECEIPTS":           &config.Store.MaxReceipts,
+		"MAX_ITEMS":              &config.Limits.MaxItems,
+		"MAX_RETAILER_LENGTH":    &config.Limits.MaxRetailerLength,
+		"MAX_DESCRIPTION_LENGTH": &config.Limits.MaxDescriptionLength,
+		"MAX_LABEL_LENGTH":       &config.Limits.MaxLabelLength,
+		"MAX_ITEM_PRICE":         &config.Limits.MaxItemPrice,
+	} {
+		value, err := envInt64(name, int64(*setting))
+		if err != nil {
+			return err
+		}
+		*setting = int(value)
+	}
+
+	if value := os.Getenv("CORS_ALLOWED_ORIGINS"); value != "" {
+		config.CORSAllowedOrigins = parseAllowedOrigins(value)
+	}
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		config.TrustedProxies = splitTrustedProxies(value)
+	}
+	if value := os.Getenv("API_TOKEN"); value != "" {
+		config.APIToken = value
+	}
+	if value := os.Getenv("ADMIN_SECRET"); value != "" {
+		config.AdminSecret = value
+	}
+	if value := os.Getenv("WEBHOOK_URL"); value != "" {
+		config.WebhookURL = value
+	}
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		config.LogLevel = value
+	}
+	if value := os.Getenv("AUDIT_PATH"); value != "" {
+		config.AuditPath = value
+	}
+	if value := os.Getenv("REJECT_FUTURE_DATES"); value != "" {
+		config.Limits.RejectFutureDates = value == "true"
+	}
+	if value := os.Getenv("RECEIPT_TIME_ZONE"); value != "" {
+		config.Limits.TimeZone = value
+	}
+	if value := os.Getenv("TLS_CERT_FILE"); value != "" {
+		config.TLS.CertFile = value
+	}
+	if value := os.Getenv("TLS_KEY_FILE"); value != "" {
+		config.TLS.KeyFile = value
+	}
+	if value := os.Getenv("TLS_REDIRECT_ADDR"); value != "" {
+		config.TLS.RedirectAddr = value
+	}
+	return nil
+}
+
+// Validate reports whether the server can start with this Config
+func (config Config) Validate() error {
+	switch config.Store.Backend {
+	case backendMemory:
+	case backendBolt:
+		if config.Store.DBPath == "" {
+			return errors.New("store.dbPath is required for the bolt backend")
+		}
+	case backendRedis:
+		if config.Store.RedisURL == "" {
+			return errors.New("store.redisURL is required for the redis backend")
+		}
+	default:
+		return fmt.Errorf("store.backend must be %s, %s or %s, got %q", backendMemory, backendBolt, backendRedis, config.Store.Backend)
+	}
+
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return errors.New("tls.certFile and tls.keyFile must be set together")
+	}
+	if config.TLS.RedirectAddr != "" && !config.TLS.Enabled() {
+		return errors.New("tls.redirectAddr requires tls.certFile and tls.keyFile")
+	}
+
+	if config.Store.TTL < 0 {
+		return errors.New("store.ttl must not be negative")
+	}
+	if config.Store.TTL > 0 && config.Store.Backend != backendMemory {
+		return errors.New("store.ttl only applies to the memory backend")
+	}
+	if config.Store.SnapshotPath != "" && config.Store.Backend != backendMemory {
+		return errors.New("store.snapshotPath only applies to the memory backend")
+	}
+	if config.Store.MaxReceipts < 0 {
+		return errors.New("store.maxReceipts must not be negative")
+	}
+	if config.Store.MaxReceipts > 0 && config.Store.Backend != backendMemory {
+		return errors.New("store.maxReceipts only applies to the memory backend")
+	}
+	if config.Store.SnapshotInterval <= 0 {
+		return errors.New("store.snapshotInterval must be positive")
+	}
+	if config.RequestTimeout <= 0 {
+		return errors.New("requestTimeout must be positive")
+	}
+	if config.RateLimitRPS <= 0 || config.RateLimitBurst <= 0 {
+		return errors.New("rateLimitRPS and rateLimitBurst must be positive")
+	}
+	if config.MaxBodyBytes <= 0 {
+		return errors.New("maxBodyBytes must be positive")
+	}
+	if _, err := parseTrustedProxies(config.TrustedProxies); err != nil {
+		return err
+	}
+	if config.LeaderboardMaxLimit <= 0 {
+		return errors.New("leaderboardMaxLimit must be positive")
+	}
+	if config.Limits.MaxItems <= 0 || config.Limits.MaxRetailerLength <= 0 || config.Limits.MaxDescriptionLength <= 0 || config.Limits.MaxLabelLength <= 0 {
+		return errors.New("limits.maxItems, limits.maxRetailerLength, limits.maxDescriptionLength and limits.maxLabelLength must be positive")
+	}
+	if config.Limits.MaxItemPrice < 0 {
+		return errors.New("limits.maxItemPrice must not be negative")
+	}
+	if _, err := time.LoadLocation(config.Limits.TimeZone); err != nil {
+		return fmt.Errorf("limits.timeZone %q is not a known time zone", config.Limits.TimeZone)
+	}
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		return err
+	}
+	for name, path := range config.RuleSets {
+		if name == "" || path == "" {
+			return errors.New("ruleSets entries need a name and a ruleset file")
+		}
+	}
+	return config.Rules.Validate()
+}
+
+// envInt64 reads a positive integer from the environment, returning fallback
+// when the variable is unset.
+func envInt64(name string, fallback int64) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+// envDuration reads a duration such as "24h" from the environment, returning
+// fallback when the variable is unset. Zero is only accepted when allowZero
+// is set.
+func envDuration(name string, fallback time.Duration, allowZero bool) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 || (parsed == 0 && !allowZero) {
+		kind := "positive"
+		if allowZero {
+			kind = "non-negative"
+		}
+		return 0, fmt.Errorf("%s must be a %s duration, got %q", name, kind, value)
+	}
+	return parsed, nil
+}
+
+// applyScoringConfig installs the rules, named rulesets and validation limits
+// of config, so that the server and the score subcommand validate and score
+// receipts the same way
+func applyScoringConfig(config Config) error {
+	setActiveRules(config.Rules)
+	for name, path := range config.RuleSets {
+		rules, err := LoadRuleSet(path)
+		if err != nil {
+			return fmt.Errorf("failed to load ruleset %s: %w", name, err)
+		}
+		registerRuleSet(name, rules)
+	}
+	validationLimits = config.Limits
+	receiptLocation, _ = time.LoadLocation(config.Limits.TimeZone)
+	return nil
+}
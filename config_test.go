@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clearConfigEnv blanks every variable LoadConfig reads so the host
+// environment can't leak into a test
+func clearConfigEnv(t *testing.T) {
+	for _, name := range []string{
+		"LISTEN_ADDR", "RECEIPT_DB_PATH", "REDIS_URL", "DEDUP_RECEIPTS", "RECEIPT_TTL",
+		"REQUEST_TIMEOUT", "RULES_PATH", "RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "MAX_BODY_BYTES",
+		"LEADERBOARD_MAX_LIMIT", "CORS_ALLOWED_ORIGINS", "API_TOKEN", "ADMIN_SECRET", "WEBHOOK_URL",
+		"MAX_ITEMS", "MAX_RETAILER_LENGTH", "MAX_DESCRIPTION_LENGTH", "SNAPSHOT_PATH", "SNAPSHOT_INTERVAL",
+		"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_REDIRECT_ADDR", "MAX_RECEIPTS",
+		"REJECT_FUTURE_DATES", "RECEIPT_TIME_ZONE", "LOG_LEVEL", "MAX_ITEM_PRICE", "AUDIT_PATH",
+		"TRUSTED_PROXIES",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+
+	// Test case 1: No config file and no env vars gives the defaults
+	config, err := LoadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), config)
+
+	// Test case 2: File values override the defaults, including partial rules
+	path := filepath.Join(dir, "config.yaml")
+	err = os.WriteFile(path, []byte(`
+listenAddr: ":9090"
+store:
+  backend: bolt
+  dbPath: receipts.db
+rateLimitRPS: 50
+rules:
+  oddDayPoints: 0
+`), 0600)
+	assert.NoError(t, err)
+
+	config, err = LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", config.ListenAddr)
+	assert.Equal(t, StoreConfig{Backend: backendBolt, DBPath: "receipts.db", SnapshotInterval: defaultSnapshotInterval}, config.Store)
+	assert.Equal(t, int64(50), config.RateLimitRPS)
+	assert.Equal(t, int64(defaultRateLimitBurst), config.RateLimitBurst)
+
+	expectedRules := DefaultRuleSet()
+	expectedRules.OddDayPoints = 0
+	assert.Equal(t, expectedRules, config.Rules)
+
+	// Test case 3: Env vars override file values
+	t.Setenv("LISTEN_ADDR", ":7070")
+	t.Setenv("RATE_LIMIT_RPS", "5")
+	t.Setenv("REDIS_URL", "redis://localhost:6379/0")
+	t.Setenv("MAX_ITEMS", "50")
+	t.Setenv("MAX_ITEM_PRICE", "500")
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+	t.Setenv("TLS_KEY_FILE", "key.pem")
+	t.Setenv("TLS_REDIRECT_ADDR", ":8081")
+	t.Setenv("REJECT_FUTURE_DATES", "true")
+	t.Setenv("RECEIPT_TIME_ZONE", "America/Chicago")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("AUDIT_PATH", "audit.log")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+	config, err = LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":7070", config.ListenAddr)
+	assert.Equal(t, int64(5), config.RateLimitRPS)
+	assert.Equal(t, backendRedis, config.Store.Backend)
+	assert.Equal(t, "redis://localhost:6379/0", config.Store.RedisURL)
+	assert.Equal(t, 50, config.Limits.MaxItems)
+	assert.Equal(t, 500, config.Limits.MaxItemPrice)
+	assert.True(t, config.Limits.RejectFutureDates)
+	assert.Equal(t, "America/Chicago", config.Limits.TimeZone)
+	assert.Equal(t, "debug", config.LogLevel)
+	assert.Equal(t, "audit.log", config.AuditPath)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, config.TrustedProxies)
+	assert.Equal(t, TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", RedirectAddr: ":8081"}, config.TLS)
+
+	// Test case 4: A ruleset file replaces the rules from the config file
+	rulesPath := filepath.Join(dir, "rules.json")
+	err = os.WriteFile(rulesPath, []byte(`{"evenDayPoints": 12}`), 0600)
+	assert.NoError(t, err)
+	t.Setenv("RULES_PATH", rulesPath)
+
+	config, err = LoadConfig(path)
+	assert.NoError(t, err)
+	expectedRules = DefaultRuleSet()
+	expectedRules.EvenDayPoints = 12
+	assert.Equal(t, expectedRules, config.Rules)
+
+	// Test case 5: Invalid env vars are rejected even when the file is valid
+	t.Setenv("RATE_LIMIT_RPS", "zero")
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+
+	// Test case 6: Missing file
+	clearConfigEnv(t)
+	_, err = LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	// Test case 7: The memory backend reads its expiry from the environment
+	t.Setenv("RECEIPT_TTL", "1h")
+	config, err = LoadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, StoreConfig{Backend: backendMemory, TTL: time.Hour, SnapshotInterval: defaultSnapshotInterval}, config.Store)
+}
+
+func TestLoadConfigInvalidFile(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+
+	for i, contents := range []string{
+		// Test case 1: Unknown keys are rejected
+		"listenAddress: \":9090\"\n",
+		// Test case 2: Unknown store backend
+		"store:\n  backend: postgres\n",
+		// Test case 3: The bolt backend needs a database path
+		"store:\n  backend: bolt\n",
+		// Test case 4: Limits must be positive
+		"maxBodyBytes: 0\n",
+		// Test case 5: Snapshots only apply to the memory backend
+		"store:\n  backend: bolt\n  dbPath: receipts.db\n  snapshotPath: snapshot.json\n",
+		// Test case 6: Rules are validated
+		"rules:\n  timeWindowStart: \"16:00\"\n  timeWindowEnd: \"14:00\"\n",
+		// Test case 7: A certificate needs its key
+		"tls:\n  certFile: cert.pem\n",
+		// Test case 8: Redirecting to HTTPS needs TLS
+		"tls:\n  redirectAddr: \":8081\"\n",
+		// Test case 9: Named rulesets need a file
+		"ruleSets:\n  v2: \"\"\n",
+		// Test case 10: The time zone must exist
+		"limits:\n  timeZone: Mars/Olympus_Mons\n",
+		// Test case 11: Unknown log levels are rejected
+		"logLevel: verbose\n",
+		// Test case 12: The price cap can't be negative
+		"limits:\n  maxItemPrice: -1\n",
+		// Test case 13: Trusted proxies must be addresses or ranges
+		"trustedProxies: [\"proxy.internal\"]\n",
+		// Test case 14: Expiry only applies to the memory backend
+		"store:\n  backend: redis\n  redisURL: redis://localhost:6379\n  ttl: 24h\n",
+	} {
+		path := filepath.Join(dir, "config.yaml")
+		err := os.WriteFile(path, []byte(contents), 0600)
+		assert.NoError(t, err)
+
+		_, err = LoadConfig(path)
+		assert.Error(t, err, "test case %d", i+1)
+	}
+
+	// Test case 15: An empty file gives the defaults
+	path := filepath.Join(dir, "empty.yaml")
+	err := os.WriteFile(path, nil, 0600)
+	assert.NoError(t, err)
+
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), config)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAllowedOrigins(t *testing.T) {
+	assert.Equal(t, []string{"*"}, parseAllowedOrigins(""))
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, parseAllowedOrigins("https://a.example, https://b.example,"))
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	// Test case 1: Preflight requests are answered with 204
+	handler := CORSMiddleware([]string{"*"}, router)
+
+	req, _ := http.NewRequest("OPTIONS", "/receipts/process", nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "POST")
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "PUT")
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+	for _, header := range []string{"Content-Type", "Authorization", "X-Admin-Secret", "If-None-Match"} {
+		assert.Contains(t, rr.Header().Get("Access-Control-Allow-Headers"), header)
+	}
+
+	// Test case 2: Regular requests get the allow origin header too
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	// Test case 3: Only configured origins are allowed
+	handler = CORSMiddleware([]string{"https://app.example"}, router)
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, "https://app.example", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", rr.Header().Get("Vary"))
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// parseReceiptCSV reads a receipt in CSV form. Every row has exactly two
+// columns. The first four rows hold the receipt fields as name,value pairs
+// and may appear in any order:
+//
+//	retailer,Target
+//	purchaseDate,2022-01-01
+//	purchaseTime,13:01
+//	total,35.35
+//
+// Every following row is one item as description,price:
+//
+//	Mountain Dew 12PK,6.49
+//	Emils Cheese Pizza,12.25
+//
+// Values are used as-is, so descriptions keep any surrounding whitespace.
+// The parsed receipt still needs to be checked with validateReceipt.
+func parseReceiptCSV(r io.Reader) (Receipt, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	var receipt Receipt
+	fields := map[string]*string{
+		"retailer":     &receipt.Retailer,
+		"purchaseDate": &receipt.PurchaseDate,
+		"purchaseTime": &receipt.PurchaseTime,
+		"total":        &receipt.Total,
+	}
+
+	seen := map[string]bool{}
+	for row := 0; row < len(fields); row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return receipt, fmt.Errorf("expected %d receipt field rows, got %d", len(fields), row)
+		}
+		if err != nil {
+			return receipt, err
+		}
+
+		field, known := fields[record[0]]
+		if !known {
+			return receipt, fmt.Errorf("row %d: unknown receipt field %q", row+1, record[0])
+		}
+		if seen[record[0]] {
+			return receipt, fmt.Errorf("row %d: duplicate receipt field %q", row+1, record[0])
+		}
+		seen[record[0]] = true
+		*field = record[1]
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return receipt, nil
+		}
+		if err != nil {
+			return receipt, err
+		}
+		receipt.Items = append(receipt.Items, Item{ShortDescription: record[0], Price: record[1]})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const targetReceiptCSV = `purchaseDate,2022-01-01
+retailer,Target
+purchaseTime,13:01
+total,35.35
+Mountain Dew 12PK,6.49
+Emils Cheese Pizza,12.25
+Knorr Creamy Chicken,1.26
+Doritos Nacho Cheese,3.35
+"   Klarbrunn 12-PK 12 FL OZ  ",12.00
+`
+
+func TestParseReceiptCSV(t *testing.T) {
+	// Test case 1: The README example
+	receipt, err := parseReceiptCSV(strings.NewReader(targetReceiptCSV))
+	assert.NoError(t, err)
+	assert.Equal(t, Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+		Total: "35.35",
+	}, receipt)
+	assert.Equal(t, 28, calculatePoints(receipt, DefaultRuleSet()))
+
+	// Test case 2: Malformed input
+	invalid := map[string]string{
+		"missing fields":  "retailer,Target\ntotal,1.25\n",
+		"unknown field":   "retailer,Target\nstore,Target\npurchaseTime,13:01\ntotal,1.25\n",
+		"duplicate field": "retailer,Target\nretailer,Target\npurchaseTime,13:01\ntotal,1.25\n",
+		"wrong column count": "retailer,Target\npurchaseDate,2022-01-01\npurchaseTime,13:01\ntotal,1.25\n" +
+			"Pepsi,1.25,extra\n",
+	}
+	for name, input := range invalid {
+		_, err := parseReceiptCSV(strings.NewReader(input))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestProcessReceiptCSV(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: A CSV receipt is processed like a JSON one
+	req, _ := http.NewRequest("POST", "/receipts/process", strings.NewReader(targetReceiptCSV))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response ReceiptResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	points, exists := server.store.GetPoints(context.Background(), response.ID)
+	assert.True(t, exists)
+	assert.Equal(t, 28, points)
+
+	// Test case 2: Malformed CSV is rejected with the parse error
+	req, _ = http.NewRequest("POST", "/receipts/process", strings.NewReader("retailer,Target\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid receipt format: expected 4 receipt field rows, got 1", decodeError(t, rr).Error)
+
+	// Test case 3: Parsed CSV receipts are still validated
+	body := "retailer,Target\npurchaseDate,2022-01-01\npurchaseTime,13:01\ntotal,2.00\nPepsi,1.25\n"
+	req, _ = http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Total does not match sum of items", decodeError(t, rr).Error)
+
+	// Test case 4: JSON remains the default
+	reqBody, _ := json.Marshal(Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		Total:        "1.25",
+	})
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
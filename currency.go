@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultCurrency is assumed for receipts that don't name a currency
+const defaultCurrency = "USD"
+
+// currencyPattern matches an ISO 4217 alphabetic currency code
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// commaDecimalCurrencies are commonly written with a comma as the decimal
+// separator, so amounts like "12,25" are accepted for them
+var commaDecimalCurrencies = map[string]bool{
+	"ARS": true,
+	"BRL": true,
+	"CZK": true,
+	"DKK": true,
+	"EUR": true,
+	"HUF": true,
+	"IDR": true,
+	"NOK": true,
+	"PLN": true,
+	"RON": true,
+	"SEK": true,
+	"TRY": true,
+}
+
+// currencyMinorUnits lists the ISO 4217 currencies whose amounts don't have
+// two decimal places, by the number of decimal places they do have. Every
+// other currency uses two.
+var currencyMinorUnits = map[string]int{
+	"BIF": 0,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"ISK": 0,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"PYG": 0,
+	"RWF": 0,
+	"UGX": 0,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+	"BHD": 3,
+	"IQD": 3,
+	"JOD": 3,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+// amountPatterns match an amount with exactly the given number of decimal
+// places, keyed by every value in currencyMinorUnits and the default of two
+var amountPatterns = map[int]*regexp.Regexp{
+	0: regexp.MustCompile(`^\d+$`),
+	2: regexp.MustCompile(`^\d+\.\d{2}$`),
+	3: regexp.MustCompile(`^\d+\.\d{3}$`),
+}
+
+var errBadAmount = errors.New("amount has the wrong number of decimal places")
+
+// maxAmountUnits bounds every amount, in whole units of its currency, so that
+// totals, price sums and the scoring math on minor units can't overflow int64
+const maxAmountUnits = 1_000_000_000
+
+var errAmountTooLarge = fmt.Errorf("amount must be less than %d", maxAmountUnits)
+
+// currencyCode returns the receipt's currency, defaulting to USD
+func (r Receipt) currencyCode() string {
+	if r.Currency == "" {
+		return defaultCurrency
+	}
+	return r.Currency
+}
+
+// decimalAmount normalizes an amount from the receipt to use "." as the
+// decimal separator, so that validation and scoring see the plain number
+// whatever the currency's convention.
+func (r Receipt) decimalAmount(amount string) string {
+	if commaDecimalCurrencies[r.currencyCode()] {
+		return strings.Replace(amount, ",", ".", 1)
+	}
+	return amount
+}
+
+// minorUnits returns the number of decimal places in the receipt's currency
+func (r Receipt) minorUnits() int {
+	if digits, ok := currencyMinorUnits[r.currencyCode()]; ok {
+		return digits
+	}
+	return 2
+}
+
+// minorAmount parses an amount from the receipt into its currency's minor
+// units, so "35.35" USD is 3535 cents and "1200" JPY is 1200 yen
+func (r Receipt) minorAmount(amount string) (int64, error) {
+	return parseMinorUnits(r.decimalAmount(amount), r.minorUnits())
+}
+
+// parseMinorUnits converts an amount with exactly digits decimal places into
+// an integer count of minor units, without floating point rounding errors.
+// Amounts of maxAmountUnits or more are rejected.
+func parseMinorUnits(amount string, digits int) (int64, error) {
+	pattern, ok := amountPatterns[digits]
+	if !ok || !pattern.MatchString(amount) {
+		return 0, errBadAmount
+	}
+	value, err := strconv.ParseInt(strings.Replace(amount, ".", "", 1), 10, 64)
+	if err != nil || value >= maxAmountUnits*minorUnitsPerMajor(digits) {
+		return 0, errAmountTooLarge
+	}
+	return value, nil
+}
+
+// formatMinorUnits formats a count of minor units as an amount with digits
+// decimal places, reversing parseMinorUnits
+func formatMinorUnits(value int64, digits int) string {
+	if digits == 0 {
+		return strconv.FormatInt(value, 10)
+	}
+	scale := minorUnitsPerMajor(digits)
+	return fmt.Sprintf("%d.%0*d", value/scale, digits, value%scale)
+}
+
+// minorUnitsPerMajor returns 10 to the power of digits, the number of minor
+// units in one whole unit of the currency
+func minorUnitsPerMajor(digits int) int64 {
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	return scale
+}
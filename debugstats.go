@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DebugStats keeps a few counters in memory for deployments that don't run
+// Prometheus. They are plain atomics, so recording never contends on a lock,
+// and they start from zero on every restart.
+type DebugStats struct {
+	requests          atomic.Int64
+	receiptsProcessed atomic.Int64
+	pointsAwarded     atomic.Int64
+
+	// errors maps an HTTP status code of 400 or above to an *atomic.Int64
+	errors sync.Map
+
+	// webhook is reported when a webhook is configured
+	webhook *WebhookNotifier
+}
+
+// DebugStatsResponse is the JSON served at /debug/stats
+type DebugStatsResponse struct {
+	Requests          int64 `json:"requests"`
+	ReceiptsProcessed int64 `json:"receiptsProcessed"`
+	PointsAwarded     int64 `json:"pointsAwarded"`
+
+	// Errors counts error responses by status code, e.g. {"400": 2}
+	Errors map[string]int64 `json:"errors"`
+
+	// Webhook is the delivery circuit breaker, omitted without a webhook
+	Webhook *WebhookBreakerStats `json:"webhook,omitempty"`
+}
+
+func NewDebugStats() *DebugStats {
+	return &DebugStats{}
+}
+
+// Middleware counts every request and each error response by status code
+func (ds *DebugStats) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		ds.requests.Add(1)
+		if recorder.status >= http.StatusBadRequest {
+			counter, _ := ds.errors.LoadOrStore(recorder.status, new(atomic.Int64))
+			counter.(*atomic.Int64).Add(1)
+		}
+	})
+}
+
+// Snapshot returns the current value of every counter
+func (ds *DebugStats) Snapshot() DebugStatsResponse {
+	response := DebugStatsResponse{
+		Requests:          ds.requests.Load(),
+		ReceiptsProcessed: ds.receiptsProcessed.Load(),
+		PointsAwarded:     ds.pointsAwarded.Load(),
+		Errors:            map[string]int64{},
+	}
+	ds.errors.Range(func(status, counter any) bool {
+		response.Errors[strconv.Itoa(status.(int))] = counter.(*atomic.Int64).Load()
+		return true
+	})
+	if ds.webhook != nil {
+		breaker := ds.webhook.BreakerStats()
+		response.Webhook = &breaker
+	}
+	return response
+}
+
+// TrackWebhook includes the notifier's circuit breaker in the snapshot. It
+// must be called before the stats are served.
+func (ds *DebugStats) TrackWebhook(notifier *WebhookNotifier) {
+	ds.webhook = notifier
+}
+
+func (ds *DebugStats) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ds.Snapshot())
+}
+
+// observe counts a newly stored receipt
+func (ds *DebugStats) observe(scored ScoredReceipt) {
+	ds.receiptsProcessed.Add(1)
+	ds.pointsAwarded.Add(int64(scored.Points))
+}
+
+// InstrumentStore wraps the store so that processed receipts are counted
+func (ds *DebugStats) InstrumentStore(store Store) Store {
+	return onStored(store, ds.observe)
+}
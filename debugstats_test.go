@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugStats(t *testing.T) {
+	debugStats := NewDebugStats()
+	server := NewServer(debugStats.InstrumentStore(NewReceiptStore()))
+
+	router := mux.NewRouter()
+	router.Use(debugStats.Middleware)
+	router.HandleFunc("/receipts/process", server.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+	router.HandleFunc("/debug/stats", debugStats.Handler).Methods("GET")
+
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+	expectedPoints := calculatePoints(receipt, currentRules())
+
+	// Test case 1: Counters start at zero
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/stats", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var stats DebugStatsResponse
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	assert.Equal(t, int64(0), stats.ReceiptsProcessed)
+	assert.Equal(t, int64(0), stats.PointsAwarded)
+	assert.Empty(t, stats.Errors)
+	assert.Nil(t, stats.Webhook)
+
+	// Test case 2: Two valid receipts, one invalid receipt and one unknown id
+	reqBody, _ := json.Marshal(receipt)
+	for i := 0; i < 2; i++ {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(`{"retailer": ""}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/missing/points", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/stats", nil))
+	stats = DebugStatsResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	assert.Equal(t, int64(2), stats.ReceiptsProcessed)
+	assert.Equal(t, int64(2*expectedPoints), stats.PointsAwarded)
+	assert.Equal(t, map[string]int64{"400": 1, "404": 1}, stats.Errors)
+
+	// The first stats request plus the four above
+	assert.Equal(t, int64(5), stats.Requests)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ExportRecord is one line of the newline-delimited JSON read by /import and
+// written by /export
+type ExportRecord struct {
+	ID        string            `json:"id"`
+	Receipt   Receipt           `json:"receipt"`
+	Points    int               `json:"points"`
+	Breakdown []PointsBreakdown `json:"breakdown,omitempty"`
+}
+
+// breakdown returns the record's breakdown, or a single entry for all of its
+// points when the record has none
+func (record ExportRecord) breakdown() []PointsBreakdown {
+	if record.Breakdown == nil {
+		return []PointsBreakdown{{Rule: "imported", Description: "points imported without a breakdown", Points: record.Points}}
+	}
+	return record.Breakdown
+}
+
+type ImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+// ExportHandler streams every stored receipt as NDJSON. Receipts are fetched
+// one at a time from a snapshot of the ids, so no lock is held while writing
+// and memory use doesn't grow with the store.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, id := range s.store.ReceiptIDs(r.Context()) {
+		// Skip receipts deleted since the ids were listed
+		receipt, exists := s.store.GetReceipt(r.Context(), id)
+		if !exists {
+			continue
+		}
+		points, exists := s.store.GetPoints(r.Context(), id)
+		if !exists {
+			continue
+		}
+		breakdown, exists := s.store.GetBreakdown(r.Context(), id)
+		if !exists {
+			continue
+		}
+
+		if err := encoder.Encode(ExportRecord{ID: id, Receipt: receipt, Points: points, Breakdown: breakdown}); err != nil {
+			return
+		}
+	}
+}
+
+// ImportHandler stores every record of an NDJSON export under its original
+// id, points and breakdown, replacing receipts that already exist. Negative
+// points, and breakdowns that don't sum to the points, are rejected. Records
+// are stored as they are read, so those before an invalid record are kept.
+// Each record may be up to maxBodyBytes long, but the body as a whole is
+// unlimited.
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	body := newRecordLimitReader(r.Body, s.maxBodyBytes)
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	imported := 0
+	for {
+		var record ExportRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		body.reset()
+
+		position := imported + 1
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import record %d is larger than %d bytes", position, s.maxBodyBytes))
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid import record %d: %s", position, strings.TrimPrefix(err.Error(), "json: ")))
+			return
+		}
+		if record.ID == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Import record %d is missing id", position))
+			return
+		}
+		if record.Points < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Import record %d has negative points", position))
+			return
+		}
+		if breakdownTotal(record.breakdown()) != record.Points {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Import record %d breakdown does not sum to its points", position))
+			return
+		}
+		if err := prepareReceipt(&record.Receipt); err != nil {
+			_, message := validationStatus(err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid import record %d: %s", position, message))
+			return
+		}
+
+		if err := s.store.ImportReceipt(r.Context(), record.ID, record.Receipt, record.Points, record.breakdown()); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to import receipts")
+			return
+		}
+		imported++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ImportResponse{Imported: imported})
+}
+
+// importProgressInterval is how many records /import/stream processes between
+// progress lines
+const importProgressInterval = 100
+
+// maxImportStreamErrors bounds how many per-line errors the import summary
+// lists; later failures are still counted
+const maxImportStreamErrors = 100
+
+// ImportStreamProgress is written as a line of NDJSON while a stream is
+// imported, and once more, with Done set and the errors, at the end
+type ImportStreamProgress struct {
+	Processed int  `json:"processed"`
+	Stored    int  `json:"stored"`
+	Failed    int  `json:"failed"`
+	Done      bool `json:"done"`
+}
+
+// ImportLineError reports why the receipt on one line of a stream was not
+// stored. Lines count receipts from 1, so they match the line numbers of
+// well-formed NDJSON.
+type ImportLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportStreamSummary struct {
+	ImportStreamProgress
+	Errors []ImportLineError `json:"errors"`
+}
+
+// ImportStreamHandler stores every receipt of an NDJSON stream under a new id,
+// decoding one receipt at a time so the body is never held in memory. Invalid
+// receipts are skipped and reported in the summary. A line that isn't JSON,
+// or a receipt longer than maxBodyBytes, ends the stream, since the decoder
+// can't find the start of the next one.
+func (s *Server) ImportStreamHandler(w http.ResponseWriter, r *http.Request) {
+	onConflict, conflictErr := onConflictMode(r)
+	if conflictErr != nil {
+		writeJSONError(w, conflictErr.status, conflictErr.message)
+		return
+	}
+
+	body := newRecordLimitReader(r.Body, s.maxBodyBytes)
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	controller := http.NewResponseController(w)
+
+	summary := ImportStreamSummary{Errors: []ImportLineError{}}
+	fail := func(message string) {
+		summary.Failed++
+		if len(summary.Errors) < maxImportStreamErrors {
+			summary.Errors = append(summary.Errors, ImportLineError{Line: summary.Processed, Error: message})
+		}
+	}
+
+	for {
+		var receipt Receipt
+		err := decoder.Decode(&receipt)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		body.reset()
+		summary.Processed++
+
+		var syntaxErr *json.SyntaxError
+		switch {
+		case err == nil:
+			if err := prepareReceipt(&receipt); err != nil {
+				_, message := validationStatus(err)
+				fail(message)
+			} else if _, message := s.storeReceipt(r.Context(), receipt, onConflict); message != "" {
+				fail(message)
+			} else {
+				summary.Stored++
+			}
+		case skippableDecodeError(err):
+			fail("Invalid receipt format: " + strings.TrimPrefix(err.Error(), "json: "))
+		case isBodyTooLarge(err):
+			fail(fmt.Sprintf("Receipt is larger than %d bytes", s.maxBodyBytes))
+		case errors.As(err, &syntaxErr):
+			fail("Invalid JSON: " + err.Error())
+		default:
+			fail("Failed to read the receipt stream")
+		}
+		if err != nil && !skippableDecodeError(err) {
+			break
+		}
+
+		if summary.Processed%importProgressInterval == 0 {
+			if err := encoder.Encode(summary.ImportStreamProgress); err != nil {
+				return
+			}
+			controller.Flush()
+		}
+	}
+
+	summary.Done = true
+	encoder.Encode(summary)
+}
+
+// skippableDecodeError reports whether decoding failed after the whole value
+// was read, such as for a field of the wrong type, so the next value can still
+// be decoded
+func skippableDecodeError(err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &typeErr) || strings.HasPrefix(err.Error(), "json: unknown field")
+}
+
+// recordLimitReader caps the bytes read for each record of an NDJSON body,
+// so that a single oversized record can't exhaust memory while the body as a
+// whole stays unlimited. Call reset once a record has been decoded.
+type recordLimitReader struct {
+	reader    io.Reader
+	limit     int64
+	remaining int64
+}
+
+func newRecordLimitReader(reader io.Reader, limit int64) *recordLimitReader {
+	return &recordLimitReader{reader: reader, limit: limit, remaining: limit}
+}
+
+func (rl *recordLimitReader) Read(p []byte) (int, error) {
+	if rl.remaining <= 0 {
+		return 0, &http.MaxBytesError{Limit: rl.limit}
+	}
+	if int64(len(p)) > rl.remaining {
+		p = p[:rl.remaining]
+	}
+	n, err := rl.reader.Read(p)
+	rl.remaining -= int64(n)
+	return n, err
+}
+
+// reset starts the budget for the next record. Bytes the decoder has already
+// buffered past the previous record were counted against it, so the next
+// record can always read its remaining bytes.
+func (rl *recordLimitReader) reset() {
+	rl.remaining = rl.limit
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := NewReceiptStore()
+	sourceRouter := newAdminRouter(source)
+
+	morning := validReceipt()
+	morning.PurchaseTime = "08:13"
+	ids := []string{
+		source.AddReceipt(context.Background(), validReceipt()),
+		source.AddReceipt(context.Background(), morning),
+	}
+	deleted := source.AddReceipt(context.Background(), validReceipt())
+	source.DeleteReceipt(context.Background(), deleted)
+
+	// Test case 1: Export writes one record per stored receipt
+	req, _ := http.NewRequest("GET", "/export", nil)
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr := httptest.NewRecorder()
+	sourceRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+
+	export := rr.Body.Bytes()
+	records := []ExportRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(export))
+	for scanner.Scan() {
+		var record ExportRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		assert.Contains(t, ids, record.ID)
+		assert.NotEqual(t, deleted, record.ID)
+	}
+
+	// Test case 2: Importing the export reproduces ids, receipts, points and breakdowns
+	target := NewReceiptStore()
+	targetRouter := newAdminRouter(target)
+
+	req, _ = http.NewRequest("POST", "/import", bytes.NewReader(export))
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	targetRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ImportResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Imported)
+
+	for _, id := range ids {
+		sourceReceipt, _ := source.GetReceipt(context.Background(), id)
+		sourcePoints, _ := source.GetPoints(context.Background(), id)
+		receipt, exists := target.GetReceipt(context.Background(), id)
+		assert.True(t, exists)
+		assert.Equal(t, sourceReceipt, receipt)
+		points, _ := target.GetPoints(context.Background(), id)
+		assert.Equal(t, sourcePoints, points)
+		sourceBreakdown, _ := source.GetBreakdown(context.Background(), id)
+		breakdown, _ := target.GetBreakdown(context.Background(), id)
+		assert.Equal(t, sourceBreakdown, breakdown)
+	}
+
+	// Test case 3: Imported points are kept even if the rules would differ
+	body := `{"id": "imported-1", "receipt": ` + string(mustMarshal(t, validReceipt())) + `, "points": 999}`
+	req, _ = http.NewRequest("POST", "/import", strings.NewReader(body))
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	targetRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	points, _ := target.GetPoints(context.Background(), "imported-1")
+	assert.Equal(t, 999, points)
+	breakdown, _ := target.GetBreakdown(context.Background(), "imported-1")
+	assert.Equal(t, 999, breakdownTotal(breakdown))
+}
+
+func TestImportInvalidRecords(t *testing.T) {
+	receiptJSON := string(mustMarshal(t, validReceipt()))
+	invalidReceipt := validReceipt()
+	invalidReceipt.Total = "1.00"
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		// Test case 1: Malformed JSON
+		{`{"id": "a", "receipt": ` + receiptJSON + `, "points": 1}` + "\n{not json}", "Invalid import record 2: invalid character 'n' looking for beginning of object key string"},
+		// Test case 2: Unknown fields
+		{`{"id": "a", "receipt": ` + receiptJSON + `, "score": 1}`, `Invalid import record 1: unknown field "score"`},
+		// Test case 3: Missing id
+		{`{"receipt": ` + receiptJSON + `, "points": 1}`, "Import record 1 is missing id"},
+		// Test case 4: Receipts are validated
+		{`{"id": "a", "receipt": ` + string(mustMarshal(t, invalidReceipt)) + `, "points": 1}`, "Invalid import record 1: Total does not match sum of items"},
+		// Test case 5: Negative points
+		{`{"id": "a", "receipt": ` + receiptJSON + `, "points": -5}`, "Import record 1 has negative points"},
+		// Test case 6: Breakdowns must sum to the points
+		{`{"id": "a", "receipt": ` + receiptJSON + `, "points": 10, "breakdown": [{"rule": "retailer", "description": "", "points": 6}]}`, "Import record 1 breakdown does not sum to its points"},
+	}
+
+	for _, tt := range tests {
+		router := newAdminRouter(NewReceiptStore())
+
+		req, _ := http.NewRequest("POST", "/import", strings.NewReader(tt.body))
+		req.Header.Set(AdminSecretHeader, "s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, tt.message, decodeError(t, rr).Error)
+	}
+}
+
+func TestImportStream(t *testing.T) {
+	receiptJSON := string(mustMarshal(t, validReceipt()))
+	invalidReceipt := validReceipt()
+	invalidReceipt.Total = "1.00"
+
+	// importStream posts body and returns the lines of the response
+	importStream := func(router *mux.Router, body string) []string {
+		req, _ := http.NewRequest("POST", "/import/stream", strings.NewReader(body))
+		req.Header.Set(AdminSecretHeader, "s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		return strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	}
+
+	// Test case 1: Invalid receipts are reported by line and the rest are stored
+	store := NewReceiptStore()
+	router := newAdminRouter(store)
+
+	body := strings.Join([]string{
+		receiptJSON,
+		string(mustMarshal(t, invalidReceipt)),
+		`{"retailer": 7}`,
+		`{"retailer": "Target", "score": 1}`,
+		receiptJSON,
+	}, "\n")
+	lines := importStream(router, body)
+	assert.Len(t, lines, 1)
+
+	var summary ImportStreamSummary
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &summary))
+	assert.Equal(t, ImportStreamProgress{Processed: 5, Stored: 2, Failed: 3, Done: true}, summary.ImportStreamProgress)
+	assert.Equal(t, []ImportLineError{
+		{Line: 2, Error: "Total does not match sum of items"},
+		{Line: 3, Error: "Invalid receipt format: cannot unmarshal number into Go struct field Receipt.retailer of type string"},
+		{Line: 4, Error: `Invalid receipt format: unknown field "score"`},
+	}, summary.Errors)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 2)
+
+	// Test case 2: Progress is written every importProgressInterval receipts
+	store = NewReceiptStore()
+	router = newAdminRouter(store)
+
+	lines = importStream(router, strings.Repeat(receiptJSON+"\n", importProgressInterval+1))
+	assert.Len(t, lines, 2)
+
+	var progress ImportStreamProgress
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &progress))
+	assert.Equal(t, ImportStreamProgress{Processed: importProgressInterval, Stored: importProgressInterval}, progress)
+	summary = ImportStreamSummary{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &summary))
+	assert.Equal(t, importProgressInterval+1, summary.Stored)
+	assert.True(t, summary.Done)
+	assert.Empty(t, summary.Errors)
+
+	// Test case 3: Malformed JSON ends the stream
+	store = NewReceiptStore()
+	router = newAdminRouter(store)
+
+	lines = importStream(router, receiptJSON+"\n{not json}\n"+receiptJSON)
+	summary = ImportStreamSummary{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &summary))
+	assert.Equal(t, ImportStreamProgress{Processed: 2, Stored: 1, Failed: 1, Done: true}, summary.ImportStreamProgress)
+	assert.Equal(t, []ImportLineError{
+		{Line: 2, Error: "Invalid JSON: invalid character 'n' looking for beginning of object key string"},
+	}, summary.Errors)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 1)
+
+	// Test case 4: Each receipt is limited to maxBodyBytes, but not the stream
+	store = NewReceiptStore()
+	server := NewServer(store)
+	server.adminSecret = "s3cret"
+	server.maxBodyBytes = int64(len(receiptJSON)) + 1
+	router = mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	oversized := validReceipt()
+	oversized.Retailer = strings.Repeat("A", len(receiptJSON))
+	lines = importStream(router, strings.Repeat(receiptJSON+"\n", 3)+string(mustMarshal(t, oversized))+"\n"+receiptJSON)
+	summary = ImportStreamSummary{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &summary))
+	assert.Equal(t, ImportStreamProgress{Processed: 4, Stored: 3, Failed: 1, Done: true}, summary.ImportStreamProgress)
+	assert.Equal(t, []ImportLineError{
+		{Line: 4, Error: fmt.Sprintf("Receipt is larger than %d bytes", server.maxBodyBytes)},
+	}, summary.Errors)
+}
+
+func TestExportImportRequireAdmin(t *testing.T) {
+	store := NewReceiptStore()
+	id := store.AddReceipt(context.Background(), validReceipt())
+	router := newAdminRouter(store)
+	body := `{"id": "` + id + `", "receipt": ` + string(mustMarshal(t, validReceipt())) + `, "points": 1000000}`
+
+	// Test case 1: Export, import and stream import reject missing or wrong secrets
+	for _, secret := range []string{"", "wrong"} {
+		for _, route := range []struct{ method, path string }{{"GET", "/export"}, {"POST", "/import"}, {"POST", "/import/stream"}} {
+			req, _ := http.NewRequest(route.method, route.path, strings.NewReader(body))
+			if secret != "" {
+				req.Header.Set(AdminSecretHeader, secret)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, route.path)
+			assert.Equal(t, "Invalid admin secret", decodeError(t, rr).Error)
+		}
+	}
+
+	// Test case 2: The stored points were not overwritten
+	points, _ := store.GetPoints(context.Background(), id)
+	assert.NotEqual(t, 1000000, points)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 1)
+}
+
+// newAdminRouter registers the routes of a server whose admin secret is
+// "s3cret"
+func newAdminRouter(store Store) *mux.Router {
+	server := NewServer(store)
+	server.adminSecret = "s3cret"
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	return router
+}
+
+func mustMarshal(t *testing.T, value any) []byte {
+	encoded, err := json.Marshal(value)
+	assert.NoError(t, err)
+	return encoded
+}
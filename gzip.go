@@ -0,0 +1,125 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gzipBody closes both the gzip reader and the underlying request body
+type gzipBody struct {
+	io.Reader
+	gzip *gzip.Reader
+	body io.ReadCloser
+}
+
+func (gb *gzipBody) Close() error {
+	gb.gzip.Close()
+	return gb.body.Close()
+}
+
+// gzipResponseWriter compresses the response body once the handler starts
+// writing one
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzip        *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	// Responses without a body must not get an empty gzip stream
+	gw.compress = status != http.StatusNoContent && status != http.StatusNotModified &&
+		gw.Header().Get("Content-Encoding") == ""
+	if gw.compress {
+		gw.Header().Set("Content-Encoding", "gzip")
+		gw.Header().Del("Content-Length")
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if !gw.compress {
+		return gw.ResponseWriter.Write(b)
+	}
+	if gw.gzip == nil {
+		gw.gzip = gzip.NewWriter(gw.ResponseWriter)
+	}
+	return gw.gzip.Write(b)
+}
+
+// Flush sends any buffered compressed data to the client
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gzip != nil {
+		gw.gzip.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *gzipResponseWriter) close() {
+	if gw.gzip != nil {
+		gw.gzip.Close()
+	}
+}
+
+// recordLimitedPaths limit each NDJSON record to MAX_BODY_BYTES themselves
+// rather than the whole body, so bulk imports can be sent compressed too
+var recordLimitedPaths = map[string]bool{
+	"/import":        true,
+	"/import/stream": true,
+}
+
+// GzipMiddleware transparently decompresses request bodies sent with
+// Content-Encoding: gzip and compresses responses for clients that send
+// Accept-Encoding: gzip. Decompressed bodies are capped at maxBytes, or each
+// record is on recordLimitedPaths, so a small compressed payload cannot expand
+// without bound.
+func GzipMiddleware(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid gzip request body")
+				return
+			}
+			body := io.Reader(reader)
+			if !recordLimitedPaths[r.URL.Path] {
+				body = http.MaxBytesReader(w, reader, maxBytes)
+			}
+			r.Body = &gzipBody{Reader: body, gzip: reader, body: r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
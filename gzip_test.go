@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBytes(t *testing.T, data []byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return &buf
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	handler := GzipMiddleware(server.maxBodyBytes, router)
+
+	reqBody, _ := json.Marshal(validReceipt())
+
+	// Test case 1: A gzipped receipt is decompressed and processed
+	req, _ := http.NewRequest("POST", "/receipts/process", gzipBytes(t, reqBody))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.ID)
+
+	// Test case 2: Responses are compressed when the client accepts gzip
+	req, _ = http.NewRequest("GET", "/receipts/"+response.ID+"/points", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	var points PointsResponse
+	assert.NoError(t, json.Unmarshal(body, &points))
+	assert.Equal(t, calculatePoints(validReceipt(), currentRules()), points.Points)
+
+	// Test case 3: Responses without a body are not compressed
+	req, _ = http.NewRequest("DELETE", "/receipts/"+response.ID, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Empty(t, rr.Body.Bytes())
+
+	// Test case 4: A body that isn't gzip is rejected
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid gzip request body", decodeError(t, rr).Error)
+}
+
+func TestGzipMiddlewareLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+	// The same order as serve: logging inside gzip
+	handler := GzipMiddleware(1<<20, LoggingMiddleware(logger, router))
+
+	// A rejected request that accepts gzip logs the uncompressed reason
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(`{"retailer": "Target!"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "Missing required receipt fields", entry["reason"])
+}
+
+func TestGzipMiddlewareDecompressionBomb(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	// A few kilobytes of gzip that expand to several megabytes of whitespace
+	bomb := gzipBytes(t, bytes.Repeat([]byte(" "), 4<<20))
+	assert.Less(t, bomb.Len(), 64<<10)
+
+	req, _ := http.NewRequest("POST", "/receipts/process", bomb)
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	GzipMiddleware(1024, router).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, "Request body too large", decodeError(t, rr).Error)
+}
+
+func TestGzipMiddlewareImport(t *testing.T) {
+	store := NewReceiptStore()
+	router := newAdminRouter(store)
+
+	// Compressed bulk imports may decompress past the body cap
+	var records bytes.Buffer
+	count := 0
+	for ; records.Len() <= 4096; count++ {
+		records.Write(mustMarshal(t, ExportRecord{ID: fmt.Sprintf("imported-%d", count), Receipt: validReceipt(), Points: 28}))
+		records.WriteString("\n")
+	}
+
+	req, _ := http.NewRequest("POST", "/import", gzipBytes(t, records.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr := httptest.NewRecorder()
+	GzipMiddleware(1024, router).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.ReceiptIDs(context.Background()), count)
+
+	// So may stream imports
+	receiptJSON := string(mustMarshal(t, validReceipt())) + "\n"
+	streamed := 4096/len(receiptJSON) + 1
+	req, _ = http.NewRequest("POST", "/import/stream", gzipBytes(t, []byte(strings.Repeat(receiptJSON, streamed))))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	GzipMiddleware(1024, router).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.ReceiptIDs(context.Background()), count+streamed)
+
+	// A single record that expands past the cap is still rejected
+	bomb := gzipBytes(t, append([]byte(`{"retailer": "`), bytes.Repeat([]byte("a"), 4<<20)...))
+	req, _ = http.NewRequest("POST", "/import", bomb)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(AdminSecretHeader, "s3cret")
+	rr = httptest.NewRecorder()
+	GzipMiddleware(1024, router).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, "Import record 1 is larger than 1048576 bytes", decodeError(t, rr).Error)
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":              false,
+		"gzip":          true,
+		"deflate, gzip": true,
+		"GZIP;q=0.5":    true,
+		"gzip;q=0":      false,
+		"br":            false,
+	}
+
+	for header, expected := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", header)
+		assert.Equal(t, expected, acceptsGzip(req), header)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import "context"
+
+// ScoredReceipt is a receipt a store has just scored, with the points it
+// stored and the rules it scored them with
+type ScoredReceipt struct {
+	ID      string
+	Receipt Receipt
+	Points  int
+	Rules   RuleSet
+}
+
+type scoredReporterKey struct{}
+
+// withScoredReporter returns a context in which stores pass every receipt
+// they score to report, and then to any reporter ctx already had
+func withScoredReporter(ctx context.Context, report func(ScoredReceipt)) context.Context {
+	parent, _ := ctx.Value(scoredReporterKey{}).(func(ScoredReceipt))
+	return context.WithValue(ctx, scoredReporterKey{}, func(scored ScoredReceipt) {
+		report(scored)
+		if parent != nil {
+			parent(scored)
+		}
+	})
+}
+
+// reportScored is called by a store once it has written the points of a new
+// or recomputed receipt. Stores don't call it for a deduplicated or replayed
+// receipt, since nothing was scored. It may run inside the store's
+// transaction, so a report only counts once the call that made it succeeds.
+func reportScored(ctx context.Context, scored ScoredReceipt) {
+	if report, ok := ctx.Value(scoredReporterKey{}).(func(ScoredReceipt)); ok {
+		report(scored)
+	}
+}
+
+// onStoredStore calls hook for every receipt the wrapped store scores and
+// stores as new, with the points the store calculated
+type onStoredStore struct {
+	Store
+	hook func(ScoredReceipt)
+}
+
+// onStored wraps the store so that hook sees every newly stored receipt.
+// Metrics, stats, webhooks, streams and the audit log are all built on it.
+func onStored(store Store, hook func(ScoredReceipt)) Store {
+	return &onStoredStore{Store: store, hook: hook}
+}
+
+func (ss *onStoredStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	var id string
+	ss.add(ctx, func(ctx context.Context) bool {
+		id = ss.Store.AddReceipt(ctx, receipt)
+		return id != ""
+	})
+	return id
+}
+
+func (ss *onStoredStore) AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (string, bool, error) {
+	var id string
+	var replayed bool
+	var err error
+	ss.add(ctx, func(ctx context.Context) bool {
+		id, replayed, err = ss.Store.AddReceiptIdempotent(ctx, key, receipt)
+		return err == nil
+	})
+	return id, replayed, err
+}
+
+func (ss *onStoredStore) AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error {
+	var err error
+	ss.add(ctx, func(ctx context.Context) bool {
+		err = ss.Store.AddReceiptWithID(ctx, id, receipt)
+		return err == nil
+	})
+	return err
+}
+
+// add runs one of the wrapped store's adds, and calls the hook if it
+// succeeded and scored a receipt
+func (ss *onStoredStore) add(ctx context.Context, add func(ctx context.Context) bool) {
+	var scored *ScoredReceipt
+	succeeded := add(withScoredReporter(ctx, func(report ScoredReceipt) {
+		scored = &report
+	}))
+	if succeeded && scored != nil {
+		ss.hook(*scored)
+	}
+}
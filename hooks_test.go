@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnStored(t *testing.T) {
+	memory := NewReceiptStore()
+	memory.dedup = true
+	bolt, err := NewBoltReceiptStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	defer bolt.Close()
+	bolt.dedup = true
+
+	for name, backend := range map[string]Store{"memory": memory, "bolt": bolt} {
+		var seen []ScoredReceipt
+		store := onStored(backend, func(scored ScoredReceipt) {
+			seen = append(seen, scored)
+		})
+
+		// Test case 1: A new receipt is seen with the points the store saved
+		id := store.AddReceipt(context.Background(), validReceipt())
+		assert.Len(t, seen, 1, name)
+		points, _ := backend.GetPoints(context.Background(), id)
+		assert.Equal(t, ScoredReceipt{ID: id, Receipt: validReceipt(), Points: points, Rules: currentRules()}, seen[0], name)
+
+		// Test case 2: A deduplicated receipt wasn't stored again
+		assert.Equal(t, id, store.AddReceipt(context.Background(), validReceipt()), name)
+		assert.Len(t, seen, 1, name)
+
+		// Test case 3: A replayed idempotent receipt wasn't stored again
+		changed := validReceipt()
+		changed.PurchaseTime = "08:13"
+		store.AddReceiptIdempotent(context.Background(), "key-1", changed)
+		store.AddReceiptIdempotent(context.Background(), "key-1", changed)
+		assert.Len(t, seen, 2, name)
+
+		// Test case 4: A rejected client id stores nothing
+		assert.ErrorIs(t, store.AddReceiptWithID(context.Background(), id, changed), ErrReceiptExists, name)
+		assert.NoError(t, store.AddReceiptWithID(context.Background(), "client-id", changed), name)
+		assert.Len(t, seen, 3, name)
+		assert.Equal(t, "client-id", seen[2].ID, name)
+
+		// Test case 5: Recomputing isn't a new receipt
+		store.RecomputeAll(context.Background())
+		assert.Len(t, seen, 3, name)
+	}
+}
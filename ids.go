@@ -0,0 +1,16 @@
+package main
+
+import "github.com/google/uuid"
+
+// IDGenerator creates the ids that new receipts are stored under
+type IDGenerator interface {
+	Generate() string
+}
+
+// UUIDGenerator generates random (version 4) UUIDs. It is the default for
+// every store.
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) Generate() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// counterIDGenerator hands out receipt-1, receipt-2, ... so tests can predict ids
+type counterIDGenerator struct {
+	next atomic.Int64
+}
+
+func (g *counterIDGenerator) Generate() string {
+	return fmt.Sprintf("receipt-%d", g.next.Add(1))
+}
+
+func TestUUIDGenerator(t *testing.T) {
+	first := UUIDGenerator{}.Generate()
+	_, err := uuid.Parse(first)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, UUIDGenerator{}.Generate())
+}
+
+func TestStoresUseIDGenerator(t *testing.T) {
+	// Test case 1: The in-memory store
+	store := NewReceiptStore()
+	store.idGenerator = &counterIDGenerator{}
+	assert.Equal(t, "receipt-1", store.AddReceipt(context.Background(), validReceipt()))
+	assert.Equal(t, "receipt-2", store.AddReceipt(context.Background(), validReceipt()))
+	_, exists := store.GetPoints(context.Background(), "receipt-2")
+	assert.True(t, exists)
+
+	// Test case 2: The Bolt store, including idempotent adds
+	boltStore, err := NewBoltReceiptStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	defer boltStore.Close()
+	boltStore.idGenerator = &counterIDGenerator{}
+	assert.Equal(t, "receipt-1", boltStore.AddReceipt(context.Background(), validReceipt()))
+	id, replayed, err := boltStore.AddReceiptIdempotent(context.Background(), "key", validReceipt())
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, "receipt-2", id)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the id used to correlate logs for a single request
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request id assigned by LoggingMiddleware
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// parseLogLevel reads a LOG_LEVEL such as "debug", ignoring case
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("logLevel must be debug, info, warn or error, got %q", name)
+}
+
+// logProcessedReceipt logs a stored receipt and how it was scored at debug
+// level. The breakdown is only computed when debug logging is enabled.
+func logProcessedReceipt(ctx context.Context, id string, receipt Receipt) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	points, breakdown := calculatePointsDetailed(receipt, currentRules())
+	slog.DebugContext(ctx, "processed receipt",
+		"requestId", requestIDFromContext(ctx),
+		"id", id,
+		"receipt", receipt,
+		"points", points,
+		"breakdown", breakdown,
+	)
+}
+
+// LogValue controls how a receipt appears in structured logs. Every field is
+// listed explicitly, so a sensitive one can be redacted here for all log
+// lines at once.
+func (receipt Receipt) LogValue() slog.Value {
+	items := make([]any, len(receipt.Items))
+	for i, item := range receipt.Items {
+		items[i] = map[string]string{"shortDescription": item.ShortDescription, "price": item.Price}
+	}
+	return slog.GroupValue(
+		slog.String("retailer", receipt.Retailer),
+		slog.String("purchaseDate", receipt.PurchaseDate),
+		slog.String("purchaseTime", receipt.PurchaseTime),
+		slog.Any("items", items),
+		slog.String("total", receipt.Total),
+		slog.String("currency", receipt.Currency),
+		slog.String("label", receipt.Label),
+		slog.String("clientId", receipt.ClientID),
+	)
+}
+
+// LoggingMiddleware emits one structured log line per request and propagates
+// a request id through the X-Request-ID header, honoring one sent by the client.
+func LoggingMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		attrs := []any{
+			slog.String("requestId", requestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if recorder.status == http.StatusBadRequest {
+			attrs = append(attrs, slog.String("reason", recorder.errorMessage()))
+		}
+		logger.Info("request", attrs...)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	server := NewServer(NewReceiptStore())
+	handler := LoggingMiddleware(logger, http.HandlerFunc(server.ProcessReceiptHandler))
+
+	// Test case 1: A request id is generated and logged with the request
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	requestID := rr.Header().Get(RequestIDHeader)
+	assert.NotEmpty(t, requestID)
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(logs.Bytes(), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, requestID, entry["requestId"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/receipts/process", entry["path"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+	assert.Contains(t, entry, "latency")
+	assert.NotContains(t, entry, "reason")
+
+	// Test case 2: An incoming request id is honored and failures log a reason
+	logs.Reset()
+	receipt.Retailer = "Target!"
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	req.Header.Set(RequestIDHeader, "client-request-id")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, "client-request-id", rr.Header().Get(RequestIDHeader))
+
+	entry = map[string]interface{}{}
+	err = json.Unmarshal(logs.Bytes(), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-request-id", entry["requestId"])
+	assert.Equal(t, float64(http.StatusBadRequest), entry["status"])
+	assert.Equal(t, "Invalid retailer", entry["reason"])
+}
+
+func TestDebugLogging(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	server := NewServer(NewReceiptStore())
+	receiptJSON, _ := json.Marshal(validReceipt())
+
+	process := func(level string) string {
+		parsed, err := parseLogLevel(level)
+		assert.NoError(t, err)
+		var logs bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: parsed})))
+
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewReader(receiptJSON))
+		rr := httptest.NewRecorder()
+		server.ProcessReceiptHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return logs.String()
+	}
+
+	// Test case 1: Processed receipts are not logged at info and above
+	for _, level := range []string{"info", "warn", "ERROR"} {
+		assert.NotContains(t, process(level), "processed receipt", level)
+	}
+
+	// Test case 2: Debug logs the parsed receipt and its breakdown
+	var entry struct {
+		Msg       string            `json:"msg"`
+		Level     string            `json:"level"`
+		ID        string            `json:"id"`
+		Receipt   map[string]any    `json:"receipt"`
+		Points    int               `json:"points"`
+		Breakdown []PointsBreakdown `json:"breakdown"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(process("debug")), &entry))
+	assert.Equal(t, "processed receipt", entry.Msg)
+	assert.Equal(t, "DEBUG", entry.Level)
+	assert.NotEmpty(t, entry.ID)
+	assert.Equal(t, validReceipt().Retailer, entry.Receipt["retailer"])
+	assert.Len(t, entry.Receipt["items"], len(validReceipt().Items))
+	points, breakdown := calculatePointsDetailed(validReceipt(), currentRules())
+	assert.Equal(t, points, entry.Points)
+	assert.Equal(t, breakdown, entry.Breakdown)
+
+	// Test case 3: Unknown levels are rejected
+	_, err := parseLogLevel("verbose")
+	assert.EqualError(t, err, `logLevel must be debug, info, warn or error, got "verbose"`)
+}
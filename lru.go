@@ -0,0 +1,43 @@
+package main
+
+import "container/list"
+
+// addOrder lists receipt ids from the oldest added to the newest, so that a
+// store with a receipt cap can evict the oldest in constant time
+type addOrder struct {
+	ids      *list.List
+	elements map[string]*list.Element
+}
+
+func newAddOrder() *addOrder {
+	return &addOrder{ids: list.New(), elements: make(map[string]*list.Element)}
+}
+
+// push records id as the newest receipt, moving it if it is already listed
+func (o *addOrder) push(id string) {
+	if element, exists := o.elements[id]; exists {
+		o.ids.MoveToBack(element)
+		return
+	}
+	o.elements[id] = o.ids.PushBack(id)
+}
+
+func (o *addOrder) remove(id string) {
+	if element, exists := o.elements[id]; exists {
+		o.ids.Remove(element)
+		delete(o.elements, id)
+	}
+}
+
+// oldest returns the id that was added longest ago
+func (o *addOrder) oldest() (string, bool) {
+	front := o.ids.Front()
+	if front == nil {
+		return "", false
+	}
+	return front.Value.(string), true
+}
+
+func (o *addOrder) len() int {
+	return o.ids.Len()
+}
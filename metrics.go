@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus collectors for the receipt processor
+type Metrics struct {
+	receiptsProcessed  prometheus.Counter
+	validationFailures *prometheus.CounterVec
+	pointsAwarded      prometheus.Histogram
+	requestDuration    *prometheus.HistogramVec
+}
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		receiptsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "receipts_processed_total",
+			Help: "Number of receipts successfully processed.",
+		}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "receipt_validation_failures_total",
+			Help: "Number of receipts rejected by validation, by reason.",
+		}, []string{"reason"}),
+		pointsAwarded: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "receipt_points_awarded",
+			Help:    "Points awarded per processed receipt.",
+			Buckets: []float64{0, 10, 25, 50, 75, 100, 150, 200, 300, 500},
+		}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP handlers, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+	}
+
+	registerer.MustRegister(m.receiptsProcessed, m.validationFailures, m.pointsAwarded, m.requestDuration)
+	return m
+}
+
+// Middleware records handler latency and validation failures for every route.
+// It must be installed with router.Use so the matched route is available.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+
+		// Only receipts rejected by validation count; other bad requests,
+		// such as malformed JSON, record no reason
+		if recorder.reason != "" {
+			m.validationFailures.WithLabelValues(recorder.reason).Inc()
+		}
+	})
+}
+
+// statusRecorder captures the status code and error message written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	errorBody []byte
+
+	// reason is the validation reason code recorded by writeValidationError
+	reason string
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status >= http.StatusBadRequest && len(sr.errorBody) < 256 {
+		sr.errorBody = append(sr.errorBody, b...)
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// errorMessage returns the message from a JSON error response, or the raw
+// body for handlers that don't write JSON errors
+func (sr *statusRecorder) errorMessage() string {
+	var response ErrorResponse
+	if err := json.Unmarshal(sr.errorBody, &response); err == nil && response.Error != "" {
+		return response.Error
+	}
+	return strings.TrimSpace(string(sr.errorBody))
+}
+
+// validationReasons map the sentinel validation errors to the codes used as
+// the reason label. The label must never come from the error message, which
+// can echo client input such as unknown field names and would let clients
+// create unlimited series.
+var validationReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrMissingField, "missing_field"},
+	{ErrNoItems, "no_items"},
+	{ErrBadRetailer, "bad_retailer"},
+	{ErrBadDate, "bad_date"},
+	{ErrBadTime, "bad_time"},
+	{ErrBadTotal, "bad_total"},
+	{ErrBadCurrency, "bad_currency"},
+	{ErrBadItemPrice, "bad_item_price"},
+	{ErrTotalMismatch, "total_mismatch"},
+	{ErrBadDateTime, "bad_date_time"},
+	{ErrDateConflict, "date_conflict"},
+	{ErrTooManyItems, "too_many_items"},
+	{ErrFieldTooLong, "field_too_long"},
+	{ErrFutureDate, "future_date"},
+	{ErrBadClientID, "bad_client_id"},
+}
+
+// otherValidationReason labels validation failures with no sentinel error
+const otherValidationReason = "other"
+
+// validationReason returns the reason code for the first problem in err
+func validationReason(err error) string {
+	var errs ValidationErrors
+	if errors.As(err, &errs) && len(errs) > 0 {
+		err = errs[0]
+	}
+	for _, known := range validationReasons {
+		if errors.Is(err, known.err) {
+			return known.reason
+		}
+	}
+	return otherValidationReason
+}
+
+// recordValidationReason tells every statusRecorder wrapping w why the
+// request failed validation
+func recordValidationReason(w http.ResponseWriter, err error) {
+	reason := validationReason(err)
+	for {
+		if recorder, ok := w.(*statusRecorder); ok {
+			recorder.reason = reason
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// observe records a newly stored receipt
+func (m *Metrics) observe(scored ScoredReceipt) {
+	m.receiptsProcessed.Inc()
+	m.pointsAwarded.Observe(float64(scored.Points))
+}
+
+// InstrumentStore wraps the store so that processed receipts are recorded
+func (m *Metrics) InstrumentStore(store Store) Store {
+	return onStored(store, m.observe)
+}
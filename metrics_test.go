@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	server := NewServer(metrics.InstrumentStore(NewReceiptStore()))
+
+	router := mux.NewRouter()
+	router.Use(metrics.Middleware)
+	router.HandleFunc("/receipts/process", server.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{})).Methods("GET")
+
+	// Process one valid and one invalid receipt
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response ReceiptResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	receipt.Retailer = "Target!"
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/receipts/"+response.ID+"/points", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.receiptsProcessed))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validationFailures.WithLabelValues("bad_retailer")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.pointsAwarded))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.requestDuration))
+
+	// The collectors are exposed on the metrics endpoint
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "receipts_processed_total 1")
+	assert.Contains(t, rr.Body.String(), `http_request_duration_seconds_count{method="POST",route="/receipts/process"} 2`)
+
+	// Bad requests that aren't validation failures, such as unknown field
+	// names, aren't counted, so client input never becomes a label either
+	for _, field := range []string{"retailar", "purchase_date"} {
+		req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(`{"`+field+`": "x"}`))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.validationFailures))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxUploadBytes bounds a /receipts/upload request, which carries an image
+// and so may be far larger than a JSON receipt
+const maxUploadBytes = 10 << 20
+
+// uploadMemoryBytes is how much of an upload is held in memory; the rest of
+// the image is spooled to a temporary file
+const uploadMemoryBytes = 1 << 20
+
+// ErrOCRNotConfigured is returned by the stub OCRProcessor the server starts with
+var ErrOCRNotConfigured = errors.New("OCR not configured")
+
+// OCRProcessor reads a receipt out of an image such as a photo or scan. The
+// receipt it returns is validated and scored like a submitted one, so it
+// need not be checked here.
+type OCRProcessor interface {
+	ExtractReceipt(ctx context.Context, image io.Reader, contentType string) (Receipt, error)
+}
+
+// stubOCRProcessor is used until a real OCRProcessor is configured, so the
+// upload endpoint exists without an OCR dependency
+type stubOCRProcessor struct{}
+
+func (stubOCRProcessor) ExtractReceipt(ctx context.Context, image io.Reader, contentType string) (Receipt, error) {
+	return Receipt{}, ErrOCRNotConfigured
+}
+
+// UploadReceiptHandler accepts a multipart form with the receipt image in
+// its "image" field, turns the image into a receipt with the server's
+// OCRProcessor, then validates, scores and stores it like ProcessReceiptHandler.
+func (s *Server) UploadReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid upload. Expected a multipart form with an image field")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	image, header, err := r.FormFile("image")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Missing image field")
+		return
+	}
+	defer image.Close()
+
+	receipt, err := s.ocr.ExtractReceipt(r.Context(), image, header.Header.Get("Content-Type"))
+	if errors.Is(err, ErrOCRNotConfigured) {
+		writeJSONError(w, http.StatusNotImplemented, "OCR not configured")
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to read receipt image", "filename", header.Filename, "error", err)
+		writeJSONError(w, http.StatusUnprocessableEntity, "Could not read a receipt from the image")
+		return
+	}
+
+	if err := prepareReceipt(&receipt); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	s.storeAndRespond(w, r, receipt)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOCRProcessor returns a canned receipt and records the image it was given
+type fakeOCRProcessor struct {
+	receipt     Receipt
+	err         error
+	image       []byte
+	contentType string
+}
+
+func (fo *fakeOCRProcessor) ExtractReceipt(ctx context.Context, image io.Reader, contentType string) (Receipt, error) {
+	fo.image, _ = io.ReadAll(image)
+	fo.contentType = contentType
+	return fo.receipt, fo.err
+}
+
+// newUploadRequest builds a multipart upload with the given file under field
+func newUploadRequest(t *testing.T, field string, image []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="receipt.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	assert.NoError(t, err)
+	part.Write(image)
+	assert.NoError(t, writer.WriteField("note", "lunch"))
+	assert.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", "/receipts/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadReceipt(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	image := []byte("\x89PNG fake image")
+
+	upload := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: The stub reports that OCR is not configured
+	rr := upload(newUploadRequest(t, "image", image))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	assert.Equal(t, "OCR not configured", decodeError(t, rr).Error)
+	assert.Empty(t, store.ReceiptIDs(context.Background()))
+
+	// Test case 2: The extracted receipt is scored and stored
+	ocr := &fakeOCRProcessor{receipt: validReceipt()}
+	server.ocr = ocr
+	req := newUploadRequest(t, "image", image)
+	req.URL.RawQuery = "includePoints=true"
+	rr = upload(req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, image, ocr.image)
+	assert.Equal(t, "image/png", ocr.contentType)
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	points, exists := store.GetPoints(context.Background(), response.ID)
+	assert.True(t, exists)
+	assert.Equal(t, calculatePoints(validReceipt(), currentRules()), points)
+	assert.Equal(t, points, *response.Points)
+
+	// Test case 3: Extracted receipts are validated like submitted ones
+	invalid := validReceipt()
+	invalid.Total = "1.00"
+	server.ocr = &fakeOCRProcessor{receipt: invalid}
+	rr = upload(newUploadRequest(t, "image", image))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Total does not match sum of items", decodeError(t, rr).Error)
+
+	// Test case 4: Images the processor can't read
+	server.ocr = &fakeOCRProcessor{err: errors.New("no text found")}
+	rr = upload(newUploadRequest(t, "image", image))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, "Could not read a receipt from the image", decodeError(t, rr).Error)
+
+	// Test case 5: Malformed uploads never reach the processor
+	ocr = &fakeOCRProcessor{receipt: validReceipt()}
+	server.ocr = ocr
+	rr = upload(newUploadRequest(t, "photo", image))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Missing image field", decodeError(t, rr).Error)
+
+	req, _ = http.NewRequest("POST", "/receipts/upload", strings.NewReader(`{"retailer": "Target"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = upload(req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid upload. Expected a multipart form with an image field", decodeError(t, rr).Error)
+	assert.Nil(t, ocr.image)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 1)
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Minimal OpenAPI 3 document model, covering only what this service uses
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Required    bool           `json:"required"`
+	Description string         `json:"description"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref         string                      `json:"$ref,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Pattern     string                    `json:"pattern,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Example     interface{}               `json:"example,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	MinItems    int                       `json:"minItems,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas   map[string]*openAPISchema  `json:"schemas"`
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+func schemaRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+func jsonContent(schema *openAPISchema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// receiptIDParameter is the {id} path parameter shared by the receipt routes
+var receiptIDParameter = openAPIParameter{
+	Name:        "id",
+	In:          "path",
+	Required:    true,
+	Description: "The ID of the receipt.",
+	Schema:      &openAPISchema{Type: "string", Pattern: `^\S+$`},
+}
+
+// amountPattern documents totals and prices, which use "." or, for currencies
+// written with a decimal comma, ",". The number of decimal places depends on
+// the currency.
+const amountPattern = `^\d+([.,]\d{2,3})?$`
+
+// openAPISpec describes the routes registered by Server.RegisterRoutes
+func openAPISpec() openAPIDocument {
+	badRequest := openAPIResponse{Ref: "#/components/responses/BadRequest"}
+	notFound := openAPIResponse{Ref: "#/components/responses/NotFound"}
+	// Single receipts may also be sent as CSV, see parseReceiptCSV
+	processBody := &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+		"application/json": {Schema: schemaRef("Receipt")},
+		"text/csv": {Schema: &openAPISchema{
+			Type:        "string",
+			Description: "Four name,value rows for retailer, purchaseDate, purchaseTime and total, then one description,price row per item.",
+		}},
+	}}
+
+	return openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Receipt Processor",
+			Description: "A simple receipt processor",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]map[string]openAPIOperation{
+			"/receipts": {
+				"get": {
+					Summary: "Lists stored receipts, ordered by purchase date then ID.",
+					Parameters: []openAPIParameter{
+						{
+							Name:        "limit",
+							In:          "query",
+							Description: fmt.Sprintf("Page size, default %d and at most %d.", defaultListLimit, maxListLimit),
+							Schema:      &openAPISchema{Type: "integer"},
+						},
+						{
+							Name:        "offset",
+							In:          "query",
+							Description: "Number of receipts to skip.",
+							Schema:      &openAPISchema{Type: "integer"},
+						},
+						{
+							Name:        "label",
+							In:          "query",
+							Description: "Only list receipts with this label; the total counts matching receipts.",
+							Schema:      &openAPISchema{Type: "string"},
+						},
+					},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "One page of receipt summaries.", Content: jsonContent(schemaRef("ReceiptList"))},
+						"400": {Description: "The limit or offset is invalid.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/changes": {
+				"get": {
+					Summary: "Lists the receipts stored after a timestamp, oldest first, for incremental sync.",
+					Parameters: []openAPIParameter{{
+						Name:        "since",
+						In:          "query",
+						Description: "An RFC 3339 timestamp; only receipts stored after it are listed. Pass the last addedAt to continue.",
+						Required:    true,
+						Schema:      &openAPISchema{Type: "string", Format: "date-time"},
+					}},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The receipts stored after since, with addedAt set.", Content: jsonContent(schemaRef("ReceiptChanges"))},
+						"400": {Description: "since is missing or not an RFC 3339 timestamp.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/stream": {
+				"get": {
+					Summary: "Streams an event for every receipt processed while connected, as server-sent events.",
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "A receipt event per processed receipt, with periodic keep-alive comments.", Content: map[string]openAPIMediaType{
+							"text/event-stream": {Schema: schemaRef("ReceiptEvent")},
+						}},
+					},
+				},
+			},
+			"/receipts/process": {
+				"post": {
+					Summary: "Submits a receipt for processing.",
+					Parameters: []openAPIParameter{
+						{
+							Name:        IdempotencyKeyHeader,
+							In:          "header",
+							Description: "Retries with the same key return the original ID instead of creating a new receipt.",
+							Schema:      &openAPISchema{Type: "string"},
+						},
+						{
+							Name:        "includePoints",
+							In:          "query",
+							Description: "When true, the response also includes the points awarded.",
+							Schema:      &openAPISchema{Type: "boolean"},
+						},
+						{
+							Name:        "label",
+							In:          "query",
+							Description: "Labels the receipt, as an alternative to the label field. Both may be sent only if they agree.",
+							Schema:      &openAPISchema{Type: "string"},
+						},
+						{
+							Name:        "onConflict",
+							In:          "query",
+							Description: "What to do when the clientId is taken: reject with 409, or suffix the id as clientId-2, clientId-3 and so on. Defaults to reject.",
+							Schema:      &openAPISchema{Type: "string", Enum: []string{"reject", "suffix"}},
+						},
+					},
+					RequestBody: processBody,
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "Returns the ID assigned to the receipt.", Content: jsonContent(schemaRef("ReceiptResponse"))},
+						"400": badRequest,
+						"409": {Description: "The Idempotency-Key was already used for a different receipt, or a receipt with the clientId already exists.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/upload": {
+				"post": {
+					Summary: "Reads a receipt out of an uploaded image with the configured OCR processor, then validates, scores and stores it.",
+					RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+						"multipart/form-data": {Schema: &openAPISchema{
+							Type:       "object",
+							Required:   []string{"image"},
+							Properties: map[string]*openAPISchema{"image": {Type: "string", Format: "binary"}},
+						}},
+					}},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The receipt was stored.", Content: jsonContent(schemaRef("ReceiptResponse"))},
+						"400": {Description: "The upload has no image, or the receipt read from it is invalid.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The upload is larger than 10 MiB.", Content: jsonContent(schemaRef("Error"))},
+						"422": {Description: "No receipt could be read from the image.", Content: jsonContent(schemaRef("Error"))},
+						"501": {Description: "No OCR processor is configured.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/process/batch": {
+				"post": {
+					Summary: "Submits several receipts for processing.",
+					RequestBody: &openAPIRequestBody{
+						Required: true,
+						Content:  jsonContent(&openAPISchema{Type: "array", Items: schemaRef("Receipt")}),
+					},
+					Responses: map[string]openAPIResponse{
+						"207": {
+							Description: "The outcome for each receipt in the batch.",
+							Content:     jsonContent(&openAPISchema{Type: "array", Items: schemaRef("BatchResult")}),
+						},
+						"400": badRequest,
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/aggregate": {
+				"post": {
+					Summary: "Scores several receipts and sums their points, without storing them.",
+					RequestBody: &openAPIRequestBody{
+						Required: true,
+						Content:  jsonContent(&openAPISchema{Type: "array", Items: schemaRef("Receipt")}),
+					},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The total and each receipt's points and breakdown.", Content: jsonContent(schemaRef("AggregateResponse"))},
+						"400": {Description: "A receipt is invalid; the error names its index.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/validate": {
+				"post": {
+					Summary:     "Validates a receipt and previews its points without storing it.",
+					RequestBody: processBody,
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The receipt is valid.", Content: jsonContent(schemaRef("ValidateResponse"))},
+						"400": badRequest,
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/receipts/points/lookup": {
+				"post": {
+					Summary:     "Finds a stored receipt identical to the one sent and returns its ID and points, without storing anything.",
+					RequestBody: processBody,
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The matching receipt.", Content: jsonContent(schemaRef("LookupResponse"))},
+						"400": badRequest,
+						"404": {Description: "No identical receipt is stored.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/score": {
+				"post": {
+					Summary:     "Validates a receipt and returns only its points, without storing it.",
+					RequestBody: processBody,
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The points the receipt would be awarded.", Content: jsonContent(schemaRef("PointsResponse"))},
+						"400": badRequest,
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/score/with-rules": {
+				"post": {
+					Summary: "Scores a valid receipt with a posted ruleset, without storing the receipt or activating the rules.",
+					RequestBody: &openAPIRequestBody{
+						Required: true,
+						Content:  jsonContent(schemaRef("ScoreWithRulesRequest")),
+					},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The points and breakdown under the posted rules.", Content: jsonContent(schemaRef("ScoreWithRulesResponse"))},
+						"400": {Description: "The receipt or the ruleset is invalid, or the rules are missing.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/simulate": {
+				"post": {
+					Summary: "Scores a valid receipt once for each value of a swept total or purchaseTime, without storing it.",
+					RequestBody: &openAPIRequestBody{
+						Required: true,
+						Content:  jsonContent(schemaRef("SimulateRequest")),
+					},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The points for each value, in order.", Content: jsonContent(schemaRef("SimulateResponse"))},
+						"400": {Description: "The receipt or the sweep is invalid, or the sweep has too many steps.", Content: jsonContent(schemaRef("Error"))},
+						"413": {Description: "The request body is too large.", Content: jsonContent(schemaRef("Error"))},
+					},
+				},
+			},
+			"/rules": {
+				"get": {
+					Summary: "Describes how points are calculated with the active ruleset.",
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "One sentence per rule, as a bulleted list when text/plain is preferred in Accept.", Content: map[string]openAPIMediaType{
+							"application/json": {Schema: schemaRef("RulesDescriptionResponse")},
+							"text/plain":       {Schema: &openAPISchema{Type: "string", Example: "* 50 points if the total is a round dollar amount with no cents."}},
+						}},
+					},
+				},
+			},
+			"/receipts/{id}/points": {
+				"get": {
+					Summary: "Returns the points awarded for the receipt.",
+					Parameters: []openAPIParameter{receiptIDParameter, {
+						Name:        "If-None-Match",
+						In:          "header",
+						Description: "An ETag from an earlier response; the points are only sent if they changed.",
+						Schema:      &openAPISchema{Type: "string"},
+					}, {
+						Name:        "ruleset",
+						In:          "query",
+						Description: "A named ruleset, such as v1, to rescore the receipt with instead of returning its stored points.",
+						Schema:      &openAPISchema{Type: "string"},
+					}},
+					Responses: map[string]openAPIResponse{
+						"200": {Description: "The number of points awarded, as a bare integer and newline when text/plain is preferred in Accept.", Content: map[string]openAPIMediaType{
+							"application/json": {Schema: schemaRef("PointsResponse")},
+							"text/plain":       {Schema: &openAPISchema{Type: "string", Example: "28"}},
+						}},
+						"304": {Description: "The points match the If-None-Match tag."},
+						"400": {Description: "The ruleset is unknown.", Content: jsonContent(schemaRef("Error"))},
+						"404": notFound,
+					},
+				},
+			},
+			"/receipts/{id}/points/breakdown": {
+				"get": {
+					Summary:    "Returns how each rule contributed to the receipt's points.",
+					Parameters: []openAPIParameter{receiptIDParameter},
+					Responses: map[string]openAPIResponse{
+						"200": {
+							Description: "One entry per rule that awarded points.",
+							Content:     jsonContent(&openAPISchema{Type: "array", Items: schemaRef("PointsBreakdown")}),
+						},
+						"404": notFound,
+					},
+				},
+			},
+			"/receipts/{id}": {
+				"delete": {
+					Summary:    "Deletes the receipt and its points.",
+					Parameters: []openAPIParameter{receiptIDParameter},
+					Responses: map[string]openAPIResponse{
+						"204": {Description: "The receipt was deleted."},
+						"404": notFound,
+					},
+				},
+			},
+		},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				"Receipt": {
+					Type:     "object",
+					Required: []string{"retailer", "items", "total"},
+					Properties: map[string]*openAPISchema{
+						"retailer": {
+							Type:        "string",
+							Description: "The name of the retailer or store the receipt is from.",
+							Pattern:     retailerPattern.String(),
+							Example:     "M&M Corner Market",
+						},
+						"purchaseDate": {
+							Type:        "string",
+							Format:      "date",
+							Description: "The date of the purchase printed on the receipt.",
+							Example:     "2022-01-01",
+						},
+						"purchaseTime": {
+							Type:        "string",
+							Format:      "time",
+							Description: "The time of the purchase printed on the receipt. 24-hour time expected.",
+							Example:     "13:01",
+						},
+						"purchaseDateTime": {
+							Type:        "string",
+							Format:      "date-time",
+							Description: "Alternative to purchaseDate and purchaseTime. Either this or both split fields are required, and they must agree when both are sent.",
+							Example:     "2022-01-01T13:01:00-05:00",
+						},
+						"label": {
+							Type:        "string",
+							Description: "A free-form tag for filtering the listing. It is stored but not scored.",
+							Example:     "business",
+						},
+						"clientId": {
+							Type:        "string",
+							Pattern:     clientIDPattern.String(),
+							Description: "Stores the receipt under this ID instead of a generated one. It cannot be combined with an Idempotency-Key.",
+							Example:     "order-1234",
+						},
+						"items": {Type: "array", MinItems: 1, Items: schemaRef("Item")},
+						"total": {
+							Type:        "string",
+							Description: "The total amount paid on the receipt, with as many decimal places as the currency has: two for USD, none for JPY, three for BHD. Currencies written with a decimal comma may use \"12,25\".",
+							Pattern:     amountPattern,
+							Example:     "6.49",
+						},
+						"currency": {
+							Type:        "string",
+							Description: "ISO 4217 currency code, USD when omitted.",
+							Pattern:     currencyPattern.String(),
+							Example:     "USD",
+						},
+					},
+				},
+				"Item": {
+					Type:     "object",
+					Required: []string{"shortDescription", "price"},
+					Properties: map[string]*openAPISchema{
+						"shortDescription": {
+							Type:        "string",
+							Description: "The Short Product Description for the item.",
+							Example:     "Mountain Dew 12PK",
+						},
+						"price": {
+							Type:        "string",
+							Description: "The total price payed for this item, in the receipt's currency.",
+							Pattern:     amountPattern,
+							Example:     "6.49",
+						},
+					},
+				},
+				"ReceiptResponse": {
+					Type:     "object",
+					Required: []string{"id"},
+					Properties: map[string]*openAPISchema{
+						"id":     {Type: "string", Pattern: `^\S+$`},
+						"points": {Type: "integer", Format: "int64", Description: "Only present when requested with includePoints=true."},
+					},
+				},
+				"ValidateResponse": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"valid":  {Type: "boolean"},
+						"points": {Type: "integer", Format: "int64", Description: "The points the receipt would be awarded."},
+					},
+				},
+				"LookupResponse": {
+					Type:     "object",
+					Required: []string{"id", "points"},
+					Properties: map[string]*openAPISchema{
+						"id":     {Type: "string"},
+						"points": {Type: "integer", Format: "int64"},
+					},
+				},
+				"RulesDescriptionResponse": {
+					Type:       "object",
+					Required:   []string{"rules"},
+					Properties: map[string]*openAPISchema{"rules": {Type: "array", Items: &openAPISchema{Type: "string"}}},
+				},
+				"PointsResponse": {
+					Type:       "object",
+					Properties: map[string]*openAPISchema{"points": {Type: "integer", Format: "int64", Example: 100}},
+				},
+				"BatchResult": {
+					Type:     "object",
+					Required: []string{"index"},
+					Properties: map[string]*openAPISchema{
+						"index": {Type: "integer", Description: "Position of the receipt in the submitted array."},
+						"id":    {Type: "string", Description: "The ID assigned to the receipt, if it was stored."},
+						"error": {Type: "string", Description: "Why the receipt was rejected, if it was not stored."},
+					},
+				},
+				"ReceiptSummary": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"id":                 {Type: "string"},
+						"retailer":           {Type: "string"},
+						"normalizedRetailer": {Type: "string", Description: "Only present when the normalizeRetailers rule is on."},
+						"purchaseDate":       {Type: "string", Format: "date"},
+						"total":              {Type: "string"},
+						"points":             {Type: "integer"},
+						"label":              {Type: "string"},
+						"addedAt":            {Type: "string", Format: "date-time", Description: "When the receipt was stored; only present in /receipts/changes."},
+					},
+				},
+				"ReceiptChanges": {
+					Type:     "object",
+					Required: []string{"receipts", "since"},
+					Properties: map[string]*openAPISchema{
+						"receipts": {Type: "array", Items: schemaRef("ReceiptSummary")},
+						"since":    {Type: "string", Format: "date-time"},
+					},
+				},
+				"ReceiptEvent": {
+					Type:     "object",
+					Required: []string{"id", "retailer", "points", "processedAt"},
+					Properties: map[string]*openAPISchema{
+						"id":          {Type: "string"},
+						"retailer":    {Type: "string"},
+						"points":      {Type: "integer", Format: "int64"},
+						"processedAt": {Type: "string", Format: "date-time"},
+					},
+				},
+				"ReceiptList": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"receipts": {Type: "array", Items: schemaRef("ReceiptSummary")},
+						"total":    {Type: "integer", Description: "Number of stored receipts."},
+						"limit":    {Type: "integer"},
+						"offset":   {Type: "integer"},
+					},
+				},
+				"Error": {
+					Type:     "object",
+					Required: []string{"error", "status"},
+					Properties: map[string]*openAPISchema{
+						"error":  {Type: "string", Description: "What went wrong."},
+						"status": {Type: "integer", Description: "The HTTP status code."},
+						"errors": {Type: "array", Description: "Every validation problem, for a receipt that failed validation.", Items: schemaRef("FieldError")},
+					},
+				},
+				"FieldError": {
+					Type:     "object",
+					Required: []string{"field", "message"},
+					Properties: map[string]*openAPISchema{
+						"field":   {Type: "string", Description: "The receipt field at fault, such as purchaseDate or items[1].price."},
+						"message": {Type: "string"},
+					},
+				},
+				"AggregateResponse": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"totalPoints": {Type: "integer"},
+						"receipts": {Type: "array", Items: &openAPISchema{
+							Type: "object",
+							Properties: map[string]*openAPISchema{
+								"index":     {Type: "integer", Description: "Position of the receipt in the submitted array."},
+								"points":    {Type: "integer"},
+								"breakdown": {Type: "array", Items: schemaRef("PointsBreakdown")},
+							},
+						}},
+					},
+				},
+				"ScoreWithRulesRequest": {
+					Type:     "object",
+					Required: []string{"receipt", "rules"},
+					Properties: map[string]*openAPISchema{
+						"receipt": schemaRef("Receipt"),
+						"rules":   {Type: "object", Description: "A ruleset in the same form as the RULES_PATH file. Missing rules keep their default values."},
+					},
+				},
+				"ScoreWithRulesResponse": {
+					Type:     "object",
+					Required: []string{"points", "breakdown"},
+					Properties: map[string]*openAPISchema{
+						"points":    {Type: "integer", Format: "int64"},
+						"breakdown": {Type: "array", Items: schemaRef("PointsBreakdown")},
+					},
+				},
+				"SimulateRequest": {
+					Type:     "object",
+					Required: []string{"receipt", "parameter", "from", "to", "step"},
+					Properties: map[string]*openAPISchema{
+						"receipt":   schemaRef("Receipt"),
+						"parameter": {Type: "string", Enum: []string{"total", "purchaseTime"}},
+						"from":      {Type: "string", Description: "The first value, such as 1.00 or 13:00.", Example: "1.00"},
+						"to":        {Type: "string", Description: "The last value, included when a step lands on it.", Example: "100.00"},
+						"step":      {Type: "string", Description: "An amount for total, or a duration such as 15m for purchaseTime.", Example: "1.00"},
+					},
+				},
+				"SimulateResponse": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"parameter": {Type: "string"},
+						"results": {Type: "array", Items: &openAPISchema{
+							Type: "object",
+							Properties: map[string]*openAPISchema{
+								"value":  {Type: "string"},
+								"points": {Type: "integer"},
+							},
+						}},
+					},
+				},
+				"PointsBreakdown": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"rule":        {Type: "string"},
+						"description": {Type: "string"},
+						"points":      {Type: "integer"},
+					},
+				},
+			},
+			Responses: map[string]openAPIResponse{
+				"BadRequest": {Description: "The receipt is invalid.", Content: jsonContent(schemaRef("Error"))},
+				"NotFound":   {Description: "No receipt found for that ID.", Content: jsonContent(schemaRef("Error"))},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI document for this service
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(openAPISpec())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var document map[string]interface{}
+	err := json.Unmarshal(rr.Body.Bytes(), &document)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0.3", document["openapi"])
+
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"Receipt", "Item", "ReceiptResponse", "PointsResponse"} {
+		assert.Contains(t, schemas, name)
+	}
+}
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	// Every documented operation must be served by the router
+	for path, operations := range openAPISpec().Paths {
+		for method := range operations {
+			url := strings.ReplaceAll(path, "{id}", "some-id")
+			req, _ := http.NewRequest(strings.ToUpper(method), url, nil)
+
+			var match mux.RouteMatch
+			assert.True(t, router.Match(req, &match), "%s %s", method, path)
+			assert.NoError(t, match.MatchErr, "%s %s", method, path)
+
+			template, _ := match.Route.GetPathTemplate()
+			assert.Equal(t, path, template, "%s %s", method, path)
+		}
+	}
+
+	// Every receipt route must be documented
+	spec := openAPISpec()
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, _ := route.GetPathTemplate()
+		if !strings.HasPrefix(template, "/receipts") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			assert.Contains(t, spec.Paths[template], strings.ToLower(method), "%s %s", method, template)
+		}
+		return nil
+	})
+}
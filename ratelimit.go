@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Default token bucket settings, overridable with RATE_LIMIT_RPS and RATE_LIMIT_BURST
+const (
+	defaultRateLimitRPS   = 10
+	defaultRateLimitBurst = 20
+)
+
+// Limiters for clients that haven't made a request within limiterIdleTimeout
+// are evicted by the sweeper
+const (
+	limiterIdleTimeout   = 3 * time.Minute
+	limiterSweepInterval = time.Minute
+)
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter keeps a token bucket per client IP
+type RateLimiter struct {
+	sync.Mutex
+	limiters map[string]*clientLimiter
+	rps      rate.Limit
+	burst    int
+
+	// trustedProxies are the proxies whose X-Forwarded-For header is
+	// believed; it is ignored for every other remote address
+	trustedProxies []netip.Prefix
+}
+
+func NewRateLimiter(rps float64, burst int, trustedProxies []netip.Prefix) *RateLimiter {
+	return &RateLimiter{
+		limiters:       make(map[string]*clientLimiter),
+		rps:            rate.Limit(rps),
+		burst:          burst,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// splitTrustedProxies splits a comma-separated TRUSTED_PROXIES value
+func splitTrustedProxies(value string) []string {
+	proxies := []string{}
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of
+// TRUSTED_PROXIES
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trustedProxies entry %q is not an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// limiterFor returns the limiter for the client, creating one on first use
+func (rl *RateLimiter) limiterFor(client string, now time.Time) *rate.Limiter {
+	rl.Lock()
+	defer rl.Unlock()
+
+	entry, exists := rl.limiters[client]
+	if !exists {
+		entry = &clientLimiter{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.limiters[client] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// sweep evicts limiters that have been idle since before the cutoff
+func (rl *RateLimiter) sweep(cutoff time.Time) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	for client, entry := range rl.limiters {
+		if entry.lastSeen.Before(cutoff) {
+			delete(rl.limiters, client)
+		}
+	}
+}
+
+// StartSweeper evicts idle client limiters in the background until ctx is done
+func (rl *RateLimiter) StartSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(limiterSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				rl.sweep(now.Add(-limiterIdleTimeout))
+			}
+		}
+	}()
+}
+
+// Middleware rejects requests with 429 once a client exhausts its bucket
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		reservation := rl.limiterFor(clientIP(r, rl.trustedProxies), now).ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+			reservation.CancelAt(now)
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP identifies the caller by the connection's remote address. When
+// that is a trusted proxy, the right-most X-Forwarded-For entry that isn't
+// one is used instead: entries to its left were sent by the client and can
+// be forged.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop, trustedProxies) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip falls in one of the trusted ranges
+func isTrustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+	limiter := NewRateLimiter(1, 5, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	handler := limiter.Middleware(router)
+
+	// Test case 1: Hammering the endpoint eventually returns 429
+	limited := 0
+	var limitedResponse *httptest.ResponseRecorder
+	for i := 0; i < 20; i++ {
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code == http.StatusTooManyRequests {
+			limited++
+			limitedResponse = rr
+		}
+	}
+	assert.Equal(t, 15, limited)
+	if assert.NotNil(t, limitedResponse) {
+		assert.Equal(t, "1", limitedResponse.Header().Get("Retry-After"))
+		assert.Equal(t, "Rate limit exceeded", decodeError(t, limitedResponse).Error)
+	}
+
+	// Test case 2: Other clients have their own bucket
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Test case 3: A spoofed X-Forwarded-For from an untrusted client doesn't
+	// get a new bucket
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotContains(t, limiter.limiters, "203.0.113.7")
+
+	// Test case 4: Clients behind a trusted proxy are keyed by X-Forwarded-For
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1, 203.0.113.7")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.9"})
+	assert.NoError(t, err)
+
+	// Test case 1: Without X-Forwarded-For the remote address is used
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "192.0.2.1", clientIP(req, trusted))
+
+	// Test case 2: X-Forwarded-For is ignored from untrusted remote addresses
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, "192.0.2.1", clientIP(req, trusted))
+
+	// Test case 3: Behind trusted proxies the right-most untrusted hop is
+	// used, so entries the client prepended are skipped
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.4, 203.0.113.7, 192.0.2.9, 10.0.0.2")
+	assert.Equal(t, "203.0.113.7", clientIP(req, trusted))
+
+	// Test case 4: When every hop is trusted the left-most one is used
+	req.Header.Set("X-Forwarded-For", "10.0.0.3, 192.0.2.9")
+	assert.Equal(t, "10.0.0.3", clientIP(req, trusted))
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := NewRateLimiter(1, 1, nil)
+	now := time.Now()
+	rl.limiterFor("idle", now.Add(-time.Hour))
+	rl.limiterFor("active", now)
+
+	rl.sweep(now.Add(-limiterIdleTimeout))
+
+	assert.NotContains(t, rl.limiters, "idle")
+	assert.Contains(t, rl.limiters, "active")
+}
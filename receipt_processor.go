@@ -1,19 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"io"
+	"log/slog"
 	"math"
+	"mime"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Data structures based on the API specification
@@ -23,6 +37,26 @@ type Receipt struct {
 	PurchaseTime string `json:"purchaseTime"`
 	Items        []Item `json:"items"`
 	Total        string `json:"total"`
+
+	// Currency is an ISO 4217 code; receipts without one are treated as USD
+	Currency string `json:"currency,omitempty"`
+
+	// PurchaseDateTime is an RFC 3339 alternative to the separate date and
+	// time fields. prepareReceipt derives those from it, and scoring uses its
+	// offset to find the instant of purchase.
+	PurchaseDateTime string `json:"purchaseDateTime,omitempty"`
+
+	// NormalizedRetailer is set by prepareReceipt when the normalizeRetailers
+	// rule is on, so stats group "  target  " with "Target"
+	NormalizedRetailer string `json:"normalizedRetailer,omitempty"`
+
+	// Label is a free-form tag such as "business" for filtering the listing.
+	// It is stored with the receipt but never scored.
+	Label string `json:"label,omitempty"`
+
+	// ClientID asks for the receipt to be stored under this id instead of a
+	// generated one. Handlers clear it before the receipt is stored.
+	ClientID string `json:"clientId,omitempty"`
 }
 
 type Item struct {
@@ -32,151 +66,1450 @@ type Item struct {
 
 type ReceiptResponse struct {
 	ID string `json:"id"`
+
+	// Points is only set when the client asks for it with ?includePoints=true
+	Points *int `json:"points,omitempty"`
+}
+
+// ValidateResponse previews a receipt's points without storing it
+type ValidateResponse struct {
+	Valid  bool `json:"valid"`
+	Points int  `json:"points"`
+}
+
+// LookupResponse identifies the stored copy of a receipt and its points
+type LookupResponse struct {
+	ID     string `json:"id"`
+	Points int    `json:"points"`
 }
 
 type PointsResponse struct {
 	Points int `json:"points"`
 }
 
-// In-memory storage
-type ReceiptStore struct {
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+
+	// Path is only set for requests that matched no route
+	Path string `json:"path,omitempty"`
+
+	// Errors lists every problem found when a receipt fails validation
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a single validation problem and the field it concerns
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ReceiptSummary is the listing view of a stored receipt
+type ReceiptSummary struct {
+	ID                 string `json:"id"`
+	Retailer           string `json:"retailer"`
+	NormalizedRetailer string `json:"normalizedRetailer,omitempty"`
+	PurchaseDate       string `json:"purchaseDate"`
+	Total              string `json:"total"`
+	Points             int    `json:"points"`
+	Label              string `json:"label,omitempty"`
+
+	// AddedAt is when the receipt was stored. It is only set by ChangesSince.
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+}
+
+// ReceiptFilter narrows the receipts returned by ListReceipts. Empty fields
+// match every receipt.
+type ReceiptFilter struct {
+	Label string
+}
+
+func (f ReceiptFilter) matches(summary ReceiptSummary) bool {
+	return f.Label == "" || summary.Label == f.Label
+}
+
+// filterSummaries keeps the summaries that match the filter
+func filterSummaries(summaries []ReceiptSummary, filter ReceiptFilter) []ReceiptSummary {
+	matched := summaries[:0]
+	for _, summary := range summaries {
+		if filter.matches(summary) {
+			matched = append(matched, summary)
+		}
+	}
+	return matched
+}
+
+// ReceiptChangesResponse lists the receipts added after since, oldest first
+type ReceiptChangesResponse struct {
+	Receipts []ReceiptSummary `json:"receipts"`
+	Since    time.Time        `json:"since"`
+}
+
+type ReceiptListResponse struct {
+	Receipts []ReceiptSummary `json:"receipts"`
+	Total    int              `json:"total"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+}
+
+// StatsResponse aggregates points over every stored receipt
+type StatsResponse struct {
+	Receipts      int            `json:"receipts"`
+	TotalPoints   int            `json:"totalPoints"`
+	AveragePoints float64        `json:"averagePoints"`
+	Retailers     map[string]int `json:"retailers"`
+}
+
+func newStatsResponse() StatsResponse {
+	return StatsResponse{Retailers: map[string]int{}}
+}
+
+// add counts one receipt and its points towards the stats
+func (stats *StatsResponse) add(receipt Receipt, points int) {
+	stats.Receipts++
+	stats.TotalPoints += points
+	stats.AveragePoints = float64(stats.TotalPoints) / float64(stats.Receipts)
+	stats.Retailers[receipt.retailerKey()]++
+}
+
+// retailerKey returns the name stats group the receipt under: the normalized
+// retailer when there is one, or the retailer as submitted
+func (r Receipt) retailerKey() string {
+	if r.NormalizedRetailer != "" {
+		return r.NormalizedRetailer
+	}
+	return r.Retailer
+}
+
+type LeaderboardResponse struct {
+	Receipts []ReceiptSummary `json:"receipts"`
+	Limit    int              `json:"limit"`
+}
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// BatchResult reports the outcome for one receipt of a batch submission
+type BatchResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// PointsBreakdown describes how much a single rule contributed to a receipt's points
+type PointsBreakdown struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	Points      int    `json:"points"`
+}
+
+// Store is implemented by every receipt storage backend. Every method takes
+// the request context so backends that do I/O can honor cancellation; the
+// in-memory store ignores it.
+type Store interface {
+	AddReceipt(ctx context.Context, receipt Receipt) string
+	GetPoints(ctx context.Context, id string) (int, bool)
+
+	// GetBreakdown returns how the stored points were calculated, with
+	// entries summing to them. It is saved when the receipt is scored, so a
+	// later ruleset change doesn't alter it until the points are recomputed.
+	GetBreakdown(ctx context.Context, id string) ([]PointsBreakdown, bool)
+
+	GetReceipt(ctx context.Context, id string) (Receipt, bool)
+	DeleteReceipt(ctx context.Context, id string) bool
+	Ping(ctx context.Context) error
+
+	// AddReceiptIdempotent stores the receipt the first time a key is seen and
+	// returns the original id, with replayed set, when the key is repeated for
+	// the same receipt. A different receipt under a used key is rejected with
+	// ErrIdempotencyConflict.
+	AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (id string, replayed bool, err error)
+
+	// AddReceiptWithID stores the receipt under an id chosen by the client,
+	// returning ErrReceiptExists if a receipt is already stored under it.
+	// Identical receipts are stored again rather than deduplicated.
+	AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error
+
+	// ListReceipts returns one page of the receipt summaries matching filter,
+	// ordered by purchase date then id, along with the total number of
+	// matching receipts.
+	ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int)
+
+	// RecomputeAll rescores every stored receipt with the active ruleset and
+	// returns how many receipts were updated.
+	RecomputeAll(ctx context.Context) int
+
+	// Stats aggregates points across every stored receipt.
+	Stats(ctx context.Context) StatsResponse
+
+	// TopReceipts returns the n receipts with the most points, highest first,
+	// with ties broken by id.
+	TopReceipts(ctx context.Context, n int) []ReceiptSummary
+
+	// ReceiptIDs returns the id of every stored receipt, sorted.
+	ReceiptIDs(ctx context.Context) []string
+
+	// ImportReceipt stores a receipt under the given id with the given points
+	// and their breakdown, replacing any receipt already stored under that id.
+	ImportReceipt(ctx context.Context, id string, receipt Receipt, points int, breakdown []PointsBreakdown) error
+
+	// FindReceipt returns the id of a stored receipt identical to receipt,
+	// comparing their receiptHash. Nothing is stored.
+	FindReceipt(ctx context.Context, receipt Receipt) (string, bool)
+
+	// ChangesSince returns a summary, with AddedAt set, of every receipt
+	// stored after since, ordered by when it was stored and then by id.
+	ChangesSince(ctx context.Context, since time.Time) []ReceiptSummary
+
+	// Clear removes every stored receipt, along with its points and any
+	// idempotency keys, and returns how many receipts were removed.
+	Clear(ctx context.Context) int
+}
+
+// ErrIdempotencyConflict reports an Idempotency-Key reused for a different receipt
+var ErrIdempotencyConflict = errors.New("idempotency key was already used for a different receipt")
+
+// ErrReceiptExists reports a client-supplied id that is already in use
+var ErrReceiptExists = errors.New("a receipt with that id already exists")
+
+// IdempotencyKeyHeader lets clients safely retry receipt submissions
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	_ Store = (*ReceiptStore)(nil)
+	_ Store = (*BoltReceiptStore)(nil)
+	_ Store = (*RedisReceiptStore)(nil)
+)
+
+// defaultMaxBodyBytes limits request bodies unless MAX_BODY_BYTES is set
+const defaultMaxBodyBytes = 1 << 20
+
+// Server holds the HTTP handlers and the store they operate on
+type Server struct {
+	store        Store
+	maxBodyBytes int64
+
+	// maxLeaderboardLimit caps the limit accepted by /leaderboard
+	maxLeaderboardLimit int
+
+	// adminSecret must be sent in the X-Admin-Secret header to use the admin
+	// endpoints; they are disabled when it is empty
+	adminSecret string
+
+	// ocr reads receipts out of images uploaded to /receipts/upload
+	ocr OCRProcessor
+
+	// events publishes the receipts stored through the server to
+	// /receipts/stream
+	events *ReceiptBroker
+}
+
+// NewServer returns a Server backed by the given store, defaulting to the
+// in-memory store when none is provided.
+func NewServer(store Store) *Server {
+	if store == nil {
+		store = NewReceiptStore()
+	}
+	events := NewReceiptBroker()
+	return &Server{
+		store:               events.PublishStore(store),
+		events:              events,
+		maxBodyBytes:        defaultMaxBodyBytes,
+		maxLeaderboardLimit: defaultMaxLeaderboardLimit,
+		ocr:                 stubOCRProcessor{},
+	}
+}
+
+// receiptHash returns a stable SHA-256 hash of the receipt's canonical JSON
+// encoding, so identical receipts always hash the same.
+func receiptHash(receipt Receipt) string {
+	encoded, _ := json.Marshal(receipt)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// defaultReceiptShards is the number of shards in a new ReceiptStore. It must
+// be a power of two so that a shard can be picked by masking the id's hash.
+const defaultReceiptShards = 32
+
+// receiptShard holds the receipts whose ids hash to it, under its own lock
+type receiptShard struct {
 	sync.RWMutex
-	receipts map[string]Receipt
-	points   map[string]int
+	receipts   map[string]Receipt
+	points     map[string]int
+	breakdowns map[string][]PointsBreakdown
+	addedAt    map[string]time.Time
+}
+
+// In-memory storage. Receipts are spread across shards by a hash of their id
+// so that writes to different shards don't wait on each other.
+type ReceiptStore struct {
+	shards []*receiptShard
+
+	// indexMu guards the indexes that span shards. Only idempotent adds, and
+	// adds and deletes with dedup or a receipt cap enabled, take it, always
+	// before any shard lock.
+	indexMu         sync.Mutex
+	idempotencyKeys map[string]string
+	hashToID        map[string]string
+	addOrder        *addOrder
+
+	// dedup makes AddReceipt return the existing id for identical receipts
+	dedup bool
+
+	// ttl evicts receipts this long after they are stored; zero keeps them forever
+	ttl time.Duration
+
+	// idGenerator creates the id of each new receipt
+	idGenerator IDGenerator
+
+	// maxReceipts evicts the oldest receipts once more than this many are
+	// stored; zero is unlimited
+	maxReceipts int
 }
 
 func NewReceiptStore() *ReceiptStore {
+	return newShardedReceiptStore(defaultReceiptShards)
+}
+
+// newShardedReceiptStore creates a store with count shards, which must be a
+// power of two. A single shard behaves like one store-wide lock.
+func newShardedReceiptStore(count int) *ReceiptStore {
+	shards := make([]*receiptShard, count)
+	for i := range shards {
+		shards[i] = &receiptShard{
+			receipts:   make(map[string]Receipt),
+			points:     make(map[string]int),
+			breakdowns: make(map[string][]PointsBreakdown),
+			addedAt:    make(map[string]time.Time),
+		}
+	}
+
 	return &ReceiptStore{
-		receipts: make(map[string]Receipt),
-		points:   make(map[string]int),
+		shards:          shards,
+		idempotencyKeys: make(map[string]string),
+		hashToID:        make(map[string]string),
+		addOrder:        newAddOrder(),
+		idGenerator:     UUIDGenerator{},
 	}
 }
 
-func (rs *ReceiptStore) AddReceipt(receipt Receipt) string {
-	rs.Lock()
-	defer rs.Unlock()
+// shardFor returns the shard that holds id
+func (rs *ReceiptStore) shardFor(id string) *receiptShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return rs.shards[hash.Sum32()&uint32(len(rs.shards)-1)]
+}
+
+// expiredLocked reports whether the receipt has outlived the store's TTL. The
+// caller must hold the shard's lock.
+func (rs *ReceiptStore) expiredLocked(shard *receiptShard, id string, now time.Time) bool {
+	return rs.ttl > 0 && now.Sub(shard.addedAt[id]) >= rs.ttl
+}
+
+// usesIndex reports whether adds and deletes must hold indexMu, because dedup
+// or the receipt cap keeps an index across shards
+func (rs *ReceiptStore) usesIndex() bool {
+	return rs.dedup || rs.maxReceipts > 0
+}
 
-	id := uuid.New().String()
-	rs.receipts[id] = receipt
+func (rs *ReceiptStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	// Scoring is CPU-bound, so it happens before any lock is taken
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
 
-	// Calculate points for the receipt
-	points := calculatePoints(receipt)
-	rs.points[id] = points
+	if rs.usesIndex() {
+		rs.indexMu.Lock()
+		defer rs.indexMu.Unlock()
+	}
 
+	id, added := rs.addReceipt(receipt, points, breakdown)
+	if added {
+		reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+	}
 	return id
 }
 
-func (rs *ReceiptStore) GetPoints(id string) (int, bool) {
-	rs.RLock()
-	defer rs.RUnlock()
+func (rs *ReceiptStore) AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (string, bool, error) {
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+
+	rs.indexMu.Lock()
+	defer rs.indexMu.Unlock()
+
+	// A key whose receipt has since been deleted is treated as unused
+	if id, exists := rs.idempotencyKeys[key]; exists {
+		if stored, found := rs.getReceipt(id); found {
+			if !reflect.DeepEqual(stored, receipt) {
+				return "", false, ErrIdempotencyConflict
+			}
+			return id, true, nil
+		}
+	}
+
+	id, added := rs.addReceipt(receipt, points, breakdown)
+	if added {
+		reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+	}
+	rs.idempotencyKeys[key] = id
+	return id, false, nil
+}
+
+func (rs *ReceiptStore) AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error {
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+
+	if rs.usesIndex() {
+		rs.indexMu.Lock()
+		defer rs.indexMu.Unlock()
+	}
+
+	shard := rs.shardFor(id)
+	shard.Lock()
+	if _, exists := shard.receipts[id]; exists {
+		// An expired receipt that hasn't been swept yet frees its id
+		if !rs.expiredLocked(shard, id, time.Now()) {
+			shard.Unlock()
+			return ErrReceiptExists
+		}
+		rs.deleteLocked(shard, id)
+	}
+	shard.receipts[id] = receipt
+	shard.points[id] = points
+	shard.breakdowns[id] = breakdown
+	shard.addedAt[id] = time.Now()
+	shard.Unlock()
 
-	points, exists := rs.points[id]
-	return points, exists
+	if rs.dedup {
+		rs.hashToID[receiptHash(receipt)] = id
+	}
+	if rs.maxReceipts > 0 {
+		rs.addOrder.push(id)
+		rs.evictOverCap()
+	}
+	reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+	return nil
 }
 
-// Points calculation logic
-func calculatePoints(receipt Receipt) int {
-	points := 0
+// addReceipt stores a receipt and its already calculated points and breakdown
+// under a new id, or returns the id of an identical receipt, and false, when
+// dedup is enabled. The caller must hold indexMu when usesIndex is true.
+func (rs *ReceiptStore) addReceipt(receipt Receipt, points int, breakdown []PointsBreakdown) (string, bool) {
+	var hash string
+	if rs.dedup {
+		hash = receiptHash(receipt)
+		if existing, exists := rs.hashToID[hash]; exists {
+			if _, found := rs.getReceipt(existing); found {
+				return existing, false
+			}
+		}
+	}
+
+	id := rs.idGenerator.Generate()
+	shard := rs.shardFor(id)
+	shard.Lock()
+	shard.receipts[id] = receipt
+	shard.points[id] = points
+	shard.breakdowns[id] = breakdown
+	shard.addedAt[id] = time.Now()
+	shard.Unlock()
 
-	// Rule 1: One point for every alphanumeric character in the retailer name
-	alphanumericRegex := regexp.MustCompile(`[a-zA-Z0-9]`)
-	retailerAlphanumeric := alphanumericRegex.FindAllString(receipt.Retailer, -1)
-	points += len(retailerAlphanumeric)
+	if rs.dedup {
+		rs.hashToID[hash] = id
+	}
+	if rs.maxReceipts > 0 {
+		rs.addOrder.push(id)
+		rs.evictOverCap()
+	}
+	return id, true
+}
 
-	// Rule 2: 50 points if the total is a round dollar amount with no cents
-	total, _ := strconv.ParseFloat(receipt.Total, 64)
-	if total == math.Floor(total) {
-		points += 50
+// evictOverCap deletes the oldest receipts until at most maxReceipts are
+// stored. The caller must hold indexMu and no shard lock.
+func (rs *ReceiptStore) evictOverCap() {
+	for rs.addOrder.len() > rs.maxReceipts {
+		id, _ := rs.addOrder.oldest()
+		shard := rs.shardFor(id)
+		shard.Lock()
+		rs.deleteLocked(shard, id)
+		shard.Unlock()
 	}
+}
+
+func (rs *ReceiptStore) GetPoints(ctx context.Context, id string) (int, bool) {
+	shard := rs.shardFor(id)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	// Rule 3: 25 points if the total is a multiple of 0.25
-	if math.Mod(total*100, 25) == 0 {
-		points += 25
+	points, exists := shard.points[id]
+	if !exists || rs.expiredLocked(shard, id, time.Now()) {
+		return 0, false
 	}
+	return points, true
+}
 
-	// Rule 4: 5 points for every two items on the receipt
-	points += (len(receipt.Items) / 2) * 5
+func (rs *ReceiptStore) GetBreakdown(ctx context.Context, id string) ([]PointsBreakdown, bool) {
+	shard := rs.shardFor(id)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	// Rule 5: If the trimmed length of the item description is a multiple of 3,
-	// multiply the price by 0.2 and round up to the nearest integer
-	for _, item := range receipt.Items {
-		trimmedDesc := strings.TrimSpace(item.ShortDescription)
-		if len(trimmedDesc)%3 == 0 {
-			price, _ := strconv.ParseFloat(item.Price, 64)
-			points += int(math.Ceil(price * 0.2))
+	breakdown, exists := shard.breakdowns[id]
+	if !exists || rs.expiredLocked(shard, id, time.Now()) {
+		return nil, false
+	}
+	return breakdown, true
+}
+
+func (rs *ReceiptStore) GetReceipt(ctx context.Context, id string) (Receipt, bool) {
+	return rs.getReceipt(id)
+}
+
+func (rs *ReceiptStore) getReceipt(id string) (Receipt, bool) {
+	shard := rs.shardFor(id)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	receipt, exists := shard.receipts[id]
+	if !exists || rs.expiredLocked(shard, id, time.Now()) {
+		return Receipt{}, false
+	}
+	return receipt, true
+}
+
+func (rs *ReceiptStore) FindReceipt(ctx context.Context, receipt Receipt) (string, bool) {
+	hash := receiptHash(receipt)
+
+	// The hash index is only kept with dedup enabled; otherwise every live
+	// receipt is hashed in turn
+	if rs.dedup {
+		rs.indexMu.Lock()
+		id, exists := rs.hashToID[hash]
+		rs.indexMu.Unlock()
+		if !exists {
+			return "", false
+		}
+		_, found := rs.getReceipt(id)
+		return id, found
+	}
+
+	now := time.Now()
+	for _, shard := range rs.shards {
+		shard.RLock()
+		for id, stored := range shard.receipts {
+			if !rs.expiredLocked(shard, id, now) && receiptHash(stored) == hash {
+				shard.RUnlock()
+				return id, true
+			}
+		}
+		shard.RUnlock()
+	}
+	return "", false
+}
+
+func (rs *ReceiptStore) DeleteReceipt(ctx context.Context, id string) bool {
+	if rs.usesIndex() {
+		rs.indexMu.Lock()
+		defer rs.indexMu.Unlock()
+	}
+
+	shard := rs.shardFor(id)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, exists := shard.receipts[id]; !exists || rs.expiredLocked(shard, id, time.Now()) {
+		return false
+	}
+	rs.deleteLocked(shard, id)
+	return true
+}
+
+// deleteLocked removes a receipt and everything indexed by it. The caller
+// must hold the shard's write lock, and indexMu when usesIndex is true.
+func (rs *ReceiptStore) deleteLocked(shard *receiptShard, id string) {
+	if rs.dedup {
+		if hash := receiptHash(shard.receipts[id]); rs.hashToID[hash] == id {
+			delete(rs.hashToID, hash)
+		}
+	}
+	if rs.maxReceipts > 0 {
+		rs.addOrder.remove(id)
+	}
+	delete(shard.receipts, id)
+	delete(shard.points, id)
+	delete(shard.breakdowns, id)
+	delete(shard.addedAt, id)
+}
+
+func (rs *ReceiptStore) ReceiptIDs(ctx context.Context) []string {
+	now := time.Now()
+	ids := []string{}
+	for _, shard := range rs.shards {
+		shard.RLock()
+		for id := range shard.receipts {
+			if !rs.expiredLocked(shard, id, now) {
+				ids = append(ids, id)
+			}
+		}
+		shard.RUnlock()
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (rs *ReceiptStore) ImportReceipt(ctx context.Context, id string, receipt Receipt, points int, breakdown []PointsBreakdown) error {
+	if rs.usesIndex() {
+		rs.indexMu.Lock()
+		defer rs.indexMu.Unlock()
+	}
+
+	shard := rs.shardFor(id)
+	shard.Lock()
+	if _, exists := shard.receipts[id]; exists {
+		rs.deleteLocked(shard, id)
+	}
+	shard.receipts[id] = receipt
+	shard.points[id] = points
+	shard.breakdowns[id] = breakdown
+	shard.addedAt[id] = time.Now()
+	shard.Unlock()
+
+	if rs.dedup {
+		rs.hashToID[receiptHash(receipt)] = id
+	}
+	if rs.maxReceipts > 0 {
+		rs.addOrder.push(id)
+		rs.evictOverCap()
+	}
+	return nil
+}
+
+// sweepExpired evicts every receipt that has outlived the TTL, one shard at a
+// time
+func (rs *ReceiptStore) sweepExpired(now time.Time) {
+	if rs.usesIndex() {
+		rs.indexMu.Lock()
+		defer rs.indexMu.Unlock()
+	}
+
+	for _, shard := range rs.shards {
+		shard.Lock()
+		for id := range shard.receipts {
+			if rs.expiredLocked(shard, id, now) {
+				rs.deleteLocked(shard, id)
+			}
 		}
+		shard.Unlock()
+	}
+}
+
+// StartExpirySweeper evicts expired receipts every minute until ctx is done.
+// It does nothing when the store has no TTL.
+func (rs *ReceiptStore) StartExpirySweeper(ctx context.Context) {
+	if rs.ttl <= 0 {
+		return
 	}
 
-	// Rule 6: 6 points if the day in the purchase date is odd
-	purchaseDate, _ := time.Parse("2006-01-02", receipt.PurchaseDate)
-	if purchaseDate.Day()%2 == 1 {
-		points += 6
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				rs.sweepExpired(now)
+			}
+		}
+	}()
+}
+
+func (rs *ReceiptStore) ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int) {
+	summaries := filterSummaries(rs.summaries(), filter)
+	return paginateSummaries(summaries, limit, offset), len(summaries)
+}
+
+func (rs *ReceiptStore) TopReceipts(ctx context.Context, n int) []ReceiptSummary {
+	return topSummaries(rs.summaries(), n)
+}
+
+// summaries returns a summary of every live receipt, in no particular order
+func (rs *ReceiptStore) summaries() []ReceiptSummary {
+	now := time.Now()
+	summaries := []ReceiptSummary{}
+	for _, shard := range rs.shards {
+		shard.RLock()
+		for id, receipt := range shard.receipts {
+			if !rs.expiredLocked(shard, id, now) {
+				summaries = append(summaries, newReceiptSummary(id, receipt, shard.points[id]))
+			}
+		}
+		shard.RUnlock()
 	}
+	return summaries
+}
 
-	// Rule 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm
-	purchaseTime, _ := time.Parse("15:04", receipt.PurchaseTime)
-	purchaseHour := purchaseTime.Hour()
-	purchaseMinute := purchaseTime.Minute()
-	if (purchaseHour == 14 && purchaseMinute > 0) ||
-		(purchaseHour == 15) ||
-		(purchaseHour == 16 && purchaseMinute == 0) {
-		points += 10
+func newReceiptSummary(id string, receipt Receipt, points int) ReceiptSummary {
+	return ReceiptSummary{
+		ID:                 id,
+		Retailer:           receipt.Retailer,
+		NormalizedRetailer: receipt.NormalizedRetailer,
+		PurchaseDate:       receipt.PurchaseDate,
+		Total:              receipt.Total,
+		Points:             points,
+		Label:              receipt.Label,
 	}
+}
+
+// newReceiptChange summarizes a receipt stored at addedAt
+func newReceiptChange(id string, receipt Receipt, points int, addedAt time.Time) ReceiptSummary {
+	summary := newReceiptSummary(id, receipt, points)
+	summary.AddedAt = &addedAt
+	return summary
+}
+
+// sortChanges orders receipt changes by when they were stored, then by id
+func sortChanges(changes []ReceiptSummary) {
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].AddedAt.Equal(*changes[j].AddedAt) {
+			return changes[i].AddedAt.Before(*changes[j].AddedAt)
+		}
+		return changes[i].ID < changes[j].ID
+	})
+}
 
-	return points
+// paginateSummaries sorts summaries by purchase date then id, so pages are
+// repeatable, and returns the requested page.
+func paginateSummaries(summaries []ReceiptSummary, limit, offset int) []ReceiptSummary {
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].PurchaseDate != summaries[j].PurchaseDate {
+			return summaries[i].PurchaseDate < summaries[j].PurchaseDate
+		}
+		return summaries[i].ID < summaries[j].ID
+	})
+
+	if offset >= len(summaries) {
+		return []ReceiptSummary{}
+	}
+	end := offset + limit
+	if end > len(summaries) {
+		end = len(summaries)
+	}
+	return summaries[offset:end]
+}
+
+func (rs *ReceiptStore) RecomputeAll(ctx context.Context) int {
+	now := time.Now()
+	rules := currentRules()
+	updated := 0
+	for _, shard := range rs.shards {
+		shard.Lock()
+		for id, receipt := range shard.receipts {
+			if rs.expiredLocked(shard, id, now) {
+				continue
+			}
+			shard.points[id], shard.breakdowns[id] = calculatePointsDetailed(receipt, rules)
+			reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: shard.points[id], Rules: rules})
+			updated++
+		}
+		shard.Unlock()
+	}
+	return updated
+}
+
+// Clear holds the index lock and every shard lock while it empties the store,
+// so no add or delete is seen half applied
+func (rs *ReceiptStore) Clear(ctx context.Context) int {
+	rs.indexMu.Lock()
+	defer rs.indexMu.Unlock()
+
+	now := time.Now()
+	cleared := 0
+	for _, shard := range rs.shards {
+		shard.Lock()
+		defer shard.Unlock()
+		for id := range shard.receipts {
+			if !rs.expiredLocked(shard, id, now) {
+				cleared++
+			}
+		}
+	}
+
+	for _, shard := range rs.shards {
+		shard.receipts = make(map[string]Receipt)
+		shard.points = make(map[string]int)
+		shard.breakdowns = make(map[string][]PointsBreakdown)
+		shard.addedAt = make(map[string]time.Time)
+	}
+	rs.idempotencyKeys = make(map[string]string)
+	rs.hashToID = make(map[string]string)
+	rs.addOrder = newAddOrder()
+	return cleared
+}
+
+func (rs *ReceiptStore) ChangesSince(ctx context.Context, since time.Time) []ReceiptSummary {
+	now := time.Now()
+	changes := []ReceiptSummary{}
+	for _, shard := range rs.shards {
+		shard.RLock()
+		for id, receipt := range shard.receipts {
+			addedAt := shard.addedAt[id]
+			if addedAt.After(since) && !rs.expiredLocked(shard, id, now) {
+				changes = append(changes, newReceiptChange(id, receipt, shard.points[id], addedAt))
+			}
+		}
+		shard.RUnlock()
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func (rs *ReceiptStore) Stats(ctx context.Context) StatsResponse {
+	now := time.Now()
+	stats := newStatsResponse()
+	for _, shard := range rs.shards {
+		shard.RLock()
+		for id, receipt := range shard.receipts {
+			if !rs.expiredLocked(shard, id, now) {
+				stats.add(receipt, shard.points[id])
+			}
+		}
+		shard.RUnlock()
+	}
+	return stats
+}
+
+// topSummaries sorts summaries by points, highest first with ties broken by
+// id, and returns the first n.
+func topSummaries(summaries []ReceiptSummary, n int) []ReceiptSummary {
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Points != summaries[j].Points {
+			return summaries[i].Points > summaries[j].Points
+		}
+		return summaries[i].ID < summaries[j].ID
+	})
+
+	if n < len(summaries) {
+		summaries = summaries[:n]
+	}
+	return summaries
+}
+
+// Ping always succeeds since the in-memory store has no backing service
+func (rs *ReceiptStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// writeJSONError sends an error response in the same JSON style as successes
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeErrorResponse(w, ErrorResponse{Error: message, Status: status})
+}
+
+// writeValidationError sends the response for a receipt that failed
+// prepareReceipt, listing every validation problem found
+func writeValidationError(w http.ResponseWriter, err error) {
+	recordValidationReason(w, err)
+	writeErrorResponse(w, validationErrorResponse(err))
+}
+
+// validationErrorResponse describes an error from prepareReceipt, with the
+// first problem as the error and every problem under Errors
+func validationErrorResponse(err error) ErrorResponse {
+	status, message := validationStatus(err)
+	response := ErrorResponse{Error: message, Status: status}
+
+	var errs ValidationErrors
+	var single *ValidationError
+	switch {
+	case errors.As(err, &errs):
+		for _, e := range errs {
+			response.Errors = append(response.Errors, FieldError{Field: e.Field, Message: e.Message})
+		}
+	case errors.As(err, &single):
+		response.Errors = []FieldError{{Field: single.Field, Message: single.Message}}
+	}
+	return response
+}
+
+// writeErrorResponse sends a prepared ErrorResponse with its status code
+func writeErrorResponse(w http.ResponseWriter, response ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response)
 }
 
 // HTTP Handlers
-func (rs *ReceiptStore) ProcessReceiptHandler(w http.ResponseWriter, r *http.Request) {
-	var receipt Receipt
-	err := json.NewDecoder(r.Body).Decode(&receipt)
-	if err != nil {
-		http.Error(w, "Invalid receipt format", http.StatusBadRequest)
+
+// NotFoundHandler answers requests for unknown routes with a JSON error
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: "Not found", Status: http.StatusNotFound, Path: r.URL.Path})
+}
+
+func (s *Server) ProcessReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := s.readValidReceipt(w, r)
+	if !ok {
 		return
 	}
+	s.storeAndRespond(w, r, receipt)
+}
 
-	// Basic validation
-	if receipt.Retailer == "" || receipt.PurchaseDate == "" || receipt.PurchaseTime == "" || receipt.Total == "" {
-		http.Error(w, "Missing required receipt fields", http.StatusBadRequest)
+// storeAndRespond stores a validated receipt and writes its id, honouring the
+// Idempotency-Key header and the includePoints query parameter
+func (s *Server) storeAndRespond(w http.ResponseWriter, r *http.Request, receipt Receipt) {
+	onConflict, conflictErr := onConflictMode(r)
+	if conflictErr != nil {
+		writeJSONError(w, conflictErr.status, conflictErr.message)
 		return
 	}
 
-	// Validate date format (YYYY-MM-DD)
-	_, err = time.Parse("2006-01-02", receipt.PurchaseDate)
+	// Process receipt and generate ID, reusing the original ID for retries,
+	// unless the client chose its own
+	var id string
+	var ok bool
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if receipt.ClientID != "" {
+		if key != "" {
+			writeJSONError(w, http.StatusBadRequest, "clientId cannot be combined with "+IdempotencyKeyHeader)
+			return
+		}
+		if id, ok = s.addClientReceipt(w, r, receipt, onConflict); !ok {
+			return
+		}
+	} else if key != "" {
+		var err error
+		id, _, err = s.store.AddReceiptIdempotent(r.Context(), key, receipt)
+		if errors.Is(err, ErrIdempotencyConflict) {
+			writeJSONError(w, http.StatusConflict, "Idempotency-Key was already used for a different receipt")
+			return
+		}
+		if err != nil {
+			slog.Error("failed to store receipt", "error", err)
+		}
+	} else {
+		id = s.store.AddReceipt(r.Context(), receipt)
+	}
+	if id == "" {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store receipt")
+		return
+	}
+	logProcessedReceipt(r.Context(), id, receipt)
+
+	response := ReceiptResponse{ID: id}
+	if r.URL.Query().Get("includePoints") == "true" {
+		if points, exists := s.store.GetPoints(r.Context(), id); exists {
+			response.Points = &points
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// addClientReceipt stores a receipt under its clientId, writing the error
+// response and returning false when the id is taken or the write fails
+func (s *Server) addClientReceipt(w http.ResponseWriter, r *http.Request, receipt Receipt, onConflict string) (string, bool) {
+	id, err := s.addWithClientID(r.Context(), receipt, onConflict)
+	if errors.Is(err, ErrReceiptExists) {
+		writeJSONError(w, http.StatusConflict, "A receipt with that clientId already exists")
+		return "", false
+	}
+	if err != nil {
+		slog.Error("failed to store receipt", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store receipt")
+		return "", false
+	}
+	return id, true
+}
+
+// Ways of handling a clientId that is already taken, chosen with ?onConflict
+const (
+	onConflictReject = "reject"
+	onConflictSuffix = "suffix"
+)
+
+// maxClientIDSuffix bounds how many suffixed ids are tried for one receipt
+const maxClientIDSuffix = 100
+
+// onConflictMode reads ?onConflict, which defaults to reject
+func onConflictMode(r *http.Request) (string, *requestError) {
+	switch mode := r.URL.Query().Get("onConflict"); mode {
+	case "", onConflictReject:
+		return onConflictReject, nil
+	case onConflictSuffix:
+		return onConflictSuffix, nil
+	default:
+		return "", &requestError{http.StatusBadRequest, "Invalid onConflict. Expected suffix or reject"}
+	}
+}
+
+// addWithClientID stores a receipt under its clientId and returns the id used.
+// With onConflictSuffix a taken id is retried as id-2, id-3 and so on, so the
+// receipt is stored under the first free one. ErrReceiptExists is returned
+// when the id is taken and no suffix was tried, or every suffix is taken too.
+func (s *Server) addWithClientID(ctx context.Context, receipt Receipt, onConflict string) (string, error) {
+	clientID := receipt.ClientID
+	receipt.ClientID = ""
+
+	id := clientID
+	err := s.store.AddReceiptWithID(ctx, id, receipt)
+	for n := 2; errors.Is(err, ErrReceiptExists) && onConflict == onConflictSuffix && n <= maxClientIDSuffix; n++ {
+		id = fmt.Sprintf("%s-%d", clientID, n)
+		err = s.store.AddReceiptWithID(ctx, id, receipt)
+	}
 	if err != nil {
-		http.Error(w, "Invalid purchase date format. Expected YYYY-MM-DD", http.StatusBadRequest)
+		return "", err
+	}
+	return id, nil
+}
+
+// ValidateReceiptHandler runs the same checks as ProcessReceiptHandler and
+// previews the points, but never stores the receipt
+func (s *Server) ValidateReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := s.readValidReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ValidateResponse{Valid: true, Points: calculatePoints(receipt, currentRules())})
+}
+
+// LookupPointsHandler finds a stored receipt identical to the one in the
+// request and returns its id and points, for clients that kept the receipt
+// but not its id. It never stores the receipt.
+func (s *Server) LookupPointsHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := s.readValidReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	// The clientId isn't part of the stored receipt
+	receipt.ClientID = ""
+	id, found := s.store.FindReceipt(r.Context(), receipt)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "No matching receipt found")
+		return
+	}
+	points, exists := s.store.GetPoints(r.Context(), id)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "No matching receipt found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LookupResponse{ID: id, Points: points})
+}
+
+// ScoreHandler validates a receipt and returns only its points. Nothing is
+// stored and no id is issued.
+func (s *Server) ScoreHandler(w http.ResponseWriter, r *http.Request) {
+	receipt, ok := s.readValidReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PointsResponse{Points: calculatePoints(receipt, currentRules())})
+}
+
+// ScoreWithRulesRequest pairs a receipt with a ruleset to score it with.
+// Rules missing from the ruleset keep their default values.
+type ScoreWithRulesRequest struct {
+	Receipt Receipt         `json:"receipt"`
+	Rules   json.RawMessage `json:"rules"`
+}
+
+type ScoreWithRulesResponse struct {
+	Points    int               `json:"points"`
+	Breakdown []PointsBreakdown `json:"breakdown"`
+}
+
+// ScoreWithRulesHandler scores a receipt with the ruleset posted alongside it,
+// so rule values can be tried out before they are deployed. The ruleset is
+// neither registered nor made active, and the receipt isn't stored. The
+// receipt is validated for the posted rules, so they decide which retailer
+// names are accepted.
+func (s *Server) ScoreWithRulesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	var request ScoreWithRulesRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid request format. Expected {\"receipt\": ..., \"rules\": ...}")
+		return
+	}
+	if len(request.Rules) == 0 || string(request.Rules) == "null" {
+		writeJSONError(w, http.StatusBadRequest, "Missing rules")
 		return
 	}
 
-	// Validate time format (HH:MM)
-	_, err = time.Parse("15:04", receipt.PurchaseTime)
+	rules, err := decodeRuleSet(bytes.NewReader(request.Rules))
 	if err != nil {
-		http.Error(w, "Invalid purchase time format. Expected HH:MM", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ruleset: "+strings.TrimPrefix(err.Error(), "json: "))
+		return
+	}
+	if err := prepareReceiptWith(&request.Receipt, rules); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	points, breakdown := calculatePointsDetailed(request.Receipt, rules)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ScoreWithRulesResponse{Points: points, Breakdown: breakdown})
+}
+
+// RulesDescriptionResponse explains the active ruleset, one sentence per rule
+type RulesDescriptionResponse struct {
+	Rules []string `json:"rules"`
+}
+
+// RulesDescriptionHandler describes how points are calculated with the active
+// ruleset. The sentences are generated from the RuleSet, so they follow any
+// change to the rules. They are sent as a plain-text list when text/plain is
+// preferred in Accept.
+func (s *Server) RulesDescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	rules := currentRules().Describe()
+
+	w.Header().Set("Vary", "Accept")
+	if prefersPlainText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, rule := range rules {
+			fmt.Fprintf(w, "* %s\n", rule)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RulesDescriptionResponse{Rules: rules})
+}
+
+// readValidReceipt decodes and validates the receipt in the request body,
+// writing the error response and returning false when it is rejected
+func (s *Server) readValidReceipt(w http.ResponseWriter, r *http.Request) (Receipt, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	receipt, decodeErr := decodeReceipt(r)
+	if decodeErr != nil {
+		writeJSONError(w, decodeErr.status, decodeErr.message)
+		return Receipt{}, false
+	}
+
+	// A label can also be given as a query parameter, but not two different ones
+	if label := r.URL.Query().Get("label"); label != "" {
+		if receipt.Label != "" && receipt.Label != label {
+			writeJSONError(w, http.StatusBadRequest, "The label query parameter does not match the receipt label")
+			return Receipt{}, false
+		}
+		receipt.Label = label
+	}
+
+	if err := prepareReceipt(&receipt); err != nil {
+		writeValidationError(w, err)
+		return Receipt{}, false
+	}
+	return receipt, true
+}
+
+// requestError carries the status code and message to send to the client
+type requestError struct {
+	status  int
+	message string
+}
+
+// decodeReceipt reads the receipt from the request body as JSON, or as CSV
+// when the Content-Type is text/csv
+func decodeReceipt(r *http.Request) (Receipt, *requestError) {
+	var receipt Receipt
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		receipt, err = parseReceiptCSV(r.Body)
+	} else {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&receipt)
+	}
+	if err == nil {
+		return receipt, nil
+	}
+
+	if isBodyTooLarge(err) {
+		return receipt, &requestError{http.StatusRequestEntityTooLarge, "Request body too large"}
+	}
+	// The decoder only reports a clean EOF when there was nothing but
+	// whitespace to read; a truncated receipt is io.ErrUnexpectedEOF
+	if errors.Is(err, io.EOF) {
+		return receipt, &requestError{http.StatusBadRequest, "Empty request body"}
+	}
+	// Name the offending field so typos like "retailar" are easy to spot
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return receipt, &requestError{http.StatusBadRequest, "Invalid receipt format: " + strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return receipt, &requestError{http.StatusBadRequest, "Invalid receipt format: " + describeTypeError(typeErr)}
+	}
+	if mediaType == "text/csv" {
+		return receipt, &requestError{http.StatusBadRequest, "Invalid receipt format: " + err.Error()}
+	}
+	return receipt, &requestError{http.StatusBadRequest, "Invalid receipt format"}
+}
+
+// jsonIndexPattern matches the array indexes in an UnmarshalTypeError field
+// path such as "items.0.price"
+var jsonIndexPattern = regexp.MustCompile(`\.(\d+)`)
+
+// describeTypeError explains a JSON value of the wrong type in terms of the
+// receipt's JSON fields, e.g. field "total" must be a string, got number.
+// Field paths use the same items[0].price form as validation errors.
+func describeTypeError(err *json.UnmarshalTypeError) string {
+	got := err.Value
+	if got == "bool" {
+		got = "boolean"
+	}
+
+	var expected string
+	switch err.Type.Kind() {
+	case reflect.String:
+		expected = "a string"
+	case reflect.Slice, reflect.Array:
+		expected = "an array"
+	case reflect.Struct, reflect.Map:
+		expected = "an object"
+	case reflect.Bool:
+		expected = "a boolean"
+	default:
+		expected = "a number"
+	}
+
+	if err.Field == "" {
+		return fmt.Sprintf("receipt must be %s, got %s", expected, got)
+	}
+	field := jsonIndexPattern.ReplaceAllString(err.Field, "[$1]")
+	return fmt.Sprintf("field %q must be %s, got %s", field, expected, got)
+}
+
+// isBodyTooLarge reports whether a read failed because of http.MaxBytesReader
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func (s *Server) ProcessReceiptBatchHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	onConflict, conflictErr := onConflictMode(r)
+	if conflictErr != nil {
+		writeJSONError(w, conflictErr.status, conflictErr.message)
+		return
+	}
+
+	var receipts []Receipt
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&receipts); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			writeJSONError(w, http.StatusBadRequest, "Invalid receipt batch format: "+strings.TrimPrefix(err.Error(), "json: "))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid receipt batch format")
+		return
+	}
+
+	// Each receipt is validated and stored independently, so earlier
+	// successes are kept even when later receipts fail
+	results := make([]BatchResult, 0, len(receipts))
+	for index, receipt := range receipts {
+		if err := prepareReceipt(&receipt); err != nil {
+			_, message := validationStatus(err)
+			results = append(results, BatchResult{Index: index, Error: message})
+			continue
+		}
+
+		id, message := s.storeReceipt(r.Context(), receipt, onConflict)
+		results = append(results, BatchResult{Index: index, ID: id, Error: message})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(results)
+}
+
+// storeReceipt stores one validated receipt of a batch or stream, under its
+// clientId when it has one. It returns the id, or the message describing why
+// the receipt couldn't be stored.
+func (s *Server) storeReceipt(ctx context.Context, receipt Receipt, onConflict string) (string, string) {
+	if receipt.ClientID != "" {
+		id, err := s.addWithClientID(ctx, receipt, onConflict)
+		receipt.ClientID = ""
+		switch {
+		case errors.Is(err, ErrReceiptExists):
+			return "", "A receipt with that clientId already exists"
+		case err != nil:
+			return "", "Failed to store receipt"
+		}
+		logProcessedReceipt(ctx, id, receipt)
+		return id, ""
+	}
+
+	id := s.store.AddReceipt(ctx, receipt)
+	if id == "" {
+		return "", "Failed to store receipt"
+	}
+	logProcessedReceipt(ctx, id, receipt)
+	return id, ""
+}
+
+// Pagination limits for the receipt listing
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// Leaderboard sizes; the maximum can be raised with LEADERBOARD_MAX_LIMIT
+const (
+	defaultLeaderboardLimit    = 10
+	defaultMaxLeaderboardLimit = 100
+)
+
+func (s *Server) ListReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultListLimit)
+	if err != nil || limit < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid limit")
 		return
 	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
 
-	// Validate total format (number with optional decimal point)
-	_, err = strconv.ParseFloat(receipt.Total, 64)
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid offset")
+		return
+	}
+
+	filter := ReceiptFilter{Label: r.URL.Query().Get("label")}
+	receipts, total := s.store.ListReceipts(r.Context(), filter, limit, offset)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ReceiptListResponse{Receipts: receipts, Total: total, Limit: limit, Offset: offset})
+}
+
+// ReceiptChangesHandler lists the receipts stored after the RFC 3339 since
+// query parameter, so a downstream copy can fetch only what is new. Passing
+// the last addedAt back as since continues from where the previous call
+// stopped.
+func (s *Server) ReceiptChangesHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
 	if err != nil {
-		http.Error(w, "Invalid total format", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid since. Expected an RFC 3339 timestamp such as 2022-01-01T13:01:00Z")
 		return
 	}
 
-	// Process receipt and generate ID
-	id := rs.AddReceipt(receipt)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ReceiptChangesResponse{Receipts: s.store.ChangesSince(r.Context(), since), Since: since})
+}
+
+// LeaderboardHandler returns the highest scoring receipts
+func (s *Server) LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultLeaderboardLimit)
+	if err != nil || limit < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	if limit > s.maxLeaderboardLimit {
+		limit = s.maxLeaderboardLimit
+	}
+
+	receipts := s.store.TopReceipts(r.Context(), limit)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(ReceiptResponse{ID: id})
+	json.NewEncoder(w).Encode(LeaderboardResponse{Receipts: receipts, Limit: limit})
 }
 
-func (rs *ReceiptStore) GetPointsHandler(w http.ResponseWriter, r *http.Request) {
+// queryInt reads an integer query parameter, returning fallback when it is absent
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (s *Server) GetPointsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	points, exists := rs.GetPoints(id)
+	// A named ruleset rescores the stored receipt on the fly, leaving its
+	// stored points as they are
+	if name := r.URL.Query().Get("ruleset"); name != "" {
+		rules, ok := lookupRuleSet(name)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unknown ruleset %q", name))
+			return
+		}
+		receipt, exists := s.store.GetReceipt(r.Context(), id)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "No receipt found for that id")
+			return
+		}
+		writePoints(w, r, id, calculatePoints(receipt, rules))
+		return
+	}
+
+	points, exists := s.store.GetPoints(r.Context(), id)
 	if !exists {
-		http.Error(w, "No receipt found for that id", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "No receipt found for that id")
+		return
+	}
+	writePoints(w, r, id, points)
+}
+
+// writePoints sends a receipt's points, or 304 when the client already has
+// them. Clients that prefer text/plain get the bare number.
+func writePoints(w http.ResponseWriter, r *http.Request, id string, points int) {
+	plainText := prefersPlainText(r.Header.Get("Accept"))
+
+	// The points are part of the tag because a recompute can change them
+	etag := fmt.Sprintf(`"%s-%d"`, id, points)
+	if plainText {
+		etag = fmt.Sprintf(`"%s-%d-text"`, id, points)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if plainText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "%d\n", points)
 		return
 	}
 
@@ -185,15 +1518,343 @@ func (rs *ReceiptStore) GetPointsHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(PointsResponse{Points: points})
 }
 
+// prefersPlainText reports whether an Accept header ranks text/plain above
+// JSON. JSON wins ties and is the default, so wildcards count towards it.
+func prefersPlainText(header string) bool {
+	plainQuality, jsonQuality := 0.0, 0.0
+	for _, mediaRange := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if key, value, found := strings.Cut(strings.TrimSpace(param), "="); found && strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "text/plain", "text/*":
+			plainQuality = math.Max(plainQuality, quality)
+		case "application/json", "application/*", "*/*":
+			jsonQuality = math.Max(jsonQuality, quality)
+		}
+	}
+	return plainQuality > jsonQuality
+}
+
+// etagMatches reports whether an If-None-Match header (a comma-separated list
+// of tags, or "*") includes etag. Weak tags match their strong equivalent.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) GetPointsBreakdownHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	breakdown, exists := s.store.GetBreakdown(r.Context(), id)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "No receipt found for that id")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(breakdown)
+}
+
+func (s *Server) DeleteReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !s.store.DeleteReceipt(r.Context(), id) {
+		writeJSONError(w, http.StatusNotFound, "No receipt found for that id")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StatsHandler reports aggregate points across the live store
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.store.Stats(r.Context()))
+}
+
+// HealthzHandler reports that the server is up
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StatusResponse{Status: "ok"})
+}
+
+// ReadyzHandler reports whether the backing store can serve requests
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.store.Ping(r.Context()); err != nil {
+		slog.Error("store is not ready", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(StatusResponse{Status: "unavailable"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StatusResponse{Status: "ok"})
+}
+
+// RegisterRoutes adds the receipt API, probe and OpenAPI routes to the router
+func (s *Server) RegisterRoutes(router *mux.Router) {
+	router.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	router.HandleFunc("/receipts", s.ListReceiptsHandler).Methods("GET")
+	router.HandleFunc("/receipts/changes", s.ReceiptChangesHandler).Methods("GET")
+	router.HandleFunc("/receipts/stream", s.ReceiptStreamHandler).Methods("GET")
+	router.HandleFunc("/receipts/process", s.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/upload", s.UploadReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/process/batch", s.ProcessReceiptBatchHandler).Methods("POST")
+	router.HandleFunc("/receipts/aggregate", s.AggregateReceiptsHandler).Methods("POST")
+	router.HandleFunc("/receipts/validate", s.ValidateReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/points/lookup", s.LookupPointsHandler).Methods("POST")
+	router.HandleFunc("/score", s.ScoreHandler).Methods("POST")
+	router.HandleFunc("/score/with-rules", s.ScoreWithRulesHandler).Methods("POST")
+	router.HandleFunc("/simulate", s.SimulateHandler).Methods("POST")
+	router.HandleFunc("/rules", s.RulesDescriptionHandler).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points", s.GetPointsHandler).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points/breakdown", s.GetPointsBreakdownHandler).Methods("GET")
+	router.HandleFunc("/receipts/{id}", s.DeleteReceiptHandler).Methods("DELETE")
+	router.HandleFunc("/export", s.requireAdmin(s.ExportHandler)).Methods("GET")
+	router.HandleFunc("/import", s.requireAdmin(s.ImportHandler)).Methods("POST")
+	router.HandleFunc("/import/stream", s.requireAdmin(s.ImportStreamHandler)).Methods("POST")
+	router.HandleFunc("/stats", s.StatsHandler).Methods("GET")
+	router.HandleFunc("/leaderboard", s.LeaderboardHandler).Methods("GET")
+	router.HandleFunc("/healthz", s.HealthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", s.ReadyzHandler).Methods("GET")
+	router.HandleFunc("/openapi.json", OpenAPIHandler).Methods("GET")
+	router.HandleFunc("/version", VersionHandler).Methods("GET")
+	router.HandleFunc("/admin/recompute", s.requireAdmin(s.RecomputeHandler)).Methods("POST")
+	router.HandleFunc("/admin/reset", s.requireAdmin(s.ResetHandler)).Methods("POST")
+	router.HandleFunc("/admin/rules", s.requireAdmin(s.RulesHandler)).Methods("GET")
+	router.HandleFunc("/admin/rules", s.requireAdmin(s.UpdateRulesHandler)).Methods("PUT")
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to drain
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	store := NewReceiptStore()
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "score":
+		os.Exit(runScore(os.Args[2:], os.Stdout, os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// serve runs the HTTP server and exits non-zero if it fails to start or stops
+// unexpectedly
+func serve() {
+	if err := run(); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run serves HTTP until it receives SIGINT or SIGTERM. Errors are returned
+// rather than exiting so that stores and the audit log are closed on the way
+// out.
+func run() error {
+	// The level is raised or lowered once the configuration is loaded
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	// Settings come from the optional config file, overridden by env vars
+	configPath := os.Getenv("CONFIG_FILE")
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	level, _ := parseLogLevel(config.LogLevel)
+	logLevel.Set(level)
+	if configPath != "" {
+		logger.Info("using config file", "path", configPath)
+	}
+	if err := applyScoringConfig(config); err != nil {
+		return err
+	}
+	for name, path := range config.RuleSets {
+		logger.Info("registered ruleset", "name", name, "path", path)
+	}
+	if validationLimits.RejectFutureDates {
+		logger.Info("rejecting receipts dated in the future", "timeZone", receiptLocation.String())
+	}
+
+	// Receipts are kept in memory unless a persistent store is configured
+	memoryStore := NewReceiptStore()
+	memoryStore.dedup = config.Store.Dedup
+	memoryStore.ttl = config.Store.TTL
+	memoryStore.maxReceipts = config.Store.MaxReceipts
+	if config.Store.SnapshotPath != "" {
+		if err := memoryStore.LoadSnapshot(config.Store.SnapshotPath); err != nil {
+			return fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		logger.Info("using snapshot", "path", config.Store.SnapshotPath, "interval", config.Store.SnapshotInterval)
+	}
+	var store Store = memoryStore
+	switch config.Store.Backend {
+	case backendBolt:
+		boltStore, err := NewBoltReceiptStore(config.Store.DBPath)
+		if err != nil {
+			return fmt.Errorf("failed to open receipt database: %w", err)
+		}
+		defer boltStore.Close()
+		boltStore.dedup = config.Store.Dedup
+		store = boltStore
+		logger.Info("using receipt database", "path", config.Store.DBPath)
+	case backendRedis:
+		// A shared Redis store lets several instances serve the same receipts
+		redisStore, err := NewRedisReceiptStore(config.Store.RedisURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to redis: %w", err)
+		}
+		defer redisStore.Close()
+		redisStore.dedup = config.Store.Dedup
+		store = redisStore
+		logger.Info("using redis store")
+	}
+
+	metrics := NewMetrics(prometheus.DefaultRegisterer)
+	store = metrics.InstrumentStore(store)
+	debugStats := NewDebugStats()
+	store = debugStats.InstrumentStore(store)
+
+	// Append an audit entry for every scored receipt
+	audit, err := OpenAuditLog(config.AuditPath)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer audit.Close()
+	store = audit.AuditStore(store)
+	if config.AuditPath != "" {
+		logger.Info("writing audit log", "path", config.AuditPath)
+	}
+
+	// Post an event for every processed receipt when a webhook is configured
+	if config.WebhookURL != "" {
+		notifier := NewWebhookNotifier(config.WebhookURL)
+		store = notifier.NotifyStore(store)
+		debugStats.TrackWebhook(notifier)
+		logger.Info("sending receipt events", "url", config.WebhookURL)
+	}
+
+	server := NewServer(store)
+	server.maxBodyBytes = config.MaxBodyBytes
+	server.adminSecret = config.AdminSecret
+	server.maxLeaderboardLimit = int(config.LeaderboardMaxLimit)
+	// Validate has already rejected malformed entries
+	trustedProxies, _ := parseTrustedProxies(config.TrustedProxies)
+	rateLimiter := NewRateLimiter(float64(config.RateLimitRPS), int(config.RateLimitBurst), trustedProxies)
 	router := mux.NewRouter()
+	router.Use(metrics.Middleware)
+	router.Use(debugStats.Middleware)
 
 	// Define API routes
-	router.HandleFunc("/receipts/process", store.ProcessReceiptHandler).Methods("POST")
-	router.HandleFunc("/receipts/{id}/points", store.GetPointsHandler).Methods("GET")
+	server.RegisterRoutes(router)
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/debug/stats", debugStats.Handler).Methods("GET")
+
+	// Wrap the router from the inside out: auth runs after rate
+	// limiting so unauthenticated clients are throttled too, CORS answers
+	// preflight requests before either, and gzip is outermost so logging sees
+	// the uncompressed response
+	var handler http.Handler = router
+	handler = RecoveryMiddleware(logger, handler)
+	handler = TimeoutMiddleware(config.RequestTimeout, handler)
+	handler = AuthMiddleware(config.APIToken, handler)
+	handler = rateLimiter.Middleware(handler)
+	handler = CORSMiddleware(config.CORSAllowedOrigins, handler)
+	handler = LoggingMiddleware(logger, handler)
+	handler = GzipMiddleware(server.maxBodyBytes, handler)
+
+	httpServer := &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: handler,
+	}
+	httpServer.RegisterOnShutdown(server.events.Close)
 
 	// Start the server
-	fmt.Println("Server starting on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rateLimiter.StartSweeper(ctx)
+	memoryStore.StartExpirySweeper(ctx)
+	if config.Store.SnapshotPath != "" {
+		memoryStore.StartSnapshotter(ctx, config.Store.SnapshotPath, config.Store.SnapshotInterval)
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		// Plain HTTP stays the default for local development
+		if config.TLS.Enabled() {
+			logger.Info("server starting", "addr", httpServer.Addr, "tls", true)
+			serveErr <- httpServer.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile)
+			return
+		}
+		logger.Info("server starting", "addr", httpServer.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	var redirectServer *http.Server
+	if config.TLS.RedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:    config.TLS.RedirectAddr,
+			Handler: RedirectHandler(config.ListenAddr),
+		}
+		go func() {
+			logger.Info("redirecting HTTP to HTTPS", "addr", redirectServer.Addr)
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Let in-flight requests drain before exiting
+	logger.Info("shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("graceful shutdown failed, forcing close", "error", err)
+		httpServer.Close()
+	}
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+			redirectServer.Close()
+		}
+	}
+
+	// Save once more so receipts since the last tick survive the restart
+	if config.Store.SnapshotPath != "" {
+		if err := memoryStore.SaveSnapshot(config.Store.SnapshotPath); err != nil {
+			logger.Error("failed to save snapshot", "error", err)
+		}
+	}
+	logger.Info("server stopped")
+	return nil
 }
@@ -2,17 +2,38 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
+// decodeError parses a JSON error response and checks it matches the status code
+func decodeError(t *testing.T, rr *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+
+	var response ErrorResponse
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, rr.Code, response.Status)
+	return response
+}
+
 func TestProcessReceipt(t *testing.T) {
-	store := NewReceiptStore()
+	server := NewServer(NewReceiptStore())
 
 	// Test case 1: Valid receipt
 	receipt := Receipt{
@@ -33,7 +54,7 @@ func TestProcessReceipt(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(store.ProcessReceiptHandler)
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
 	handler.ServeHTTP(rr, req)
 
 	// Check status code
@@ -64,6 +85,34 @@ func TestProcessReceipt(t *testing.T) {
 
 	// Check status code for error
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Missing required receipt fields", decodeError(t, rr).Error)
+}
+
+func TestProcessReceiptIncludePoints(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	reqBody, _ := json.Marshal(validReceipt())
+
+	// Test case 1: Points are omitted by default so the response shape is unchanged
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.ProcessReceiptHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var fields map[string]any
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fields))
+	assert.NotContains(t, fields, "points")
+
+	// Test case 2: Points are included when requested
+	req, _ = http.NewRequest("POST", "/receipts/process?includePoints=true", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.ProcessReceiptHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	if assert.NotNil(t, response.Points) {
+		assert.Equal(t, calculatePoints(validReceipt(), currentRules()), *response.Points)
+	}
 }
 
 func TestGetPoints(t *testing.T) {
@@ -81,14 +130,15 @@ func TestGetPoints(t *testing.T) {
 		Total: "18.74",
 	}
 
-	id := store.AddReceipt(receipt)
+	id := store.AddReceipt(context.Background(), receipt)
+	server := NewServer(store)
 
 	// Test case 1: Get points for valid ID
 	req, _ := http.NewRequest("GET", "/receipts/"+id+"/points", nil)
 	rr := httptest.NewRecorder()
 
 	router := mux.NewRouter()
-	router.HandleFunc("/receipts/{id}/points", store.GetPointsHandler).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
 	router.ServeHTTP(rr, req)
 
 	// Check status code
@@ -108,6 +158,370 @@ func TestGetPoints(t *testing.T) {
 
 	// Check status code for error
 	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "No receipt found for that id", decodeError(t, rr).Error)
+}
+
+func TestGetPointsRuleSet(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Store the receipt under rules that award no retailer points
+	defer setActiveRules(currentRules())
+	rules := DefaultRuleSet()
+	rules.RetailerCharPoints = 0
+	setActiveRules(rules)
+
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		},
+		Total: "18.74",
+	}
+	id := store.AddReceipt(context.Background(), receipt)
+
+	// Test case 1: Without a ruleset the stored points are returned
+	var response PointsResponse
+	rr := get("/receipts/" + id + "/points")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 14, response.Points)
+
+	// Test case 2: v1 rescores with the original rules, including the six
+	// retailer characters
+	rr = get("/receipts/" + id + "/points?ruleset=v1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 20, response.Points)
+
+	// Test case 3: The stored points are left as they were
+	points, _ := store.GetPoints(context.Background(), id)
+	assert.Equal(t, 14, points)
+
+	// Test case 4: Registered rulesets can be used by name
+	defer delete(ruleSetRegistry, "double-odd-day")
+	doubled := DefaultRuleSet()
+	doubled.OddDayPoints = 12
+	registerRuleSet("double-odd-day", doubled)
+	rr = get("/receipts/" + id + "/points?ruleset=double-odd-day")
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 26, response.Points)
+
+	// Test case 5: Unknown rulesets and receipts
+	rr = get("/receipts/" + id + "/points?ruleset=v0")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `Unknown ruleset "v0"`, decodeError(t, rr).Error)
+	rr = get("/receipts/invalid-id/points?ruleset=v1")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetPointsConditional(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+
+	id := store.AddReceipt(context.Background(), validReceipt())
+
+	// Test case 1: The first response carries an ETag
+	req, _ := http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Test case 2: A matching If-None-Match gets 304 with no body
+	for _, header := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		req, _ = http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+		req.Header.Set("If-None-Match", header)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotModified, rr.Code, header)
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		assert.Empty(t, rr.Body.String())
+	}
+
+	// Test case 3: A stale tag gets the full response
+	req, _ = http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response PointsResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+}
+
+func TestProcessReceiptClientID(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/process", server.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/process/batch", server.ProcessReceiptBatchHandler).Methods("POST")
+
+	processURL := func(url string, receipt Receipt) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	process := func(receipt Receipt, idempotencyKey string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(body))
+		if idempotencyKey != "" {
+			req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: A supplied id is used instead of a generated one, and is
+	// not kept on the stored receipt
+	for _, clientID := range []string{"order-1234", "0c2f7a8e-6b1d-4f7e-9a8b-3d2c1b0a9f8e"} {
+		receipt := validReceipt()
+		receipt.ClientID = clientID
+		rr := process(receipt, "")
+		assert.Equal(t, http.StatusOK, rr.Code, clientID)
+
+		var response ReceiptResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, clientID, response.ID)
+		stored, exists := store.GetReceipt(context.Background(), clientID)
+		assert.True(t, exists)
+		assert.Equal(t, validReceipt(), stored)
+	}
+
+	// Test case 2: A duplicate id is rejected and the original is kept
+	duplicate := validReceipt()
+	duplicate.ClientID = "order-1234"
+	duplicate.Retailer = "Target"
+	rr := process(duplicate, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "A receipt with that clientId already exists", decodeError(t, rr).Error)
+	stored, _ := store.GetReceipt(context.Background(), "order-1234")
+	assert.Equal(t, "M&M Corner Market", stored.Retailer)
+
+	// Test case 3: Malformed ids are rejected
+	for _, clientID := range []string{"has space", "slash/id", strings.Repeat("a", 65)} {
+		receipt := validReceipt()
+		receipt.ClientID = clientID
+		rr = process(receipt, "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code, clientID)
+		assert.Equal(t, "clientId", decodeError(t, rr).Errors[0].Field, clientID)
+	}
+
+	// Test case 4: A client id can't be combined with an idempotency key
+	receipt := validReceipt()
+	receipt.ClientID = "order-5678"
+	assert.Equal(t, http.StatusBadRequest, process(receipt, "key-1").Code)
+
+	// Test case 5: Without a client id, ids are still generated
+	rr = process(validReceipt(), "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.ReceiptIDs(context.Background()), 3)
+
+	// Test case 6: Batches honor client ids per receipt
+	body, _ := json.Marshal([]Receipt{receipt, duplicate})
+	req, _ := http.NewRequest("POST", "/receipts/process/batch", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var results []BatchResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Equal(t, []BatchResult{
+		{Index: 0, ID: "order-5678"},
+		{Index: 1, Error: "A receipt with that clientId already exists"},
+	}, results)
+
+	// Test case 7: onConflict=reject is the default behavior
+	rr = processURL("/receipts/process?onConflict=reject", duplicate)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "A receipt with that clientId already exists", decodeError(t, rr).Error)
+
+	// Test case 8: onConflict=suffix stores a colliding id under the first
+	// free suffix and returns it
+	for _, expected := range []string{"order-1234-2", "order-1234-3"} {
+		rr = processURL("/receipts/process?onConflict=suffix", duplicate)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response ReceiptResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, expected, response.ID)
+		stored, exists := store.GetReceipt(context.Background(), expected)
+		assert.True(t, exists)
+		assert.Equal(t, "Target", stored.Retailer)
+		assert.Empty(t, stored.ClientID)
+	}
+	stored, _ = store.GetReceipt(context.Background(), "order-1234")
+	assert.Equal(t, "M&M Corner Market", stored.Retailer)
+
+	// Test case 9: A free id is used as it is in suffix mode
+	fresh := validReceipt()
+	fresh.ClientID = "order-9999"
+	rr = processURL("/receipts/process?onConflict=suffix", fresh)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"id":"order-9999"`)
+
+	// Test case 10: Batches take the same parameter
+	body, _ = json.Marshal([]Receipt{duplicate})
+	req, _ = http.NewRequest("POST", "/receipts/process/batch?onConflict=suffix", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	results = nil
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Equal(t, []BatchResult{{Index: 0, ID: "order-1234-4"}}, results)
+
+	// Test case 11: Unknown modes are rejected
+	rr = processURL("/receipts/process?onConflict=overwrite", duplicate)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid onConflict. Expected suffix or reject", decodeError(t, rr).Error)
+}
+
+func TestLookupPoints(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		store := NewReceiptStore()
+		store.dedup = dedup
+		server := NewServer(store)
+		router := mux.NewRouter()
+		router.HandleFunc("/receipts/points/lookup", server.LookupPointsHandler).Methods("POST")
+
+		lookup := func(receipt Receipt) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(receipt)
+			req, _ := http.NewRequest("POST", "/receipts/points/lookup", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		receipt := validReceipt()
+		id := store.AddReceipt(context.Background(), receipt)
+		points, _ := store.GetPoints(context.Background(), id)
+
+		// Test case 1: An identical receipt returns the stored id and points
+		rr := lookup(receipt)
+		assert.Equal(t, http.StatusOK, rr.Code, "dedup %v", dedup)
+		var response LookupResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, LookupResponse{ID: id, Points: points}, response)
+
+		// Test case 2: A receipt that was never stored is not found, and
+		// looking it up doesn't store it
+		other := receipt
+		other.PurchaseTime = "09:15"
+		rr = lookup(other)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "No matching receipt found", decodeError(t, rr).Error)
+		assert.Len(t, store.ReceiptIDs(context.Background()), 1)
+
+		// Test case 3: Deleted receipts are no longer found
+		store.DeleteReceipt(context.Background(), id)
+		assert.Equal(t, http.StatusNotFound, lookup(receipt).Code)
+
+		// Test case 4: Invalid receipts are rejected before the lookup
+		receipt.Total = ""
+		assert.Equal(t, http.StatusBadRequest, lookup(receipt).Code)
+	}
+}
+
+func TestGetPointsPlainText(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+
+	id := store.AddReceipt(context.Background(), validReceipt())
+	points, _ := store.GetPoints(context.Background(), id)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: text/plain gets the bare number and a newline
+	for _, accept := range []string{"text/plain", "text/plain, */*;q=0.5", "application/json;q=0.2, text/plain;q=0.9"} {
+		rr := get(accept)
+		assert.Equal(t, http.StatusOK, rr.Code, accept)
+		assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"), accept)
+		assert.Equal(t, fmt.Sprintf("%d\n", points), rr.Body.String(), accept)
+	}
+
+	// Test case 2: JSON is the default and wins ties
+	for _, accept := range []string{"", "application/json", "*/*", "text/plain, application/json"} {
+		rr := get(accept)
+		assert.Equal(t, http.StatusOK, rr.Code, accept)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), accept)
+		var response PointsResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response), accept)
+		assert.Equal(t, points, response.Points, accept)
+	}
+
+	// Test case 3: Each representation has its own ETag
+	assert.NotEqual(t, get("text/plain").Header().Get("ETag"), get("application/json").Header().Get("ETag"))
+	assert.Equal(t, "Accept", get("text/plain").Header().Get("Vary"))
+
+	// Test case 4: Unknown ids still get a JSON error
+	req, _ := http.NewRequest("GET", "/receipts/missing/points", nil)
+	req.Header.Set("Accept", "text/plain")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRulesDescription(t *testing.T) {
+	defer setActiveRules(currentRules())
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/rules", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: JSON lists one sentence per rule with its point value
+	rr := get("application/json")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response RulesDescriptionResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, DefaultRuleSet().Describe(), response.Rules)
+	for _, points := range []string{"One point", "50 points", "25 points", "5 points", "0.2", "6 points", "10 points"} {
+		assert.Contains(t, strings.Join(response.Rules, "\n"), points)
+	}
+
+	// Test case 2: text/plain gets a bulleted list
+	rr = get("text/plain")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "* One point for every alphanumeric character in the retailer name.\n"))
+	assert.Equal(t, len(response.Rules), strings.Count(rr.Body.String(), "\n"))
+
+	// Test case 3: The description follows the active rules
+	rules := currentRules()
+	rules.RoundDollarPoints = 75
+	setActiveRules(rules)
+	assert.Contains(t, get("text/plain").Body.String(), "* 75 points if the total is a round dollar amount with no cents.\n")
 }
 
 func TestCalculatePoints(t *testing.T) {
@@ -117,21 +531,29 @@ func TestCalculatePoints(t *testing.T) {
 		PurchaseDate: "2022-01-01", // Odd day: +6 points
 		PurchaseTime: "13:01",      // Not between 2:00 PM and 4:00 PM
 		Items: []Item{
-			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},              // Length 16 (not divisible by 3)
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},              // Length 17 (not divisible by 3)
 			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},            // Length 18 (divisible by 3): +3 points (ceil(12.25 * 0.2))
-			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},           // Length 21 (divisible by 3): +1 point (ceil(1.26 * 0.2))
-			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},           // Length 21 (divisible by 3): +1 point (ceil(3.35 * 0.2))
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},           // Length 20 (not divisible by 3)
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},           // Length 20 (not divisible by 3)
 			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"}, // Trimmed length 24 (divisible by 3): +3 points (ceil(12.00 * 0.2))
 		},
 		// 5 items: +10 points (5 points for every 2 items)
-		Total: "35.35", // Not a round dollar, but multiple of 0.25: +25 points
+		Total: "35.35", // Neither a round dollar amount nor a multiple of 0.25
 	}
 
-	// Retailer name "Target" has 6 alphanumeric characters: +6 points
-	// Expected total: 6 + 6 + 10 + 3 + 1 + 1 + 3 + 25 = 55 points
+	// Expected total, matching the README:
+	//      6 points - retailer name has 6 characters
+	//     10 points - 5 items (2 pairs @ 5 points each)
+	//      3 points - "Emils Cheese Pizza" is 18 characters (a multiple of 3)
+	//                 item price of 12.25 * 0.2 = 2.45, rounded up is 3 points
+	//      3 points - "Klarbrunn 12-PK 12 FL OZ" is 24 characters (a multiple of 3)
+	//                 item price of 12.00 * 0.2 = 2.4, rounded up is 3 points
+	//      6 points - purchase day is odd
+	//   + ---------
+	//   = 28 points
 
-	points := calculatePoints(receipt)
-	assert.Equal(t, 28, points) // This will be corrected to 55 once all rules are properly implemented
+	points := calculatePoints(receipt, DefaultRuleSet())
+	assert.Equal(t, 28, points)
 
 	// Test with another example
 	receipt2 := Receipt{
@@ -148,7 +570,6 @@ func TestCalculatePoints(t *testing.T) {
 		Total: "9.00", // Round dollar amount: +50 points, multiple of 0.25: +25 points
 	}
 
-	// Retailer name "M&M Corner Market" has 13 alphanumeric characters: +13 points
 	// Expected total:
 	//     50 points - total is a round dollar amount
 	//     25 points - total is a multiple of 0.25
@@ -159,6 +580,1550 @@ func TestCalculatePoints(t *testing.T) {
 	//   + ---------
 	//   = 109 points
 
-	points2 := calculatePoints(receipt2)
+	points2 := calculatePoints(receipt2, DefaultRuleSet())
 	assert.Equal(t, 109, points2)
 }
+
+func TestProcessReceiptTotalMismatch(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: Total does not match the sum of the items
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		},
+		Total: "18.75",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Total does not match sum of items", decodeError(t, rr).Error)
+
+	// Test case 2: Prices that don't add up exactly as floats still match in cents
+	receipt.Items = []Item{
+		{ShortDescription: "Gum", Price: "0.10"},
+		{ShortDescription: "Mints", Price: "0.20"},
+	}
+	receipt.Total = "0.30"
+
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Test case 3: No items with a nonzero total
+	receipt.Items = nil
+	receipt.Total = "1.00"
+
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestProcessReceiptValidationErrors(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: A receipt with several problems reports all of them
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022/01/01",
+		PurchaseTime: "25:00",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "", Price: "12.25"},
+		},
+		Total: "18.74",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	response := decodeError(t, rr)
+	assert.Equal(t, "Item 1 is missing shortDescription", response.Error)
+	assert.Equal(t, []FieldError{
+		{Field: "items[1].shortDescription", Message: "Item 1 is missing shortDescription"},
+		{Field: "purchaseDate", Message: "Invalid purchase date format. Expected YYYY-MM-DD"},
+		{Field: "purchaseTime", Message: "Invalid purchase time format. Expected HH:MM"},
+	}, response.Errors)
+
+	// Test case 2: A single problem is listed on its own
+	receipt.PurchaseDate = "2022-01-01"
+	receipt.PurchaseTime = "13:01"
+	receipt.Items[1].ShortDescription = "Emils Cheese Pizza"
+	receipt.Total = "18.75"
+
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []FieldError{{Field: "total", Message: "Total does not match sum of items"}}, decodeError(t, rr).Errors)
+}
+
+func TestGetPointsBreakdown(t *testing.T) {
+	store := NewReceiptStore()
+
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	id := store.AddReceipt(context.Background(), receipt)
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/{id}/points/breakdown", server.GetPointsBreakdownHandler).Methods("GET")
+
+	// Test case 1: Breakdown for a valid ID sums to the points total
+	req, _ := http.NewRequest("GET", "/receipts/"+id+"/points/breakdown", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var breakdown []PointsBreakdown
+	err := json.Unmarshal(rr.Body.Bytes(), &breakdown)
+	assert.NoError(t, err)
+
+	sum := 0
+	rules := []string{}
+	for _, entry := range breakdown {
+		sum += entry.Points
+		rules = append(rules, entry.Rule)
+	}
+	assert.Equal(t, 109, sum)
+	assert.Equal(t, []string{"retailer-name", "round-dollar", "quarter-multiple", "item-pairs", "time-window"}, rules)
+	assert.Equal(t, "14 points - retailer name has 14 alphanumeric characters", breakdown[0].Description)
+
+	// Test case 2: Invalid ID
+	req, _ = http.NewRequest("GET", "/receipts/invalid-id/points/breakdown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestProcessReceiptInvalidItemPrice(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	for _, price := range []string{"abc", "1.5", "1.555"} {
+		receipt := Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items: []Item{
+				{ShortDescription: "Mountain Dew 12PK", Price: price},
+			},
+			Total: "1.50",
+		}
+
+		reqBody, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "price %q", price)
+		assert.Equal(t, "Invalid item price format", decodeError(t, rr).Error, "price %q", price)
+	}
+}
+
+func TestProcessReceiptRetailer(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	tests := []struct {
+		retailer string
+		status   int
+	}{
+		{"M&M Corner Market", http.StatusOK},
+		{"Walgreens-24", http.StatusOK},
+		{"Target\nStore", http.StatusBadRequest},
+		{"Target 🎯", http.StatusBadRequest},
+		{"Target\x07", http.StatusBadRequest},
+		{"Target!", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		receipt := Receipt{
+			Retailer:     tt.retailer,
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items: []Item{
+				{ShortDescription: "Gatorade", Price: "2.25"},
+			},
+			Total: "2.25",
+		}
+
+		reqBody, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, tt.status, rr.Code, "retailer %q", tt.retailer)
+		if tt.status == http.StatusBadRequest {
+			assert.Equal(t, "Invalid retailer", decodeError(t, rr).Error, "retailer %q", tt.retailer)
+		}
+	}
+}
+
+// fakeStore is a Store with canned responses for exercising the handlers
+type fakeStore struct {
+	added      []Receipt
+	id         string
+	points     map[string]int
+	breakdowns map[string][]PointsBreakdown
+	receipts   map[string]Receipt
+	pingErr    error
+}
+
+func (fs *fakeStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	fs.added = append(fs.added, receipt)
+	return fs.id
+}
+
+func (fs *fakeStore) GetPoints(ctx context.Context, id string) (int, bool) {
+	points, exists := fs.points[id]
+	return points, exists
+}
+
+func (fs *fakeStore) GetBreakdown(ctx context.Context, id string) ([]PointsBreakdown, bool) {
+	breakdown, exists := fs.breakdowns[id]
+	return breakdown, exists
+}
+
+func (fs *fakeStore) GetReceipt(ctx context.Context, id string) (Receipt, bool) {
+	receipt, exists := fs.receipts[id]
+	return receipt, exists
+}
+
+func (fs *fakeStore) DeleteReceipt(ctx context.Context, id string) bool {
+	_, exists := fs.receipts[id]
+	delete(fs.receipts, id)
+	return exists
+}
+
+func (fs *fakeStore) Ping(ctx context.Context) error {
+	return fs.pingErr
+}
+
+func (fs *fakeStore) ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int) {
+	return []ReceiptSummary{}, 0
+}
+
+func (fs *fakeStore) RecomputeAll(ctx context.Context) int {
+	return len(fs.receipts)
+}
+
+func (fs *fakeStore) Stats(ctx context.Context) StatsResponse {
+	stats := newStatsResponse()
+	for id, receipt := range fs.receipts {
+		stats.add(receipt, fs.points[id])
+	}
+	return stats
+}
+
+func (fs *fakeStore) TopReceipts(ctx context.Context, n int) []ReceiptSummary {
+	return []ReceiptSummary{}
+}
+
+func (fs *fakeStore) ReceiptIDs(ctx context.Context) []string {
+	ids := []string{}
+	for id := range fs.receipts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (fs *fakeStore) AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error {
+	if _, exists := fs.receipts[id]; exists {
+		return ErrReceiptExists
+	}
+	points, breakdown := calculatePointsDetailed(receipt, currentRules())
+	return fs.ImportReceipt(ctx, id, receipt, points, breakdown)
+}
+
+func (fs *fakeStore) FindReceipt(ctx context.Context, receipt Receipt) (string, bool) {
+	for id, stored := range fs.receipts {
+		if receiptHash(stored) == receiptHash(receipt) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (fs *fakeStore) ChangesSince(ctx context.Context, since time.Time) []ReceiptSummary {
+	return []ReceiptSummary{}
+}
+
+func (fs *fakeStore) Clear(ctx context.Context) int {
+	cleared := len(fs.receipts)
+	fs.receipts = map[string]Receipt{}
+	fs.points = map[string]int{}
+	fs.breakdowns = map[string][]PointsBreakdown{}
+	return cleared
+}
+
+func (fs *fakeStore) ImportReceipt(ctx context.Context, id string, receipt Receipt, points int, breakdown []PointsBreakdown) error {
+	if fs.receipts == nil {
+		fs.receipts = map[string]Receipt{}
+		fs.points = map[string]int{}
+		fs.breakdowns = map[string][]PointsBreakdown{}
+	}
+	fs.receipts[id] = receipt
+	fs.points[id] = points
+	fs.breakdowns[id] = breakdown
+	return nil
+}
+
+func (fs *fakeStore) AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (string, bool, error) {
+	return fs.AddReceipt(ctx, receipt), false, nil
+}
+
+func TestServerWithFakeStore(t *testing.T) {
+	store := &fakeStore{id: "fake-id", points: map[string]int{"fake-id": 42}}
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/process", server.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts/{id}/points", server.GetPointsHandler).Methods("GET")
+
+	// Test case 1: Processing hands the receipt to the store and returns its id
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []Receipt{receipt}, store.added)
+
+	var response ReceiptResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "fake-id", response.ID)
+
+	// Test case 2: Points come straight from the store
+	req, _ = http.NewRequest("GET", "/receipts/fake-id/points", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var pointsResponse PointsResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &pointsResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, pointsResponse.Points)
+
+	// Test case 3: A store failure surfaces as a server error
+	store.id = ""
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestDeleteReceipt(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+	id := store.AddReceipt(context.Background(), receipt)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/{id}", server.DeleteReceiptHandler).Methods("DELETE")
+
+	// Test case 1: Deleting an existing receipt removes it and its points
+	req, _ := http.NewRequest("DELETE", "/receipts/"+id, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	_, exists := store.GetPoints(context.Background(), id)
+	assert.False(t, exists)
+	_, exists = store.GetReceipt(context.Background(), id)
+	assert.False(t, exists)
+
+	// Test case 2: Deleting it again is not found
+	req, _ = http.NewRequest("DELETE", "/receipts/"+id, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestProcessReceiptBatch(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	handler := http.HandlerFunc(server.ProcessReceiptBatchHandler)
+
+	// Test case 1: Mixed valid and invalid receipts
+	receipts := []Receipt{
+		{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "13:13",
+			Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+			Total:        "1.25",
+		},
+		{
+			Retailer:     "Target",
+			PurchaseTime: "13:13",
+			Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+			Total:        "1.25",
+		},
+		{
+			Retailer:     "Walgreens",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "08:13",
+			Items: []Item{
+				{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+				{ShortDescription: "Dasani", Price: "1.40"},
+			},
+			Total: "2.65",
+		},
+	}
+
+	reqBody, _ := json.Marshal(receipts)
+	req, _ := http.NewRequest("POST", "/receipts/process/batch", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusMultiStatus, rr.Code)
+
+	var results []BatchResult
+	err := json.Unmarshal(rr.Body.Bytes(), &results)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	assert.Equal(t, 0, results[0].Index)
+	assert.NotEmpty(t, results[0].ID)
+	assert.Empty(t, results[0].Error)
+
+	assert.Equal(t, 1, results[1].Index)
+	assert.Empty(t, results[1].ID)
+	assert.Equal(t, "Missing required receipt fields", results[1].Error)
+
+	assert.Equal(t, 2, results[2].Index)
+	assert.NotEmpty(t, results[2].ID)
+
+	// Successful receipts were stored despite the failure
+	_, exists := store.GetPoints(context.Background(), results[0].ID)
+	assert.True(t, exists)
+	_, exists = store.GetPoints(context.Background(), results[2].ID)
+	assert.True(t, exists)
+
+	// Test case 2: Body is not an array of receipts
+	req, _ = http.NewRequest("POST", "/receipts/process/batch", bytes.NewBufferString(`{"retailer":"Target"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Test case 3: Unknown fields reject the whole batch, as they do a single receipt
+	req, _ = http.NewRequest("POST", "/receipts/process/batch", bytes.NewBufferString(`[{"retailer":"Target","retailar":"Target"}]`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `Invalid receipt batch format: unknown field "retailar"`, decodeError(t, rr).Error)
+}
+
+func TestProcessReceiptItems(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: Empty item list
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []Item{},
+		Total:        "0.00",
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Receipt must contain at least one item", decodeError(t, rr).Error)
+
+	// Test case 2: Item with a blank description
+	receipt.Items = []Item{
+		{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		{ShortDescription: "", Price: "1.40"},
+	}
+	receipt.Total = "2.65"
+
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Item 1 is missing shortDescription", decodeError(t, rr).Error)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	store := &fakeStore{}
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/healthz", server.HealthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", server.ReadyzHandler).Methods("GET")
+
+	// Test case 1: Both probes succeed when the store is reachable
+	for _, path := range []string{"/healthz", "/readyz"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String(), path)
+	}
+
+	// Test case 2: Readiness fails while the store is unreachable, liveness does not
+	store.pingErr = errors.New("connection refused")
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"status":"unavailable"}`, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestProcessReceiptUnknownField(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	body := `{
+		"retailar": "Target",
+		"purchaseDate": "2022-01-02",
+		"purchaseTime": "13:13",
+		"items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}],
+		"total": "1.25"
+	}`
+
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid receipt format: unknown field \"retailar\"", decodeError(t, rr).Error)
+}
+
+func TestProcessReceiptEmptyBody(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: An empty or whitespace-only body gets its own message
+	for _, body := range []io.Reader{http.NoBody, bytes.NewBufferString(""), bytes.NewBufferString(" \n")} {
+		req, _ := http.NewRequest("POST", "/receipts/process", body)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "Empty request body", decodeError(t, rr).Error)
+	}
+
+	// Test case 2: A truncated receipt is still a format error
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(`{"retailer": "Target"`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid receipt format", decodeError(t, rr).Error)
+}
+
+func TestProcessReceiptWrongType(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		// Test case 1: A numeric total
+		{`{"retailer": "Target", "total": 35.35}`, `Invalid receipt format: field "total" must be a string, got number`},
+		// Test case 2: A numeric item price names the item
+		{`{"items": [{"shortDescription": "Pepsi", "price": "1.25"}, {"shortDescription": "Gum", "price": 1}]}`, `Invalid receipt format: field "items[1].price" must be a string, got number`},
+		// Test case 3: An array where the receipt object is expected
+		{`[{"retailer": "Target"}]`, `Invalid receipt format: receipt must be an object, got array`},
+		// Test case 4: An object where the items array is expected
+		{`{"items": {"shortDescription": "Pepsi", "price": "1.25"}}`, `Invalid receipt format: field "items" must be an array, got object`},
+		// Test case 5: A boolean
+		{`{"retailer": true}`, `Invalid receipt format: field "retailer" must be a string, got boolean`},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tt.body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, tt.message, decodeError(t, rr).Error)
+	}
+}
+
+func TestProcessReceiptBodyTooLarge(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	server.maxBodyBytes = 1024
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Build a receipt with enough items to exceed the limit
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Total:        "125.00",
+	}
+	for i := 0; i < 100; i++ {
+		receipt.Items = append(receipt.Items, Item{ShortDescription: "Pepsi - 12-oz", Price: "1.25"})
+	}
+
+	reqBody, _ := json.Marshal(receipt)
+	assert.Greater(t, len(reqBody), 1024)
+
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, "Request body too large", decodeError(t, rr).Error)
+
+	// The same receipt fits under the default limit
+	server.maxBodyBytes = defaultMaxBodyBytes
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestProcessReceiptIdempotencyKey(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	process := func(receipt Receipt, key string) *httptest.ResponseRecorder {
+		reqBody, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: Retrying with the same key returns the original id
+	var first, retry ReceiptResponse
+	rr := process(receipt, "key-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.Unmarshal(rr.Body.Bytes(), &first)
+
+	rr = process(receipt, "key-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.Unmarshal(rr.Body.Bytes(), &retry)
+
+	assert.Equal(t, first.ID, retry.ID)
+	assert.Equal(t, 1, store.Stats(context.Background()).Receipts)
+
+	// Test case 2: A different receipt under the same key is a conflict
+	changed := receipt
+	changed.Retailer = "Walgreens"
+	rr = process(changed, "key-1")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "Idempotency-Key was already used for a different receipt", decodeError(t, rr).Error)
+
+	// Test case 3: A new key or no key creates a new receipt
+	var other ReceiptResponse
+	rr = process(receipt, "key-2")
+	json.Unmarshal(rr.Body.Bytes(), &other)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	rr = process(receipt, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 3, store.Stats(context.Background()).Receipts)
+}
+
+func TestAddReceiptDedup(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	// Test case 1: Without dedup, identical receipts get separate ids
+	store := NewReceiptStore()
+	first := store.AddReceipt(context.Background(), receipt)
+	second := store.AddReceipt(context.Background(), receipt)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, 2, store.Stats(context.Background()).Receipts)
+
+	// Test case 2: With dedup, identical receipts share an id
+	store = NewReceiptStore()
+	store.dedup = true
+	first = store.AddReceipt(context.Background(), receipt)
+	second = store.AddReceipt(context.Background(), receipt)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, store.Stats(context.Background()).Receipts)
+
+	// Different receipts still get their own id
+	changed := receipt
+	changed.PurchaseTime = "13:14"
+	assert.NotEqual(t, first, store.AddReceipt(context.Background(), changed))
+
+	// Once deleted, the receipt can be stored again under a new id
+	assert.True(t, store.DeleteReceipt(context.Background(), first))
+	third := store.AddReceipt(context.Background(), receipt)
+	assert.NotEqual(t, first, third)
+	_, exists := store.GetPoints(context.Background(), third)
+	assert.True(t, exists)
+}
+
+func TestReceiptStoreTTL(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	// Test case 1: Without a TTL, receipts never expire
+	store := NewReceiptStore()
+	id := store.AddReceipt(context.Background(), receipt)
+	store.shardFor(id).addedAt[id] = time.Now().Add(-365 * 24 * time.Hour)
+	store.sweepExpired(time.Now())
+	_, exists := store.GetPoints(context.Background(), id)
+	assert.True(t, exists)
+
+	// Test case 2: Expired receipts are not found, even before a sweep
+	store = NewReceiptStore()
+	store.ttl = time.Hour
+	expired := store.AddReceipt(context.Background(), receipt)
+	fresh := store.AddReceipt(context.Background(), receipt)
+	store.shardFor(expired).addedAt[expired] = time.Now().Add(-2 * time.Hour)
+
+	_, exists = store.GetPoints(context.Background(), expired)
+	assert.False(t, exists)
+	_, exists = store.GetReceipt(context.Background(), expired)
+	assert.False(t, exists)
+	_, total := store.ListReceipts(context.Background(), ReceiptFilter{}, 10, 0)
+	assert.Equal(t, 1, total)
+
+	// Test case 3: The sweeper evicts expired receipts and keeps fresh ones
+	store.sweepExpired(time.Now())
+	assert.NotContains(t, store.shardFor(expired).receipts, expired)
+	assert.NotContains(t, store.shardFor(expired).points, expired)
+	assert.NotContains(t, store.shardFor(expired).addedAt, expired)
+	points, exists := store.GetPoints(context.Background(), fresh)
+	assert.True(t, exists)
+	assert.Equal(t, calculatePoints(receipt, currentRules()), points)
+}
+
+func TestReceiptStoreMaxReceipts(t *testing.T) {
+	store := NewReceiptStore()
+	store.maxReceipts = 3
+	store.idGenerator = &counterIDGenerator{}
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		store.AddReceipt(ctx, validReceipt())
+	}
+
+	// Test case 1: The oldest receipts are evicted once the cap is passed
+	for _, id := range []string{"receipt-1", "receipt-2"} {
+		_, exists := store.GetPoints(ctx, id)
+		assert.False(t, exists, id)
+	}
+	for _, id := range []string{"receipt-3", "receipt-4", "receipt-5"} {
+		_, exists := store.GetPoints(ctx, id)
+		assert.True(t, exists, id)
+	}
+	assert.Equal(t, 3, store.Stats(ctx).Receipts)
+
+	// Test case 2: Evicted receipts get 404 from the points endpoint
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+	req, _ := http.NewRequest("GET", "/receipts/receipt-1/points", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Test case 3: Deleting a receipt frees its place
+	store.DeleteReceipt(ctx, "receipt-4")
+	store.AddReceipt(ctx, validReceipt())
+	assert.Equal(t, []string{"receipt-3", "receipt-5", "receipt-6"}, store.ReceiptIDs(ctx))
+
+	// Test case 4: Imports count towards the cap
+	assert.NoError(t, store.ImportReceipt(ctx, "imported", validReceipt(), 10, nil))
+	assert.Equal(t, []string{"imported", "receipt-5", "receipt-6"}, store.ReceiptIDs(ctx))
+}
+
+func TestReceiptStoreShards(t *testing.T) {
+	store := NewReceiptStore()
+	assert.Len(t, store.shards, defaultReceiptShards)
+
+	ids := []string{}
+	for i := 0; i < 200; i++ {
+		ids = append(ids, store.AddReceipt(context.Background(), validReceipt()))
+	}
+
+	// Test case 1: Receipts are spread across the shards
+	used := 0
+	for _, shard := range store.shards {
+		if len(shard.receipts) > 0 {
+			used++
+		}
+	}
+	assert.Greater(t, used, defaultReceiptShards/2)
+
+	// Test case 2: Every receipt is found through its shard and counted once
+	for _, id := range ids {
+		_, exists := store.GetPoints(context.Background(), id)
+		assert.True(t, exists)
+	}
+	_, total := store.ListReceipts(context.Background(), ReceiptFilter{}, 1, 0)
+	assert.Equal(t, len(ids), total)
+	assert.Equal(t, len(ids), store.Stats(context.Background()).Receipts)
+}
+
+func TestReceiptStoreConcurrentAdds(t *testing.T) {
+	const writers, perWriter = 8, 100
+	receipt := validReceipt()
+	expected := calculatePoints(receipt, currentRules())
+
+	for _, dedup := range []bool{false, true} {
+		store := NewReceiptStore()
+		store.dedup = dedup
+
+		// Readers run alongside the writers so the race detector sees
+		// every lock in use
+		var wg sync.WaitGroup
+		ids := make(chan string, writers*perWriter)
+		for w := 0; w < writers; w++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					ids <- store.AddReceipt(context.Background(), receipt)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					store.Stats(context.Background())
+					store.ListReceipts(context.Background(), ReceiptFilter{}, 10, 0)
+				}
+			}()
+		}
+		wg.Wait()
+		close(ids)
+
+		// Test case 1: Every receipt is stored with its points
+		unique := map[string]bool{}
+		for id := range ids {
+			points, exists := store.GetPoints(context.Background(), id)
+			assert.True(t, exists)
+			assert.Equal(t, expected, points)
+			unique[id] = true
+		}
+
+		// Test case 2: With dedup, every writer gets the same id
+		if dedup {
+			assert.Len(t, unique, 1)
+			assert.Equal(t, 1, store.Stats(context.Background()).Receipts)
+		} else {
+			assert.Len(t, unique, writers*perWriter)
+			assert.Equal(t, writers*perWriter, store.Stats(context.Background()).Receipts)
+		}
+	}
+}
+
+// BenchmarkReceiptStoreAddReceipt compares concurrent writes to a single
+// shard, which is equivalent to one store-wide lock, with the default
+// sharded store
+func BenchmarkReceiptStoreAddReceipt(b *testing.B) {
+	for _, shards := range []int{1, defaultReceiptShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			store := newShardedReceiptStore(shards)
+			receipt := validReceipt()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					store.AddReceipt(context.Background(), receipt)
+				}
+			})
+		})
+	}
+}
+
+func TestReceiptChanges(t *testing.T) {
+	store := NewReceiptStore()
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+
+	changes := func(since string) ReceiptChangesResponse {
+		req, _ := http.NewRequest("GET", "/receipts/changes?since="+url.QueryEscape(since), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response ReceiptChangesResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	start := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	morning := validReceipt()
+	morning.PurchaseTime = "08:13"
+	early := []string{
+		store.AddReceipt(context.Background(), validReceipt()),
+		store.AddReceipt(context.Background(), morning),
+	}
+	time.Sleep(2 * time.Millisecond)
+	window := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	late := []string{
+		store.AddReceipt(context.Background(), validReceipt()),
+		store.AddReceipt(context.Background(), morning),
+	}
+
+	// Test case 1: Every receipt stored after since, in the order stored
+	response := changes(start.Format(time.RFC3339Nano))
+	ids := []string{}
+	for _, summary := range response.Receipts {
+		ids = append(ids, summary.ID)
+		assert.NotNil(t, summary.AddedAt)
+	}
+	assert.Len(t, ids, 4)
+	assert.ElementsMatch(t, early, ids[:2])
+	assert.ElementsMatch(t, late, ids[2:])
+	assert.Equal(t, calculatePoints(validReceipt(), currentRules()), response.Receipts[0].Points)
+
+	// Test case 2: Only the receipts stored after the window starts
+	response = changes(window.Format(time.RFC3339Nano))
+	assert.Len(t, response.Receipts, 2)
+	assert.ElementsMatch(t, late, []string{response.Receipts[0].ID, response.Receipts[1].ID})
+	assert.True(t, response.Receipts[0].AddedAt.After(window))
+	assert.True(t, response.Since.Equal(window))
+
+	// Test case 3: Passing the last addedAt back returns nothing new, and
+	// deleted receipts are not listed
+	last := response.Receipts[1].AddedAt.Format(time.RFC3339Nano)
+	assert.Empty(t, changes(last).Receipts)
+	store.DeleteReceipt(context.Background(), late[0])
+	assert.Len(t, changes(window.Format(time.RFC3339Nano)).Receipts, 1)
+
+	// Test case 4: since is required and must be RFC 3339
+	for _, since := range []string{"", "2022-01-01", "yesterday"} {
+		req, _ := http.NewRequest("GET", "/receipts/changes?since="+url.QueryEscape(since), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, since)
+		assert.Contains(t, decodeError(t, rr).Error, "Invalid since", since)
+	}
+}
+
+func TestListReceipts(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts", server.ListReceiptsHandler).Methods("GET")
+
+	// Store receipts out of date order
+	ids := map[string]string{}
+	for _, date := range []string{"2022-01-03", "2022-01-01", "2022-01-02"} {
+		ids[date] = store.AddReceipt(context.Background(), Receipt{
+			Retailer:     "Target",
+			PurchaseDate: date,
+			PurchaseTime: "13:13",
+			Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+			Total:        "1.25",
+		})
+	}
+
+	list := func(query string) (*httptest.ResponseRecorder, ReceiptListResponse) {
+		req, _ := http.NewRequest("GET", "/receipts"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response ReceiptListResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// Test case 1: Defaults return everything sorted by purchase date
+	rr, response := list("")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, defaultListLimit, response.Limit)
+	assert.Len(t, response.Receipts, 3)
+	assert.Equal(t, ids["2022-01-01"], response.Receipts[0].ID)
+	assert.Equal(t, ids["2022-01-02"], response.Receipts[1].ID)
+	assert.Equal(t, ids["2022-01-03"], response.Receipts[2].ID)
+	assert.Equal(t, ReceiptSummary{
+		ID:           ids["2022-01-01"],
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		Total:        "1.25",
+		Points:       37,
+	}, response.Receipts[0])
+
+	// Test case 2: Limit and offset select a page
+	_, response = list("?limit=1&offset=1")
+	assert.Equal(t, 3, response.Total)
+	assert.Len(t, response.Receipts, 1)
+	assert.Equal(t, ids["2022-01-02"], response.Receipts[0].ID)
+
+	// Test case 3: Offsets past the end return an empty page
+	_, response = list("?offset=10")
+	assert.Empty(t, response.Receipts)
+	assert.NotNil(t, response.Receipts)
+
+	// Test case 4: Limits are capped
+	_, response = list("?limit=1000")
+	assert.Equal(t, maxListLimit, response.Limit)
+
+	// Test case 5: Invalid parameters
+	rr, _ = list("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = list("?offset=-1")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = list("?limit=ten")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestReceiptLabels(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/process", server.ProcessReceiptHandler).Methods("POST")
+	router.HandleFunc("/receipts", server.ListReceiptsHandler).Methods("GET")
+
+	process := func(query string, receipt Receipt) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process"+query, bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	list := func(query string) ReceiptListResponse {
+		req, _ := http.NewRequest("GET", "/receipts"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response ReceiptListResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	// Test case 1: Labels come from the receipt or the query parameter
+	labeled := validReceipt()
+	labeled.Label = "business"
+	assert.Equal(t, http.StatusOK, process("", labeled).Code)
+	assert.Equal(t, http.StatusOK, process("?label=business", validReceipt()).Code)
+	assert.Equal(t, http.StatusOK, process("?label=personal", validReceipt()).Code)
+	assert.Equal(t, http.StatusOK, process("", validReceipt()).Code)
+
+	// Test case 2: The label is stored but not scored
+	response := list("?label=business")
+	assert.Equal(t, 2, response.Total)
+	for _, summary := range response.Receipts {
+		assert.Equal(t, "business", summary.Label)
+		assert.Equal(t, calculatePoints(validReceipt(), currentRules()), summary.Points)
+	}
+
+	// Test case 3: Filtering by another label, or not at all
+	response = list("?label=personal")
+	assert.Equal(t, 1, response.Total)
+	assert.Equal(t, "personal", response.Receipts[0].Label)
+	assert.Equal(t, 4, list("").Total)
+	assert.Empty(t, list("?label=travel").Receipts)
+
+	// Test case 4: Conflicting and overlong labels are rejected
+	assert.Equal(t, http.StatusBadRequest, process("?label=personal", labeled).Code)
+	rr := process("?label="+strings.Repeat("a", validationLimits.MaxLabelLength+1), validReceipt())
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Label must be at most 64 characters")
+	assert.Equal(t, 4, list("").Total)
+}
+
+func TestStats(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/stats", server.StatsHandler).Methods("GET")
+
+	get := func() StatsResponse {
+		req, _ := http.NewRequest("GET", "/stats", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response StatsResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	// Test case 1: An empty store
+	assert.Equal(t, StatsResponse{Retailers: map[string]int{}}, get())
+
+	// Test case 2: Stats reflect the receipts currently stored
+	target := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		Total:        "1.25",
+	}
+	walgreens := target
+	walgreens.Retailer = "Walgreens"
+
+	store.AddReceipt(context.Background(), target)
+	id := store.AddReceipt(context.Background(), target)
+	store.AddReceipt(context.Background(), walgreens)
+
+	targetPoints := calculatePoints(target, currentRules())
+	walgreensPoints := calculatePoints(walgreens, currentRules())
+	total := 2*targetPoints + walgreensPoints
+	assert.Equal(t, StatsResponse{
+		Receipts:      3,
+		TotalPoints:   total,
+		AveragePoints: float64(total) / 3,
+		Retailers:     map[string]int{"Target": 2, "Walgreens": 1},
+	}, get())
+
+	// Test case 3: Deleted receipts no longer count
+	store.DeleteReceipt(context.Background(), id)
+	assert.Equal(t, 2, get().Receipts)
+}
+
+func TestStatsNormalizedRetailers(t *testing.T) {
+	defer setActiveRules(currentRules())
+	rules := DefaultRuleSet()
+	rules.NormalizeRetailers = true
+	setActiveRules(rules)
+
+	store := NewReceiptStore()
+	server := NewServer(store)
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+
+	// Test case 1: Casing and spacing variants are grouped under one retailer
+	receipt := Receipt{
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		Total:        "1.25",
+	}
+	var ids []string
+	for _, retailer := range []string{"Target", "  target  ", "TARGET", "Walgreens"} {
+		receipt.Retailer = retailer
+		reqBody, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response ReceiptResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		ids = append(ids, response.ID)
+	}
+
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var stats StatsResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, map[string]int{"target": 3, "walgreens": 1}, stats.Retailers)
+
+	// Test case 2: The original name is kept and still used for scoring
+	stored, _ := store.GetReceipt(context.Background(), ids[1])
+	assert.Equal(t, "  target  ", stored.Retailer)
+	assert.Equal(t, "target", stored.NormalizedRetailer)
+	points, _ := store.GetPoints(context.Background(), ids[1])
+	receipt.Retailer = "  target  "
+	assert.Equal(t, calculatePoints(receipt, DefaultRuleSet()), points)
+}
+
+func TestValidateReceiptEndpoint(t *testing.T) {
+	store := &fakeStore{id: "unused"}
+	server := NewServer(store)
+	handler := http.HandlerFunc(server.ValidateReceiptHandler)
+
+	// Test case 1: A valid receipt is scored but not stored
+	reqBody, _ := json.Marshal(validReceipt())
+	req, _ := http.NewRequest("POST", "/receipts/validate", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ValidateResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, ValidateResponse{Valid: true, Points: calculatePoints(validReceipt(), currentRules())}, response)
+	assert.Empty(t, store.added)
+
+	// Test case 2: Invalid receipts get the same error as the process endpoint
+	receipt := validReceipt()
+	receipt.Total = "4.51"
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/validate", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Total does not match sum of items", decodeError(t, rr).Error)
+	assert.Empty(t, store.added)
+}
+
+func TestScoreEndpoint(t *testing.T) {
+	store := &fakeStore{id: "unused"}
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+
+	// Test case 1: Only the points are returned and nothing is stored
+	reqBody, _ := json.Marshal(validReceipt())
+	req, _ := http.NewRequest("POST", "/score", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"points": %d}`, calculatePoints(validReceipt(), currentRules())), rr.Body.String())
+	assert.Empty(t, store.added)
+
+	// Test case 2: Receipts are fully validated
+	receipt := validReceipt()
+	receipt.PurchaseDate = "2022-02-30"
+	reqBody, _ = json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/score", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid purchase date. 2022-02-30 is not a calendar date", decodeError(t, rr).Error)
+	assert.Empty(t, store.added)
+}
+
+func TestScoreWithRules(t *testing.T) {
+	store := &fakeStore{id: "unused"}
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+
+	score := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/score/with-rules", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	receiptJSON, _ := json.Marshal(validReceipt())
+
+	// Test case 1: The same receipt scores differently under two rulesets,
+	// and rules left out keep their default values
+	doubled := DefaultRuleSet()
+	doubled.RetailerCharPoints = 2
+	noWindow := DefaultRuleSet()
+	noWindow.TimeWindowPoints = 0
+	for _, tt := range []struct {
+		rules    string
+		expected RuleSet
+	}{
+		{`{"retailerCharPoints": 2}`, doubled},
+		{`{"timeWindowPoints": 0}`, noWindow},
+	} {
+		rr := score(fmt.Sprintf(`{"receipt": %s, "rules": %s}`, receiptJSON, tt.rules))
+		assert.Equal(t, http.StatusOK, rr.Code, tt.rules)
+
+		var response ScoreWithRulesResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		points, breakdown := calculatePointsDetailed(validReceipt(), tt.expected)
+		assert.Equal(t, points, response.Points, tt.rules)
+		assert.Equal(t, breakdown, response.Breakdown, tt.rules)
+	}
+	defaultPoints := calculatePoints(validReceipt(), DefaultRuleSet())
+	assert.Equal(t, defaultPoints+len("MMCornerMarket"), calculatePoints(validReceipt(), doubled))
+	assert.Equal(t, defaultPoints-10, calculatePoints(validReceipt(), noWindow))
+
+	// Test case 2: Nothing is stored and the active rules are unchanged
+	assert.Empty(t, store.added)
+	assert.Equal(t, DefaultRuleSet(), currentRules())
+
+	// Test case 3: Invalid and missing rulesets are rejected
+	rr := score(fmt.Sprintf(`{"receipt": %s, "rules": {"descriptionLengthMultiple": 0}}`, receiptJSON))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid ruleset: descriptionLengthMultiple must be positive", decodeError(t, rr).Error)
+	rr = score(fmt.Sprintf(`{"receipt": %s, "rules": {"bonusPoints": 5}}`, receiptJSON))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `Invalid ruleset: unknown field "bonusPoints"`, decodeError(t, rr).Error)
+	rr = score(fmt.Sprintf(`{"receipt": %s}`, receiptJSON))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Missing rules", decodeError(t, rr).Error)
+
+	// Test case 4: The posted rules, not the active ones, decide which
+	// retailers are valid
+	unicodeReceipt := validReceipt()
+	unicodeReceipt.Retailer = "Café Zürich"
+	receiptJSON, _ = json.Marshal(unicodeReceipt)
+	rr = score(fmt.Sprintf(`{"receipt": %s, "rules": {"unicodeAlphanumeric": true, "normalizeRetailers": true}}`, receiptJSON))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ScoreWithRulesResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	unicodeRules := DefaultRuleSet()
+	unicodeRules.UnicodeAlphanumeric = true
+	assert.Equal(t, calculatePoints(unicodeReceipt, unicodeRules), response.Points)
+	rr = score(fmt.Sprintf(`{"receipt": %s, "rules": {"unicodeAlphanumeric": false}}`, receiptJSON))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid retailer", decodeError(t, rr).Error)
+
+	// Test case 5: The receipt is validated too
+	receipt := validReceipt()
+	receipt.Total = "4.51"
+	receiptJSON, _ = json.Marshal(receipt)
+	rr = score(fmt.Sprintf(`{"receipt": %s, "rules": {}}`, receiptJSON))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Total does not match sum of items", decodeError(t, rr).Error)
+
+	// Test case 6: Oversized rulesets are rejected before anything is scored
+	receiptJSON, _ = json.Marshal(validReceipt())
+	for rules, message := range map[string]string{
+		`{"weekdayBonuses": {"saturday": {"multiplier": 1e300}}}`:  "weekdayBonuses.saturday.multiplier must be between 0 and 1000",
+		`{"weekdayBonuses": {"saturday": {"points": 1000000000}}}`: "weekdayBonuses.saturday.points must be between 0 and 1000000",
+		`{"retailerBonuses": {"m&m*": 1000000000}}`:                "retailerBonuses.m&m* must be between 0 and 1000000",
+		`{"descriptionKeywordBonuses": {"gatorade": 1000000000}}`:  "descriptionKeywordBonuses.gatorade must be between 0 and 1000000",
+		`{"minTotalForPoints": 1e300}`:                             "minTotalForPoints must be between 0 and 1000000000",
+		`{"retailerCharPoints": 9000000000000000000}`:              "retailerCharPoints must be at most 1000000",
+	} {
+		rr = score(fmt.Sprintf(`{"receipt": %s, "rules": %s}`, receiptJSON, rules))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, rules)
+		assert.Equal(t, "Invalid ruleset: "+message, decodeError(t, rr).Error, rules)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	router := mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	req, _ := http.NewRequest("GET", "/reciepts/process", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	response := decodeError(t, rr)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "Not found", response.Error)
+	assert.Equal(t, "/reciepts/process", response.Path)
+}
+
+func TestProcessReceiptPurchaseDateTime(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.ProcessReceiptHandler)
+
+	// Test case 1: Combined timestamps are stored with the derived date and
+	// time, but scored in UTC, where 14:33 at -05:00 misses the time window
+	body := `{
+		"retailer": "M&M Corner Market",
+		"purchaseDateTime": "2022-03-20T14:33:00-05:00",
+		"items": [
+			{"shortDescription": "Gatorade", "price": "2.25"},
+			{"shortDescription": "Gatorade", "price": "2.25"},
+			{"shortDescription": "Gatorade", "price": "2.25"},
+			{"shortDescription": "Gatorade", "price": "2.25"}
+		],
+		"total": "9.00"
+	}`
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	points, _ := server.store.GetPoints(context.Background(), response.ID)
+	assert.Equal(t, 99, points)
+	stored, _ := server.store.GetReceipt(context.Background(), response.ID)
+	assert.Equal(t, "2022-03-20", stored.PurchaseDate)
+	assert.Equal(t, "14:33", stored.PurchaseTime)
+	assert.Equal(t, "2022-03-20T14:33:00-05:00", stored.PurchaseDateTime)
+
+	// Test case 2: Conflicting date fields are rejected
+	receipt := validReceipt()
+	receipt.PurchaseDateTime = "2022-03-21T14:33:00Z"
+	reqBody, _ := json.Marshal(receipt)
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "purchaseDateTime does not match purchaseDate and purchaseTime", decodeError(t, rr).Error)
+}
+
+func TestLeaderboard(t *testing.T) {
+	store := NewReceiptStore()
+	server := NewServer(store)
+	server.maxLeaderboardLimit = 2
+
+	router := mux.NewRouter()
+	router.HandleFunc("/leaderboard", server.LeaderboardHandler).Methods("GET")
+
+	// Two receipts tie on points, so they are ordered by id
+	receipt := validReceipt()
+	low := store.AddReceipt(context.Background(), receipt)
+	receipt.Retailer = "M&M Corner Market Plus"
+	tieA := store.AddReceipt(context.Background(), receipt)
+	tieB := store.AddReceipt(context.Background(), receipt)
+	if tieB < tieA {
+		tieA, tieB = tieB, tieA
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, LeaderboardResponse) {
+		req, _ := http.NewRequest("GET", "/leaderboard"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response LeaderboardResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// Test case 1: The limit is capped at the configured maximum
+	rr, response := get("")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2, response.Limit)
+	if assert.Len(t, response.Receipts, 2) {
+		assert.Equal(t, tieA, response.Receipts[0].ID)
+		assert.Equal(t, tieB, response.Receipts[1].ID)
+	}
+
+	// Test case 2: Everything is ranked by points, highest first
+	server.maxLeaderboardLimit = 10
+	_, response = get("?limit=5")
+	if assert.Len(t, response.Receipts, 3) {
+		assert.Equal(t, low, response.Receipts[2].ID)
+		assert.Greater(t, response.Receipts[0].Points, response.Receipts[2].Points)
+	}
+
+	// Test case 3: Invalid limits are rejected
+	rr, _ = get("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid limit", decodeError(t, rr).Error)
+}
+
+// FuzzProcessReceipt sends arbitrary bodies, as JSON or CSV, to the process
+// handler. It must never panic, and every response must be a JSON success
+// or client error. Run it with make fuzz.
+func FuzzProcessReceipt(f *testing.F) {
+	for _, path := range []string{"examples/simple-receipt.json", "examples/morning-receipt.json"} {
+		seed, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(seed, false)
+	}
+	f.Add([]byte(`{"retailer":"Target","purchaseDateTime":"2022-01-01T13:01:00-05:00","items":[{"shortDescription":"Pepsi","price":"1,25"}],"total":"1,25","currency":"EUR"}`), false)
+	f.Add([]byte("retailer,Target\npurchaseDate,2022-01-01\npurchaseTime,13:01\ntotal,1.25\nPepsi,1.25\n"), true)
+	f.Add([]byte(`[]`), false)
+	f.Add([]byte(`{"items":[{"price":1}]}`), false)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/receipts/process", NewServer(NewReceiptStore()).ProcessReceiptHandler).Methods("POST")
+
+	f.Fuzz(func(t *testing.T, body []byte, csv bool) {
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewReader(body))
+		if csv {
+			req.Header.Set("Content-Type", "text/csv")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		switch rr.Code {
+		case http.StatusOK:
+			var response ReceiptResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.ID == "" {
+				t.Fatalf("invalid success response %q", rr.Body.String())
+			}
+		case http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge:
+			var response ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.Error == "" || response.Status != rr.Code {
+				t.Fatalf("invalid error response %q", rr.Body.String())
+			}
+		default:
+			t.Fatalf("unexpected status %d for body %q: %s", rr.Code, body, rr.Body.String())
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoveryWriter notes whether the response has started, since a 500 can only
+// be sent before then
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (rw *recoveryWriter) WriteHeader(status int) {
+	rw.started = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recoveryWriter) Write(b []byte) (int, error) {
+	rw.started = true
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recoveryWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.started = true
+		flusher.Flush()
+	}
+}
+
+func (rw *recoveryWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// RecoveryMiddleware turns a panicking handler into a 500 JSON error, logging
+// the panic and its stack trace with the request id, so that one bad request
+// can't take down its connection or the process. It belongs directly around
+// the router so the stack trace is the handler's own.
+func RecoveryMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			logger.Error("handler panicked",
+				slog.String("requestId", requestIDFromContext(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Any("panic", p),
+				slog.String("stack", string(debug.Stack())),
+			)
+			if !rw.started {
+				writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	routes := http.NewServeMux()
+	routes.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var points map[string]int
+		points["boom"]++
+	})
+	routes.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("after the response started")
+	})
+	routes.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	// The same order as serve(), so panics cross the timeout goroutine
+	var handler http.Handler = RecoveryMiddleware(logger, routes)
+	handler = TimeoutMiddleware(time.Second, handler)
+	handler = LoggingMiddleware(slog.New(slog.NewJSONHandler(io.Discard, nil)), handler)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Test case 1: A panic becomes a 500 JSON error
+	req, _ := http.NewRequest("GET", server.URL+"/panic", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var response ErrorResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, ErrorResponse{Error: "Internal server error", Status: http.StatusInternalServerError}, response)
+
+	// Test case 2: The panic is logged with the request id and stack trace
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "handler panicked", entry["msg"])
+	assert.Equal(t, "req-123", entry["requestId"])
+	assert.Contains(t, entry["panic"], "assignment to entry in nil map")
+	assert.True(t, strings.Contains(entry["stack"].(string), "TestRecoveryMiddleware"))
+
+	// Test case 3: A response that already started is left alone
+	resp, err = http.Get(server.URL + "/partial")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// Test case 4: The server keeps serving
+	resp, err = http.Get(server.URL + "/ok")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestRecoveryMiddlewareAbortHandler(t *testing.T) {
+	handler := RecoveryMiddleware(slog.New(slog.NewJSONHandler(io.Discard, nil)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	// ErrAbortHandler is passed on so net/http can abort the response quietly
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Key layout used by the Redis store. The receipts set indexes every stored
+// id for listing, and the added sorted set scores each id by when it was
+// stored, in Unix microseconds.
+const (
+	redisReceiptIDsKey = "receipts"
+	redisAddedKey      = "receipts:added"
+)
+
+func redisReceiptKey(id string) string      { return "receipt:" + id }
+func redisPointsKey(id string) string       { return "points:" + id }
+func redisBreakdownKey(id string) string    { return "breakdown:" + id }
+func redisIdempotencyKey(key string) string { return "idempotency:" + key }
+func redisHashKey(hash string) string       { return "hash:" + hash }
+
+// redisTxRetries bounds how often an optimistic transaction is retried when a
+// watched key changes underneath it
+const redisTxRetries = 3
+
+// Shared storage backed by Redis, so several server instances can serve the
+// same receipts
+type RedisReceiptStore struct {
+	client *redis.Client
+
+	// dedup makes AddReceipt return the existing id for identical receipts
+	dedup bool
+
+	// idGenerator creates the id of each new receipt
+	idGenerator IDGenerator
+}
+
+func NewRedisReceiptStore(url string) (*RedisReceiptStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisReceiptStore{client: client, idGenerator: UUIDGenerator{}}, nil
+}
+
+func (rs *RedisReceiptStore) Close() error {
+	return rs.client.Close()
+}
+
+// watch runs fn in an optimistic transaction over the given keys, retrying
+// when one of them is modified concurrently
+func (rs *RedisReceiptStore) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	var err error
+	for attempt := 0; attempt < redisTxRetries; attempt++ {
+		err = rs.client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// AddReceipt stores the receipt and its points, returning an empty id if the
+// write fails.
+func (rs *RedisReceiptStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	hashKey := redisHashKey(receiptHash(receipt))
+
+	var id string
+	err := rs.watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		id, err = rs.putReceipt(ctx, tx, hashKey, receipt, nil)
+		return err
+	}, hashKey)
+	if err != nil {
+		slog.Error("failed to store receipt", "error", err)
+		return ""
+	}
+
+	return id
+}
+
+func (rs *RedisReceiptStore) AddReceiptIdempotent(ctx context.Context, key string, receipt Receipt) (string, bool, error) {
+	idempotencyKey := redisIdempotencyKey(key)
+	hashKey := redisHashKey(receiptHash(receipt))
+
+	var id string
+	replayed := false
+	err := rs.watch(ctx, func(tx *redis.Tx) error {
+		// A key whose receipt has since been deleted is treated as unused
+		existing, err := tx.Get(ctx, idempotencyKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if existing != "" {
+			stored, found, err := rs.getReceipt(ctx, tx, existing)
+			if err != nil {
+				return err
+			}
+			if found {
+				if !reflect.DeepEqual(stored, receipt) {
+					return ErrIdempotencyConflict
+				}
+				id = existing
+				replayed = true
+				return nil
+			}
+		}
+
+		id, err = rs.putReceipt(ctx, tx, hashKey, receipt, func(pipe redis.Pipeliner, id string) {
+			pipe.Set(ctx, idempotencyKey, id, 0)
+		})
+		return err
+	}, idempotencyKey, hashKey)
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, replayed, nil
+}
+
+// putReceipt writes a receipt and its points under a new id in a single
+// MULTI transaction, or returns the id of an identical receipt when dedup is
+// enabled. extra queues additional writes in the same transaction.
+func (rs *RedisReceiptStore) putReceipt(ctx context.Context, tx *redis.Tx, hashKey string, receipt Receipt, extra func(pipe redis.Pipeliner, id string)) (string, error) {
+	if rs.dedup {
+		existing, err := tx.Get(ctx, hashKey).Result()
+		if err == nil {
+			if extra != nil {
+				_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					extra(pipe, existing)
+					return nil
+				})
+			}
+			return existing, err
+		}
+		if !errors.Is(err, redis.Nil) {
+			return "", err
+		}
+	}
+
+	id := rs.idGenerator.Generate()
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return "", err
+	}
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisReceiptKey(id), receiptJSON, 0)
+		pipe.Set(ctx, redisPointsKey(id), points, 0)
+		pipe.Set(ctx, redisBreakdownKey(id), breakdownJSON, 0)
+		pipe.Set(ctx, hashKey, id, 0)
+		pipe.SAdd(ctx, redisReceiptIDsKey, id)
+		pipe.ZAdd(ctx, redisAddedKey, redisAddedNow(id))
+		if extra != nil {
+			extra(pipe, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+	return id, nil
+}
+
+// getReceipt reads and decodes a stored receipt
+func (rs *RedisReceiptStore) getReceipt(ctx context.Context, cmd redis.Cmdable, id string) (Receipt, bool, error) {
+	value, err := cmd.Get(ctx, redisReceiptKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Receipt{}, false, nil
+	}
+	if err != nil {
+		return Receipt{}, false, err
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(value, &receipt); err != nil {
+		return Receipt{}, false, err
+	}
+	return receipt, true, nil
+}
+
+func (rs *RedisReceiptStore) GetPoints(ctx context.Context, id string) (int, bool) {
+	points, err := rs.client.Get(ctx, redisPointsKey(id)).Int()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("failed to read points", "id", id, "error", err)
+		}
+		return 0, false
+	}
+	return points, true
+}
+
+func (rs *RedisReceiptStore) GetBreakdown(ctx context.Context, id string) ([]PointsBreakdown, bool) {
+	value, err := rs.client.Get(ctx, redisBreakdownKey(id)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("failed to read points breakdown", "id", id, "error", err)
+		}
+		return nil, false
+	}
+
+	var breakdown []PointsBreakdown
+	if err := json.Unmarshal(value, &breakdown); err != nil {
+		slog.Error("failed to decode points breakdown", "id", id, "error", err)
+		return nil, false
+	}
+	return breakdown, true
+}
+
+func (rs *RedisReceiptStore) GetReceipt(ctx context.Context, id string) (Receipt, bool) {
+	receipt, found, err := rs.getReceipt(ctx, rs.client, id)
+	if err != nil {
+		slog.Error("failed to read receipt", "id", id, "error", err)
+		return Receipt{}, false
+	}
+	return receipt, found
+}
+
+func (rs *RedisReceiptStore) AddReceiptWithID(ctx context.Context, id string, receipt Receipt) error {
+	receiptKey := redisReceiptKey(id)
+	rules := currentRules()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return err
+	}
+
+	return rs.watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, receiptKey).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 1 {
+			return ErrReceiptExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, receiptKey, receiptJSON, 0)
+			pipe.Set(ctx, redisPointsKey(id), points, 0)
+			pipe.Set(ctx, redisBreakdownKey(id), breakdownJSON, 0)
+			pipe.Set(ctx, redisHashKey(receiptHash(receipt)), id, 0)
+			pipe.SAdd(ctx, redisReceiptIDsKey, id)
+			pipe.ZAdd(ctx, redisAddedKey, redisAddedNow(id))
+			return nil
+		})
+		if err == nil {
+			reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+		}
+		return err
+	}, receiptKey)
+}
+
+func (rs *RedisReceiptStore) FindReceipt(ctx context.Context, receipt Receipt) (string, bool) {
+	id, err := rs.client.Get(ctx, redisHashKey(receiptHash(receipt))).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("failed to look up receipt", "error", err)
+		}
+		return "", false
+	}
+
+	exists, err := rs.client.Exists(ctx, redisReceiptKey(id)).Result()
+	if err != nil {
+		slog.Error("failed to look up receipt", "id", id, "error", err)
+		return "", false
+	}
+	return id, exists == 1
+}
+
+func (rs *RedisReceiptStore) DeleteReceipt(ctx context.Context, id string) bool {
+	receiptKey := redisReceiptKey(id)
+
+	deleted := false
+	err := rs.watch(ctx, func(tx *redis.Tx) error {
+		receipt, found, err := rs.getReceipt(ctx, tx, id)
+		if err != nil || !found {
+			return err
+		}
+
+		hashKey := redisHashKey(receiptHash(receipt))
+		hashID, err := tx.Get(ctx, hashKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, receiptKey, redisPointsKey(id), redisBreakdownKey(id))
+			pipe.SRem(ctx, redisReceiptIDsKey, id)
+			pipe.ZRem(ctx, redisAddedKey, id)
+			if hashID == id {
+				pipe.Del(ctx, hashKey)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	}, receiptKey)
+	if err != nil {
+		slog.Error("failed to delete receipt", "id", id, "error", err)
+		return false
+	}
+	return deleted
+}
+
+func (rs *RedisReceiptStore) ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptSummary, int) {
+	summaries, err := rs.summaries(ctx)
+	if err != nil {
+		slog.Error("failed to list receipts", "error", err)
+		return []ReceiptSummary{}, 0
+	}
+	summaries = filterSummaries(summaries, filter)
+
+	return paginateSummaries(summaries, limit, offset), len(summaries)
+}
+
+func (rs *RedisReceiptStore) TopReceipts(ctx context.Context, n int) []ReceiptSummary {
+	summaries, err := rs.summaries(ctx)
+	if err != nil {
+		slog.Error("failed to rank receipts", "error", err)
+		return []ReceiptSummary{}
+	}
+
+	return topSummaries(summaries, n)
+}
+
+// summaries returns a summary of every indexed receipt, in no particular order
+func (rs *RedisReceiptStore) summaries(ctx context.Context) ([]ReceiptSummary, error) {
+	ids, err := rs.client.SMembers(ctx, redisReceiptIDsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	receiptCmds := make([]*redis.StringCmd, len(ids))
+	pointsCmds := make([]*redis.StringCmd, len(ids))
+	_, err = rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			receiptCmds[i] = pipe.Get(ctx, redisReceiptKey(id))
+			pointsCmds[i] = pipe.Get(ctx, redisPointsKey(id))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	summaries := make([]ReceiptSummary, 0, len(ids))
+	for i, id := range ids {
+		// Skip receipts deleted between reading the index and the keys
+		receiptJSON, err := receiptCmds[i].Bytes()
+		if err != nil {
+			continue
+		}
+		var receipt Receipt
+		if err := json.Unmarshal(receiptJSON, &receipt); err != nil {
+			slog.Error("failed to decode receipt", "id", id, "error", err)
+			continue
+		}
+		points, err := strconv.Atoi(pointsCmds[i].Val())
+		if err != nil {
+			slog.Error("failed to decode points", "id", id, "error", err)
+			continue
+		}
+		summaries = append(summaries, newReceiptSummary(id, receipt, points))
+	}
+	return summaries, nil
+}
+
+// redisAddedNow scores id in the added set as stored now. Microseconds keep
+// the score exact in the float64 Redis uses.
+func redisAddedNow(id string) redis.Z {
+	return redis.Z{Score: float64(time.Now().UnixMicro()), Member: id}
+}
+
+func (rs *RedisReceiptStore) ChangesSince(ctx context.Context, since time.Time) []ReceiptSummary {
+	added, err := rs.client.ZRangeByScoreWithScores(ctx, redisAddedKey, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(since.UnixMicro(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		slog.Error("failed to list receipt changes", "error", err)
+		return []ReceiptSummary{}
+	}
+
+	changes := make([]ReceiptSummary, 0, len(added))
+	for _, entry := range added {
+		id := entry.Member.(string)
+		receipt, found, err := rs.getReceipt(ctx, rs.client, id)
+		if err != nil || !found {
+			continue
+		}
+		points, exists := rs.GetPoints(ctx, id)
+		if !exists {
+			continue
+		}
+		changes = append(changes, newReceiptChange(id, receipt, points, time.UnixMicro(int64(entry.Score)).UTC()))
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func (rs *RedisReceiptStore) RecomputeAll(ctx context.Context) int {
+	ids, err := rs.client.SMembers(ctx, redisReceiptIDsKey).Result()
+	if err != nil {
+		slog.Error("failed to recompute points", "error", err)
+		return 0
+	}
+
+	rules := currentRules()
+	updated := 0
+	for _, id := range ids {
+		receipt, found, err := rs.getReceipt(ctx, rs.client, id)
+		if err != nil {
+			slog.Error("failed to read receipt", "id", id, "error", err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		points, breakdown := calculatePointsDetailed(receipt, rules)
+		breakdownJSON, err := json.Marshal(breakdown)
+		if err != nil {
+			slog.Error("failed to update points", "id", id, "error", err)
+			continue
+		}
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisPointsKey(id), points, 0)
+			pipe.Set(ctx, redisBreakdownKey(id), breakdownJSON, 0)
+			return nil
+		})
+		if err != nil {
+			slog.Error("failed to update points", "id", id, "error", err)
+			continue
+		}
+		reportScored(ctx, ScoredReceipt{ID: id, Receipt: receipt, Points: points, Rules: rules})
+		updated++
+	}
+	return updated
+}
+
+func (rs *RedisReceiptStore) Stats(ctx context.Context) StatsResponse {
+	ids, err := rs.client.SMembers(ctx, redisReceiptIDsKey).Result()
+	if err != nil {
+		slog.Error("failed to compute stats", "error", err)
+		return newStatsResponse()
+	}
+
+	stats := newStatsResponse()
+	for _, id := range ids {
+		receipt, found, err := rs.getReceipt(ctx, rs.client, id)
+		if err != nil || !found {
+			continue
+		}
+		points, exists := rs.GetPoints(ctx, id)
+		if !exists {
+			continue
+		}
+		stats.add(receipt, points)
+	}
+	return stats
+}
+
+func (rs *RedisReceiptStore) ReceiptIDs(ctx context.Context) []string {
+	ids, err := rs.client.SMembers(ctx, redisReceiptIDsKey).Result()
+	if err != nil {
+		slog.Error("failed to list receipt ids", "error", err)
+		return []string{}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (rs *RedisReceiptStore) ImportReceipt(ctx context.Context, id string, receipt Receipt, points int, breakdown []PointsBreakdown) error {
+	receiptKey := redisReceiptKey(id)
+	hashKey := redisHashKey(receiptHash(receipt))
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	breakdownJSON, err := json.Marshal(breakdown)
+	if err != nil {
+		return err
+	}
+
+	return rs.watch(ctx, func(tx *redis.Tx) error {
+		// Drop the dedup entry of a receipt being replaced
+		staleHashKey := ""
+		previous, found, err := rs.getReceipt(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if key := redisHashKey(receiptHash(previous)); found && key != hashKey {
+			hashID, err := tx.Get(ctx, key).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if hashID == id {
+				staleHashKey = key
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, receiptKey, receiptJSON, 0)
+			pipe.Set(ctx, redisPointsKey(id), points, 0)
+			pipe.Set(ctx, redisBreakdownKey(id), breakdownJSON, 0)
+			pipe.Set(ctx, hashKey, id, 0)
+			pipe.SAdd(ctx, redisReceiptIDsKey, id)
+			pipe.ZAdd(ctx, redisAddedKey, redisAddedNow(id))
+			if staleHashKey != "" {
+				pipe.Del(ctx, staleHashKey)
+			}
+			return nil
+		})
+		return err
+	}, receiptKey)
+}
+
+// Clear deletes the receipts index and every key the store writes. Keys are
+// found with SCAN, so the index is watched to retry when a receipt is added
+// while the keys are being collected.
+func (rs *RedisReceiptStore) Clear(ctx context.Context) int {
+	cleared := 0
+	err := rs.watch(ctx, func(tx *redis.Tx) error {
+		count, err := tx.SCard(ctx, redisReceiptIDsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		keys := []string{redisReceiptIDsKey, redisAddedKey}
+		for _, pattern := range []string{redisReceiptKey("*"), redisPointsKey("*"), redisBreakdownKey("*"), redisIdempotencyKey("*"), redisHashKey("*")} {
+			iter := tx.Scan(ctx, 0, pattern, 0).Iterator()
+			for iter.Next(ctx) {
+				keys = append(keys, iter.Val())
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cleared = int(count)
+		return nil
+	}, redisReceiptIDsKey)
+	if err != nil {
+		slog.Error("failed to clear receipts", "error", err)
+		return 0
+	}
+	return cleared
+}
+
+// Ping checks that the Redis server is reachable
+func (rs *RedisReceiptStore) Ping(ctx context.Context) error {
+	return rs.client.Ping(ctx).Err()
+}
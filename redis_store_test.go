@@ -0,0 +1,221 @@
+//go:build redis
+
+// Integration tests against a local Redis server. Run them with
+//
+//	go test -tags redis ./...
+//
+// REDIS_URL selects the server, defaulting to database 15 on localhost. The
+// database is flushed before each test.
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) *RedisReceiptStore {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		url = "redis://localhost:6379/15"
+	}
+
+	store, err := NewRedisReceiptStore(url)
+	require.NoError(t, err)
+	require.NoError(t, store.client.FlushDB(context.Background()).Err())
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisReceiptStore(t *testing.T) {
+	store := newTestRedisStore(t)
+
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	id := store.AddReceipt(context.Background(), receipt)
+	assert.NotEmpty(t, id)
+	assert.NoError(t, store.Ping(context.Background()))
+
+	points, exists := store.GetPoints(context.Background(), id)
+	assert.True(t, exists)
+	assert.Equal(t, 109, points)
+
+	breakdown, exists := store.GetBreakdown(context.Background(), id)
+	assert.True(t, exists)
+	assert.Equal(t, 109, breakdownTotal(breakdown))
+
+	stored, exists := store.GetReceipt(context.Background(), id)
+	assert.True(t, exists)
+	assert.Equal(t, receipt, stored)
+
+	summaries, total := store.ListReceipts(context.Background(), ReceiptFilter{}, 10, 0)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []ReceiptSummary{newReceiptSummary(id, receipt, 109)}, summaries)
+
+	// Recomputing with unchanged rules keeps the same points
+	assert.Equal(t, 1, store.RecomputeAll(context.Background()))
+	points, _ = store.GetPoints(context.Background(), id)
+	assert.Equal(t, 109, points)
+
+	// Identical receipts are found by content
+	found, exists := store.FindReceipt(context.Background(), receipt)
+	assert.True(t, exists)
+	assert.Equal(t, id, found)
+	other := receipt
+	other.Retailer = "Target"
+	_, exists = store.FindReceipt(context.Background(), other)
+	assert.False(t, exists)
+
+	// Client ids are stored as given, and only once
+	assert.NoError(t, store.AddReceiptWithID(context.Background(), "order-1", other))
+	stored, exists = store.GetReceipt(context.Background(), "order-1")
+	assert.True(t, exists)
+	assert.Equal(t, other, stored)
+	assert.ErrorIs(t, store.AddReceiptWithID(context.Background(), "order-1", receipt), ErrReceiptExists)
+	assert.ErrorIs(t, store.AddReceiptWithID(context.Background(), id, other), ErrReceiptExists)
+	assert.True(t, store.DeleteReceipt(context.Background(), "order-1"))
+
+	// Unknown ids are not found
+	_, exists = store.GetPoints(context.Background(), "invalid-id")
+	assert.False(t, exists)
+	_, exists = store.GetReceipt(context.Background(), "invalid-id")
+	assert.False(t, exists)
+
+	// Deleted receipts are gone along with their points and breakdown
+	assert.True(t, store.DeleteReceipt(context.Background(), id))
+	assert.False(t, store.DeleteReceipt(context.Background(), id))
+	_, exists = store.GetPoints(context.Background(), id)
+	assert.False(t, exists)
+	_, exists = store.GetBreakdown(context.Background(), id)
+	assert.False(t, exists)
+	_, exists = store.GetReceipt(context.Background(), id)
+	assert.False(t, exists)
+	_, exists = store.FindReceipt(context.Background(), receipt)
+	assert.False(t, exists)
+}
+
+func TestRedisReceiptStoreIdempotency(t *testing.T) {
+	store := newTestRedisStore(t)
+
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	id, replayed, err := store.AddReceiptIdempotent(context.Background(), "key-1", receipt)
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+
+	again, replayed, err := store.AddReceiptIdempotent(context.Background(), "key-1", receipt)
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, id, again)
+
+	changed := receipt
+	changed.Total = "2.50"
+	_, _, err = store.AddReceiptIdempotent(context.Background(), "key-1", changed)
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+}
+
+func TestRedisReceiptStoreDedup(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+
+	for _, dedup := range []bool{false, true} {
+		store := newTestRedisStore(t)
+		store.dedup = dedup
+
+		first := store.AddReceipt(context.Background(), receipt)
+		second := store.AddReceipt(context.Background(), receipt)
+		assert.Equal(t, dedup, first == second, "dedup=%v", dedup)
+
+		assert.True(t, store.DeleteReceipt(context.Background(), first))
+		assert.NotEqual(t, first, store.AddReceipt(context.Background(), receipt), "dedup=%v", dedup)
+	}
+}
+
+func TestRedisReceiptStoreImport(t *testing.T) {
+	store := newTestRedisStore(t)
+	store.dedup = true
+
+	// Test case 1: Imported receipts keep their id and points
+	assert.NoError(t, store.ImportReceipt(context.Background(), "b-id", validReceipt(), 999, nil))
+	assert.NoError(t, store.ImportReceipt(context.Background(), "a-id", validReceipt(), 7, nil))
+	assert.Equal(t, []string{"a-id", "b-id"}, store.ReceiptIDs(context.Background()))
+	points, exists := store.GetPoints(context.Background(), "b-id")
+	assert.True(t, exists)
+	assert.Equal(t, 999, points)
+
+	// Test case 2: Replacing a receipt drops its old dedup entry
+	changed := validReceipt()
+	changed.PurchaseTime = "08:13"
+	assert.NoError(t, store.ImportReceipt(context.Background(), "a-id", changed, 8, nil))
+	assert.Equal(t, "a-id", store.AddReceipt(context.Background(), changed))
+}
+
+func TestRedisReceiptStoreClear(t *testing.T) {
+	store := newTestRedisStore(t)
+
+	store.AddReceipt(context.Background(), validReceipt())
+	_, _, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, store.Clear(context.Background()))
+	assert.Empty(t, store.ReceiptIDs(context.Background()))
+	_, found := store.FindReceipt(context.Background(), validReceipt())
+	assert.False(t, found)
+
+	// The idempotency key is forgotten along with its receipt
+	_, replayed, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 1, store.Clear(context.Background()))
+}
+
+func TestRedisReceiptStoreChangesSince(t *testing.T) {
+	store := newTestRedisStore(t)
+
+	store.AddReceipt(context.Background(), validReceipt())
+	time.Sleep(2 * time.Millisecond)
+	window := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	second := store.AddReceipt(context.Background(), validReceipt())
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, store.ImportReceipt(context.Background(), "imported", validReceipt(), 7, nil))
+
+	changes := store.ChangesSince(context.Background(), window)
+	require.Len(t, changes, 2)
+	assert.Equal(t, second, changes[0].ID)
+	assert.Equal(t, "imported", changes[1].ID)
+	assert.Equal(t, 7, changes[1].Points)
+
+	assert.True(t, store.DeleteReceipt(context.Background(), second))
+	assert.Len(t, store.ChangesSince(context.Background(), window), 1)
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// RuleSet holds the point values and parameters used by calculatePoints
+type RuleSet struct {
+	RetailerCharPoints         int     `json:"retailerCharPoints" yaml:"retailerCharPoints"`
+	RoundDollarPoints          int     `json:"roundDollarPoints" yaml:"roundDollarPoints"`
+	QuarterMultiplePoints      int     `json:"quarterMultiplePoints" yaml:"quarterMultiplePoints"`
+	ItemPairPoints             int     `json:"itemPairPoints" yaml:"itemPairPoints"`
+	DescriptionLengthMultiple  int     `json:"descriptionLengthMultiple" yaml:"descriptionLengthMultiple"`
+	DescriptionPriceMultiplier float64 `json:"descriptionPriceMultiplier" yaml:"descriptionPriceMultiplier"`
+	OddDayPoints               int     `json:"oddDayPoints" yaml:"oddDayPoints"`
+	EvenDayPoints              int     `json:"evenDayPoints" yaml:"evenDayPoints"`
+	TimeWindowPoints           int     `json:"timeWindowPoints" yaml:"timeWindowPoints"`
+	TimeWindowStart            string  `json:"timeWindowStart" yaml:"timeWindowStart"`
+	TimeWindowEnd              string  `json:"timeWindowEnd" yaml:"timeWindowEnd"`
+
+	// UnicodeAlphanumeric counts every Unicode letter and digit in the
+	// retailer name instead of only [a-zA-Z0-9]
+	UnicodeAlphanumeric bool `json:"unicodeAlphanumeric" yaml:"unicodeAlphanumeric"`
+
+	// WeekdayBonuses adjusts the points of receipts purchased on a weekday,
+	// keyed by lowercase day name such as "saturday"
+	WeekdayBonuses map[string]WeekdayBonus `json:"weekdayBonuses,omitempty" yaml:"weekdayBonuses,omitempty"`
+
+	// RetailerBonuses awards partner retailers extra points. Keys are
+	// retailer names or glob patterns such as "target*", matched without
+	// regard to case or extra whitespace.
+	RetailerBonuses map[string]int `json:"retailerBonuses,omitempty" yaml:"retailerBonuses,omitempty"`
+
+	// DescriptionKeywordBonuses awards extra points to every item whose
+	// trimmed description contains a keyword, ignoring case. An item earns
+	// the bonus of each keyword it contains.
+	DescriptionKeywordBonuses map[string]int `json:"descriptionKeywordBonuses,omitempty" yaml:"descriptionKeywordBonuses,omitempty"`
+
+	// NormalizeRetailers stores a trimmed, lowercased retailer name with
+	// single spaces alongside the original, which is still what is scored
+	NormalizeRetailers bool `json:"normalizeRetailers" yaml:"normalizeRetailers"`
+
+	// MaxPoints caps the points a single receipt can earn; zero is unlimited
+	MaxPoints int `json:"maxPoints" yaml:"maxPoints"`
+
+	// MinTotalForPoints is the smallest total, in dollars, that earns any
+	// points; receipts below it score zero whatever the other rules say
+	MinTotalForPoints float64 `json:"minTotalForPoints" yaml:"minTotalForPoints"`
+
+	// ScoringTimeZone is the IANA time zone the day and time rules are
+	// evaluated in. Purchase times are read in the receipt time zone and
+	// converted, so a purchase late on the 1st in one zone can score as the
+	// 2nd in another.
+	ScoringTimeZone string `json:"scoringTimeZone" yaml:"scoringTimeZone"`
+}
+
+// WeekdayBonus awards Points on its weekday, then multiplies the receipt's
+// total by Multiplier, rounding up. A zero Multiplier leaves the total as is.
+type WeekdayBonus struct {
+	Points     int     `json:"points" yaml:"points"`
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+}
+
+// DefaultRuleSet returns the rules described in the challenge README
+func DefaultRuleSet() RuleSet {
+	return RuleSet{
+		RetailerCharPoints:         1,
+		RoundDollarPoints:          50,
+		QuarterMultiplePoints:      25,
+		ItemPairPoints:             5,
+		DescriptionLengthMultiple:  3,
+		DescriptionPriceMultiplier: 0.2,
+		OddDayPoints:               6,
+		EvenDayPoints:              0,
+		TimeWindowPoints:           10,
+		TimeWindowStart:            "14:00",
+		TimeWindowEnd:              "16:00",
+		ScoringTimeZone:            "UTC",
+	}
+}
+
+// activeRules holds the RuleSet used to score newly processed receipts. It is
+// swapped atomically so the rules can be replaced while requests are served.
+var activeRules atomic.Value
+
+func init() {
+	activeRules.Store(DefaultRuleSet())
+}
+
+// currentRules returns the RuleSet new receipts are scored with
+func currentRules() RuleSet {
+	return activeRules.Load().(RuleSet)
+}
+
+// setActiveRules replaces the RuleSet used for receipts scored from now on.
+// Points that were already stored are unchanged until they are recomputed.
+func setActiveRules(rules RuleSet) {
+	activeRules.Store(rules)
+}
+
+// ruleSetRegistry holds the named rulesets that stored receipts can be
+// rescored with on request. "v1" is always the original challenge rules.
+// It is only written by registerRuleSet at startup, before requests are
+// served, so reads need no lock.
+var ruleSetRegistry = map[string]RuleSet{"v1": DefaultRuleSet()}
+
+// registerRuleSet makes rules available under name, replacing any ruleset
+// already registered with that name
+func registerRuleSet(name string, rules RuleSet) {
+	ruleSetRegistry[name] = rules
+}
+
+// lookupRuleSet returns the ruleset registered under name
+func lookupRuleSet(name string) (RuleSet, bool) {
+	rules, ok := ruleSetRegistry[name]
+	return rules, ok
+}
+
+// LoadRuleSet reads a RuleSet from a JSON file. Rules missing from the file
+// keep their default values.
+func LoadRuleSet(path string) (RuleSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DefaultRuleSet(), err
+	}
+	defer file.Close()
+
+	rules, err := decodeRuleSet(file)
+	if err != nil {
+		return rules, fmt.Errorf("invalid ruleset %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// decodeRuleSet reads and validates a JSON RuleSet, rejecting unknown rules.
+// Rules missing from the input keep their default values.
+func decodeRuleSet(r io.Reader) (RuleSet, error) {
+	rules := DefaultRuleSet()
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return rules, err
+	}
+
+	if err := rules.Validate(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// Validate reports whether the RuleSet can be used for scoring
+func (rules RuleSet) Validate() error {
+	if rules.DescriptionLengthMultiple <= 0 {
+		return errors.New("descriptionLengthMultiple must be positive")
+	}
+	if rules.DescriptionPriceMultiplier < 0 || rules.DescriptionPriceMultiplier > maxDescriptionPriceMultiplier {
+		return fmt.Errorf("descriptionPriceMultiplier must be between 0 and %d", maxDescriptionPriceMultiplier)
+	}
+
+	start, err := minutesSinceMidnight(rules.TimeWindowStart)
+	if err != nil {
+		return fmt.Errorf("invalid timeWindowStart: %w", err)
+	}
+	end, err := minutesSinceMidnight(rules.TimeWindowEnd)
+	if err != nil {
+		return fmt.Errorf("invalid timeWindowEnd: %w", err)
+	}
+	if start >= end {
+		return errors.New("timeWindowStart must be before timeWindowEnd")
+	}
+
+	for _, rule := range []struct {
+		name   string
+		points int
+	}{
+		{"retailerCharPoints", rules.RetailerCharPoints},
+		{"roundDollarPoints", rules.RoundDollarPoints},
+		{"quarterMultiplePoints", rules.QuarterMultiplePoints},
+		{"itemPairPoints", rules.ItemPairPoints},
+		{"oddDayPoints", rules.OddDayPoints},
+		{"evenDayPoints", rules.EvenDayPoints},
+		{"timeWindowPoints", rules.TimeWindowPoints},
+	} {
+		if rule.points > maxBonusPoints {
+			return fmt.Errorf("%s must be at most %d", rule.name, maxBonusPoints)
+		}
+	}
+
+	if rules.MaxPoints < 0 {
+		return errors.New("maxPoints must not be negative")
+	}
+	if rules.MinTotalForPoints < 0 || rules.MinTotalForPoints > maxAmountUnits {
+		return fmt.Errorf("minTotalForPoints must be between 0 and %d", maxAmountUnits)
+	}
+	if _, err := loadScoringLocation(rules.ScoringTimeZone); err != nil {
+		return fmt.Errorf("scoringTimeZone %q is not a known time zone", rules.ScoringTimeZone)
+	}
+
+	for pattern, bonus := range rules.RetailerBonuses {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("retailerBonuses has invalid pattern %q", pattern)
+		}
+		if bonus < 0 || bonus > maxBonusPoints {
+			return fmt.Errorf("retailerBonuses.%s must be between 0 and %d", pattern, maxBonusPoints)
+		}
+	}
+
+	for keyword, bonus := range rules.DescriptionKeywordBonuses {
+		if strings.TrimSpace(keyword) == "" {
+			return errors.New("descriptionKeywordBonuses has an empty keyword")
+		}
+		if bonus < 0 || bonus > maxBonusPoints {
+			return fmt.Errorf("descriptionKeywordBonuses.%s must be between 0 and %d", keyword, maxBonusPoints)
+		}
+	}
+
+	for day, bonus := range rules.WeekdayBonuses {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("weekdayBonuses has unknown weekday %q", day)
+		}
+		if bonus.Points < 0 || bonus.Points > maxBonusPoints {
+			return fmt.Errorf("weekdayBonuses.%s.points must be between 0 and %d", day, maxBonusPoints)
+		}
+		if bonus.Multiplier < 0 || bonus.Multiplier > maxWeekdayMultiplier {
+			return fmt.Errorf("weekdayBonuses.%s.multiplier must be between 0 and %d", day, maxWeekdayMultiplier)
+		}
+	}
+	return nil
+}
+
+// weekdays maps the keys of weekdayBonuses to their time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// scoringLocations caches loaded time zones by name, since every receipt
+// scored needs one and loading reads the zone database
+var scoringLocations sync.Map
+
+// loadScoringLocation returns the named time zone, where "" is UTC
+func loadScoringLocation(name string) (*time.Location, error) {
+	if location, ok := scoringLocations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	scoringLocations.Store(name, location)
+	return location, nil
+}
+
+// scoringTime returns when the receipt was purchased, as a time in the
+// ruleset's scoring time zone. Zones that fail to load fall back to UTC.
+func scoringTime(receipt Receipt, rules RuleSet) time.Time {
+	purchasedAt, _ := purchaseInstant(receipt)
+	location, err := loadScoringLocation(rules.ScoringTimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	return purchasedAt.In(location)
+}
+
+// minutesSinceMidnight converts an HH:MM time into minutes since midnight
+func minutesSinceMidnight(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// countAlphanumeric counts the ASCII letters and digits in s, matching
+// [a-zA-Z0-9] without the cost of a regular expression
+func countAlphanumeric(s string) int {
+	count := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			count++
+		}
+	}
+	return count
+}
+
+// countUnicodeAlphanumeric counts the Unicode letters and digits in s, so
+// "Café 北京" has six
+func countUnicodeAlphanumeric(s string) int {
+	count := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// retailerBonus returns the largest bonus whose pattern matches the
+// normalized retailer name, breaking ties by pattern so the result doesn't
+// depend on map order
+func retailerBonus(retailer string, bonuses map[string]int) (string, int, bool) {
+	name := normalizeRetailer(retailer)
+	best, bestBonus, found := "", 0, false
+	for pattern, bonus := range bonuses {
+		if matched, _ := path.Match(normalizeRetailer(pattern), name); !matched {
+			continue
+		}
+		if !found || bonus > bestBonus || (bonus == bestBonus && pattern < best) {
+			best, bestBonus, found = pattern, bonus, true
+		}
+	}
+	return best, bestBonus, found
+}
+
+// multiplierScale is the precision kept for descriptionPriceMultiplier when it
+// is applied in integer math, so 0.2 becomes 200/1000
+const multiplierScale = 1000
+
+// maxDescriptionPriceMultiplier keeps a price below maxAmountUnits, scaled by
+// the multiplier, within int64
+const maxDescriptionPriceMultiplier = 1000
+
+// maxWeekdayMultiplier bounds weekdayBonuses multipliers, so that scaling one
+// stays within int64 and so does the receipt's points multiplied by it
+const maxWeekdayMultiplier = 1000
+
+// maxBonusPoints bounds each flat bonus a ruleset can award, and the points
+// of each rule, so posted rulesets can't overflow a receipt's points
+const maxBonusPoints = 1_000_000
+
+// ceilDiv divides two non-negative integers, rounding up
+func ceilDiv(numerator, denominator int64) int64 {
+	return (numerator + denominator - 1) / denominator
+}
+
+// multiplyScaled multiplies non-negative points by scaled/multiplierScale,
+// rounding up. The whole multiples of multiplierScale are multiplied
+// separately so the product only overflows if the result would.
+func multiplyScaled(points, scaled int64) int64 {
+	return points/multiplierScale*scaled + ceilDiv(points%multiplierScale*scaled, multiplierScale)
+}
+
+// Points calculation logic. The receipt must already have been validated by
+// ProcessReceiptHandler, so parse errors are not expected here.
+func calculatePoints(receipt Receipt, rules RuleSet) int {
+	points, _ := calculatePointsDetailed(receipt, rules)
+	return points
+}
+
+// calculatePointsDetailed scores the receipt and also returns one breakdown
+// entry for every rule that awarded points.
+func calculatePointsDetailed(receipt Receipt, rules RuleSet) (int, []PointsBreakdown) {
+	points := 0
+	breakdown := []PointsBreakdown{}
+	award := func(rule string, rulePoints int, format string, args ...interface{}) {
+		if rulePoints == 0 {
+			return
+		}
+		points += rulePoints
+		breakdown = append(breakdown, PointsBreakdown{
+			Rule:        rule,
+			Description: fmt.Sprintf("%d points - %s", rulePoints, fmt.Sprintf(format, args...)),
+			Points:      rulePoints,
+		})
+	}
+
+	// Amounts are compared in the currency's minor units, such as cents, so
+	// there is no float error at the boundaries
+	digits := receipt.minorUnits()
+	unit := minorUnitsPerMajor(digits)
+	total, _ := receipt.minorAmount(receipt.Total)
+
+	// Receipts below the minimum spend earn nothing, so no other rule is
+	// applied
+	if minTotal := int64(math.Round(rules.MinTotalForPoints * float64(unit))); total < minTotal {
+		breakdown = append(breakdown, PointsBreakdown{
+			Rule: "min-total",
+			Description: fmt.Sprintf("0 points - total %s is below the minimum of %.*f for earning points",
+				receipt.Total, digits, rules.MinTotalForPoints),
+			Points: 0,
+		})
+		return 0, breakdown
+	}
+
+	// Rule 1: One point for every alphanumeric character in the retailer name
+	retailerAlphanumeric := countAlphanumeric(receipt.Retailer)
+	if rules.UnicodeAlphanumeric {
+		retailerAlphanumeric = countUnicodeAlphanumeric(receipt.Retailer)
+	}
+	award("retailer-name", retailerAlphanumeric*rules.RetailerCharPoints,
+		"retailer name has %d alphanumeric characters", retailerAlphanumeric)
+
+	// Rule 2: 50 points if the total is a round dollar amount with no cents.
+	// Totals in currencies without minor units are always round.
+	if total%unit == 0 {
+		award("round-dollar", rules.RoundDollarPoints, "total is a round dollar amount")
+	}
+
+	// Rule 3: 25 points if the total is a multiple of 0.25
+	if total*4%unit == 0 {
+		award("quarter-multiple", rules.QuarterMultiplePoints, "total is a multiple of 0.25")
+	}
+
+	// Rule 4: 5 points for every two items on the receipt
+	pairs := len(receipt.Items) / 2
+	award("item-pairs", pairs*rules.ItemPairPoints, "%d items (%d pairs @ %d points each)",
+		len(receipt.Items), pairs, rules.ItemPairPoints)
+
+	// Rule 5: If the trimmed length of the item description is a multiple of 3,
+	// multiply the price by 0.2 and round up to the nearest integer. The math is
+	// done in integer minor units so prices on exact multiples, like 15.00,
+	// don't pick up float error and round up an extra point.
+	multiplier := int64(math.Round(rules.DescriptionPriceMultiplier * multiplierScale))
+	for _, item := range receipt.Items {
+		trimmedDesc := strings.TrimSpace(item.ShortDescription)
+		// A whitespace-only description trims to length 0, which is technically
+		// a multiple of 3, but there is no description to reward, so it is skipped
+		if trimmedDesc != "" && len(trimmedDesc)%rules.DescriptionLengthMultiple == 0 {
+			price, _ := receipt.minorAmount(item.Price)
+			award("item-description", int(ceilDiv(price*multiplier, unit*multiplierScale)),
+				"%q is %d characters (a multiple of %d)", trimmedDesc, len(trimmedDesc), rules.DescriptionLengthMultiple)
+		}
+	}
+
+	// Keyword bonus: sorted so the breakdown doesn't depend on map order
+	keywords := sortedKeys(rules.DescriptionKeywordBonuses)
+	for _, item := range receipt.Items {
+		description := strings.ToLower(strings.TrimSpace(item.ShortDescription))
+		for _, keyword := range keywords {
+			if strings.Contains(description, strings.ToLower(keyword)) {
+				award("description-keyword", rules.DescriptionKeywordBonuses[keyword], "%q contains %q",
+					strings.TrimSpace(item.ShortDescription), keyword)
+			}
+		}
+	}
+
+	// Rule 6: 6 points if the day in the purchase date is odd. The day and
+	// time rules use the purchase time in the scoring time zone.
+	purchasedAt := scoringTime(receipt, rules)
+	if purchasedAt.Day()%2 == 1 {
+		award("odd-day", rules.OddDayPoints, "purchase day is odd")
+	} else {
+		award("even-day", rules.EvenDayPoints, "purchase day is even")
+	}
+
+	// Rule 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm.
+	// Both bounds are exclusive, so 14:00 and 16:00 do not qualify.
+	purchaseMinutes := purchasedAt.Hour()*60 + purchasedAt.Minute()
+	windowStart, _ := minutesSinceMidnight(rules.TimeWindowStart)
+	windowEnd, _ := minutesSinceMidnight(rules.TimeWindowEnd)
+	if windowStart < purchaseMinutes && purchaseMinutes < windowEnd {
+		award("time-window", rules.TimeWindowPoints, "%s is between %s and %s",
+			purchasedAt.Format("15:04"), rules.TimeWindowStart, rules.TimeWindowEnd)
+	}
+
+	// Partner bonus: when several patterns match, the largest bonus wins
+	if pattern, bonus, ok := retailerBonus(receipt.Retailer, rules.RetailerBonuses); ok {
+		award("retailer-bonus", bonus, "%q is a partner retailer (%s)", receipt.Retailer, pattern)
+	}
+
+	// Weekday bonus: a flat bonus, then a multiplier over every rule above. The
+	// multiplier is awarded as the points it adds (or removes).
+	weekday := strings.ToLower(purchasedAt.Weekday().String())
+	if bonus, ok := rules.WeekdayBonuses[weekday]; ok {
+		award("weekday-bonus", bonus.Points, "purchased on a %s", weekday)
+		if bonus.Multiplier != 0 {
+			scaled := int64(math.Round(bonus.Multiplier * multiplierScale))
+			multiplied := int(multiplyScaled(int64(points), scaled))
+			award("weekday-multiplier", multiplied-points, "purchased on a %s (x%g)", weekday, bonus.Multiplier)
+		}
+	}
+
+	// The cap applies last, after every bonus, and is awarded as the points
+	// it removes
+	if rules.MaxPoints > 0 && points > rules.MaxPoints {
+		award("max-points", rules.MaxPoints-points, "receipt is capped at %d points", rules.MaxPoints)
+	}
+
+	return points, breakdown
+}
+
+// breakdownTotal sums the points of every breakdown entry
+func breakdownTotal(breakdown []PointsBreakdown) int {
+	total := 0
+	for _, entry := range breakdown {
+		total += entry.Points
+	}
+	return total
+}
+
+// Version identifies the ruleset by a hash of its JSON encoding, so receipts
+// scored with identical rules share a version whenever they were scored
+func (rules RuleSet) Version() string {
+	encoded, _ := json.Marshal(rules)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Describe explains each rule in the ruleset as a sentence, in the order
+// calculatePoints applies them and worded like the challenge README. Rules
+// that can't award any points are left out.
+func (rules RuleSet) Describe() []string {
+	sentences := []string{}
+	add := func(format string, args ...interface{}) {
+		sentences = append(sentences, fmt.Sprintf(format, args...))
+	}
+
+	if rules.MinTotalForPoints > 0 {
+		add("Receipts with a total below %.2f earn no points.", rules.MinTotalForPoints)
+	}
+	if rules.RetailerCharPoints != 0 {
+		characters := "alphanumeric character"
+		if rules.UnicodeAlphanumeric {
+			characters = "Unicode letter or digit"
+		}
+		if rules.RetailerCharPoints == 1 {
+			add("One point for every %s in the retailer name.", characters)
+		} else {
+			add("%d points for every %s in the retailer name.", rules.RetailerCharPoints, characters)
+		}
+	}
+	if rules.RoundDollarPoints != 0 {
+		add("%d points if the total is a round dollar amount with no cents.", rules.RoundDollarPoints)
+	}
+	if rules.QuarterMultiplePoints != 0 {
+		add("%d points if the total is a multiple of 0.25.", rules.QuarterMultiplePoints)
+	}
+	if rules.ItemPairPoints != 0 {
+		add("%d points for every two items on the receipt.", rules.ItemPairPoints)
+	}
+	if rules.DescriptionPriceMultiplier != 0 {
+		add("If the trimmed length of the item description is a multiple of %d, multiply the price by %g and round up "+
+			"to the nearest integer. The result is the number of points earned.",
+			rules.DescriptionLengthMultiple, rules.DescriptionPriceMultiplier)
+	}
+	for _, keyword := range sortedKeys(rules.DescriptionKeywordBonuses) {
+		add("%d extra points for every item whose description contains %q.", rules.DescriptionKeywordBonuses[keyword], keyword)
+	}
+	if rules.OddDayPoints != 0 {
+		add("%d points if the day in the purchase date is odd.", rules.OddDayPoints)
+	}
+	if rules.EvenDayPoints != 0 {
+		add("%d points if the day in the purchase date is even.", rules.EvenDayPoints)
+	}
+	if rules.TimeWindowPoints != 0 {
+		add("%d points if the time of purchase is after %s and before %s.",
+			rules.TimeWindowPoints, clockTime(rules.TimeWindowStart), clockTime(rules.TimeWindowEnd))
+	}
+
+	for _, pattern := range sortedKeys(rules.RetailerBonuses) {
+		add("%d extra points if the retailer name matches %q.", rules.RetailerBonuses[pattern], pattern)
+	}
+
+	days := make([]string, 0, len(rules.WeekdayBonuses))
+	for day := range rules.WeekdayBonuses {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return weekdays[days[i]] < weekdays[days[j]] })
+	for _, day := range days {
+		bonus := rules.WeekdayBonuses[day]
+		switch {
+		case bonus.Multiplier != 0:
+			add("%d extra points if the receipt was purchased on a %s, after which its points are multiplied by %g.",
+				bonus.Points, day, bonus.Multiplier)
+		case bonus.Points != 0:
+			add("%d extra points if the receipt was purchased on a %s.", bonus.Points, day)
+		}
+	}
+
+	if rules.MaxPoints > 0 {
+		add("A receipt earns at most %d points.", rules.MaxPoints)
+	}
+	if rules.ScoringTimeZone != "" && rules.ScoringTimeZone != "UTC" {
+		add("Purchase days and times are evaluated in the %s time zone.", rules.ScoringTimeZone)
+	}
+	return sentences
+}
+
+// clockTime formats an HH:MM time the way the README does, such as 2:00pm
+func clockTime(value string) string {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return value
+	}
+	return parsed.Format("3:04pm")
+}
+
+// sortedKeys lists the keys of a bonus map in order
+func sortedKeys(bonuses map[string]int) []string {
+	keys := make([]string, 0, len(bonuses))
+	for key := range bonuses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRuleSet(t *testing.T) {
+	dir := t.TempDir()
+
+	// Test case 1: Missing rules keep their defaults
+	path := filepath.Join(dir, "rules.json")
+	err := os.WriteFile(path, []byte(`{"oddDayPoints": 0, "evenDayPoints": 12}`), 0600)
+	assert.NoError(t, err)
+
+	rules, err := LoadRuleSet(path)
+	assert.NoError(t, err)
+
+	expected := DefaultRuleSet()
+	expected.OddDayPoints = 0
+	expected.EvenDayPoints = 12
+	assert.Equal(t, expected, rules)
+
+	// Test case 2: Unknown rules are rejected
+	err = os.WriteFile(path, []byte(`{"oddDayPoint": 0}`), 0600)
+	assert.NoError(t, err)
+
+	_, err = LoadRuleSet(path)
+	assert.Error(t, err)
+
+	// Test case 3: Invalid rule values are rejected
+	err = os.WriteFile(path, []byte(`{"timeWindowStart": "16:00", "timeWindowEnd": "14:00"}`), 0600)
+	assert.NoError(t, err)
+
+	_, err = LoadRuleSet(path)
+	assert.Error(t, err)
+
+	// Test case 4: Missing file
+	_, err = LoadRuleSet(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestCalculatePointsCustomRules(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	// A bonus for purchases on even days
+	rules := DefaultRuleSet()
+	rules.EvenDayPoints = 20
+	assert.Equal(t, 129, calculatePoints(receipt, rules))
+
+	// Move the time window so 2:33pm no longer qualifies
+	rules = DefaultRuleSet()
+	rules.TimeWindowStart = "15:00"
+	assert.Equal(t, 99, calculatePoints(receipt, rules))
+
+	// Two points per retailer character and ten points per item pair
+	rules = DefaultRuleSet()
+	rules.RetailerCharPoints = 2
+	rules.ItemPairPoints = 10
+	assert.Equal(t, 133, calculatePoints(receipt, rules))
+}
+
+func TestCalculatePointsTimeWindow(t *testing.T) {
+	tests := []struct {
+		purchaseTime string
+		points       int
+	}{
+		{"13:59", 0},
+		{"14:00", 0},
+		{"14:01", 10},
+		{"14:33", 10},
+		{"15:59", 10},
+		{"16:00", 0},
+		{"16:01", 0},
+	}
+
+	for _, tt := range tests {
+		// A receipt that earns nothing from the other rules
+		receipt := Receipt{
+			Retailer:     "&",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: tt.purchaseTime,
+			Items:        []Item{{ShortDescription: "Mints", Price: "0.01"}},
+			Total:        "0.01",
+		}
+
+		assert.Equal(t, tt.points, calculatePoints(receipt, DefaultRuleSet()), tt.purchaseTime)
+	}
+}
+
+func TestCalculatePointsItemPairs(t *testing.T) {
+	tests := []struct {
+		items  int
+		points int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 5},
+		{3, 5},
+		{4, 10},
+		{5, 10},
+	}
+
+	for _, tt := range tests {
+		// Receipts that differ only in item count and earn nothing from the other rules
+		receipt := Receipt{
+			Retailer:     "&",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "10:00",
+			Items:        []Item{},
+			Total:        "0.01",
+		}
+		for i := 0; i < tt.items; i++ {
+			receipt.Items = append(receipt.Items, Item{ShortDescription: "Mints", Price: "0.01"})
+		}
+
+		assert.Equal(t, tt.points, calculatePoints(receipt, DefaultRuleSet()), "%d items", tt.items)
+	}
+}
+
+func TestCalculatePointsDescriptionPrice(t *testing.T) {
+	tests := []struct {
+		price  string
+		points int
+	}{
+		{"0.01", 1},
+		{"1.26", 1},
+		{"2.50", 1},
+		{"5.00", 1},
+		{"5.01", 2},
+		{"12.25", 3},
+		{"15.00", 3},                // 15.00 * 0.2 is 3.0000000000000004 in floating point
+		{"999999999.99", 200000000}, // the largest price validation accepts doesn't overflow
+	}
+
+	for _, tt := range tests {
+		// A three character description on a receipt that earns nothing else
+		receipt := Receipt{
+			Retailer:     "&",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "10:00",
+			Items:        []Item{{ShortDescription: "Gum", Price: tt.price}},
+			Total:        "0.01",
+		}
+
+		assert.Equal(t, tt.points, calculatePoints(receipt, DefaultRuleSet()), tt.price)
+	}
+}
+
+func TestCalculatePointsCurrency(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	// The same receipt in euros, written with decimal commas, scores the same
+	euros := receipt
+	euros.Currency = "EUR"
+	euros.Total = "9,00"
+	euros.Items = []Item{
+		{ShortDescription: "Gatorade", Price: "2,25"},
+		{ShortDescription: "Gatorade", Price: "2,25"},
+		{ShortDescription: "Gatorade", Price: "2,25"},
+		{ShortDescription: "Gatorade", Price: "2,25"},
+	}
+
+	assert.NoError(t, validateReceipt(euros))
+	assert.Equal(t, 109, calculatePoints(receipt, DefaultRuleSet()))
+	assert.Equal(t, 109, calculatePoints(euros, DefaultRuleSet()))
+
+	// The same receipt in dinars, with three decimal places
+	dinars := receipt
+	dinars.Currency = "BHD"
+	dinars.Total = "9.000"
+	dinars.Items = []Item{
+		{ShortDescription: "Gatorade", Price: "2.250"},
+		{ShortDescription: "Gatorade", Price: "2.250"},
+		{ShortDescription: "Gatorade", Price: "2.250"},
+		{ShortDescription: "Gatorade", Price: "2.250"},
+	}
+	assert.NoError(t, validateReceipt(dinars))
+	assert.Equal(t, 109, calculatePoints(dinars, DefaultRuleSet()))
+
+	// A fraction of a dinar that isn't a quarter only earns the other rules
+	dinars.Total = "9.010"
+	dinars.Items[0].Price = "2.260"
+	assert.NoError(t, validateReceipt(dinars))
+	assert.Equal(t, 109-50-25, calculatePoints(dinars, DefaultRuleSet()))
+}
+
+func TestCalculatePointsYen(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Lawson",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "10:00",
+		Currency:     "JPY",
+		Items: []Item{
+			{ShortDescription: "Onigiri", Price: "150"},
+			{ShortDescription: "Green tea", Price: "143"},
+		},
+		Total: "293",
+	}
+	assert.NoError(t, validateReceipt(receipt))
+
+	// Yen have no minor units, so every valid total is round and a multiple
+	// of 0.25. "Green tea" is 9 characters: 143 * 0.2 = 28.6, rounded up.
+	points, breakdown := calculatePointsDetailed(receipt, DefaultRuleSet())
+	assert.Equal(t, 6+50+25+5+29, points)
+	rules := []string{}
+	for _, entry := range breakdown {
+		rules = append(rules, entry.Rule)
+	}
+	assert.Equal(t, []string{"retailer-name", "round-dollar", "quarter-multiple", "item-pairs", "item-description"}, rules)
+
+	// Decimal yen are rejected
+	receipt.Total = "293.00"
+	assert.ErrorIs(t, validateReceipt(receipt), ErrBadTotal)
+
+	// The minimum total is in yen too
+	receipt.Total = "293"
+	ruleSet := DefaultRuleSet()
+	ruleSet.MinTotalForPoints = 300
+	points, breakdown = calculatePointsDetailed(receipt, ruleSet)
+	assert.Equal(t, 0, points)
+	assert.Equal(t, "0 points - total 293 is below the minimum of 300 for earning points", breakdown[0].Description)
+}
+
+func TestCalculatePointsBlankDescription(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "&",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "10:00",
+		Items:        []Item{{ShortDescription: "   ", Price: "10.00"}},
+		Total:        "0.01",
+	}
+
+	points, breakdown := calculatePointsDetailed(receipt, DefaultRuleSet())
+	assert.Equal(t, 0, points)
+	assert.Empty(t, breakdown)
+}
+
+func TestCountAlphanumeric(t *testing.T) {
+	// The loop must agree with the [a-zA-Z0-9] pattern it replaced
+	alphanumericRegex := regexp.MustCompile(`[a-zA-Z0-9]`)
+	for _, retailer := range []string{"", "Target", "M&M Corner Market", "  - & -", "7-Eleven 24", "Café 北京", "Z9_az"} {
+		assert.Equal(t, len(alphanumericRegex.FindAllString(retailer, -1)), countAlphanumeric(retailer), retailer)
+	}
+}
+
+func BenchmarkCalculatePoints(b *testing.B) {
+	receipt := validReceipt()
+	rules := DefaultRuleSet()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		calculatePoints(receipt, rules)
+	}
+}
+
+func TestUnicodeAlphanumeric(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Café 北京",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items: []Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+		Total: "1.25",
+	}
+	retailerPoints := func(rules RuleSet) int {
+		_, breakdown := calculatePointsDetailed(receipt, rules)
+		for _, entry := range breakdown {
+			if entry.Rule == "retailer-name" {
+				return entry.Points
+			}
+		}
+		return 0
+	}
+
+	// Test case 1: By default only the ASCII letters count
+	rules := DefaultRuleSet()
+	assert.Equal(t, 3, retailerPoints(rules))
+
+	// Test case 2: The flag counts accented and CJK letters too
+	rules.UnicodeAlphanumeric = true
+	assert.Equal(t, 6, retailerPoints(rules))
+
+	// Test case 3: ASCII-only names score the same in both modes
+	receipt.Retailer = "M&M Corner Market"
+	assert.Equal(t, retailerPoints(DefaultRuleSet()), retailerPoints(rules))
+}
+
+func TestWeekdayBonuses(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-19", // A Saturday
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "4.50",
+	}
+	base := calculatePoints(receipt, DefaultRuleSet())
+
+	// Test case 1: A flat Saturday bonus
+	rules := DefaultRuleSet()
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Points: 10}}
+	assert.Equal(t, base+10, calculatePoints(receipt, rules))
+
+	// Test case 2: Double points on weekends
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Multiplier: 2}, "sunday": {Multiplier: 2}}
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 2*base, points)
+	assert.Equal(t, PointsBreakdown{
+		Rule:        "weekday-multiplier",
+		Description: fmt.Sprintf("%d points - purchased on a saturday (x2)", base),
+		Points:      base,
+	}, breakdown[len(breakdown)-1])
+
+	// Test case 3: The multiplier also applies to the flat bonus, rounding up
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Points: 1, Multiplier: 1.5}}
+	assert.Equal(t, (3*(base+1)+1)/2, calculatePoints(receipt, rules))
+
+	// Test case 4: Other days are unaffected
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"monday": {Points: 10, Multiplier: 3}}
+	assert.Equal(t, base, calculatePoints(receipt, rules))
+
+	// Test case 5: Unknown days and out of range bonuses are rejected
+	for _, bonuses := range []map[string]WeekdayBonus{
+		{"Saturday": {Points: 10}},
+		{"saturday": {Multiplier: -1}},
+		{"saturday": {Multiplier: maxWeekdayMultiplier + 1}},
+		{"saturday": {Multiplier: 1e300}},
+		{"saturday": {Points: -1}},
+		{"saturday": {Points: maxBonusPoints + 1}},
+	} {
+		rules.WeekdayBonuses = bonuses
+		assert.Error(t, rules.Validate(), bonuses)
+	}
+
+	// Test case 6: The largest multiplier doesn't overflow on a large score
+	large := receipt
+	large.Items = nil
+	for i := 0; i < 1000; i++ {
+		large.Items = append(large.Items, Item{ShortDescription: "Pepsi Max", Price: "999999999.00"})
+	}
+	rules.DescriptionPriceMultiplier = maxDescriptionPriceMultiplier
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Points: maxBonusPoints}}
+	unmultiplied := calculatePoints(large, rules)
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Points: maxBonusPoints, Multiplier: maxWeekdayMultiplier}}
+	assert.NoError(t, rules.Validate())
+	assert.Equal(t, unmultiplied*maxWeekdayMultiplier, calculatePoints(large, rules))
+}
+
+func TestMaxPoints(t *testing.T) {
+	// Scores 109 points under the default rules
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	// Test case 1: No cap by default
+	rules := DefaultRuleSet()
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 109, points)
+	assert.NotEqual(t, "max-points", breakdown[len(breakdown)-1].Rule)
+
+	// Test case 2: The cap clamps the total and is noted in the breakdown
+	rules.MaxPoints = 100
+	points, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 100, points)
+	assert.Equal(t, PointsBreakdown{
+		Rule:        "max-points",
+		Description: "-9 points - receipt is capped at 100 points",
+		Points:      -9,
+	}, breakdown[len(breakdown)-1])
+
+	// Test case 3: Receipts under the cap are unaffected
+	rules.MaxPoints = 200
+	points, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 109, points)
+	assert.NotEqual(t, "max-points", breakdown[len(breakdown)-1].Rule)
+
+	// Test case 4: The cap also applies after weekday bonuses
+	rules.MaxPoints = 150
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"sunday": {Multiplier: 2}}
+	assert.Equal(t, 150, calculatePoints(receipt, rules))
+
+	// Test case 5: A negative cap is rejected
+	rules.MaxPoints = -1
+	assert.Error(t, rules.Validate())
+}
+
+func TestMinTotalForPoints(t *testing.T) {
+	// Scores 109 points under the default rules
+	receipt := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+
+	// Test case 1: No minimum by default
+	rules := DefaultRuleSet()
+	assert.Equal(t, 109, calculatePoints(receipt, rules))
+
+	// Test case 2: A total below the minimum scores zero
+	rules.MinTotalForPoints = 9.01
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 0, points)
+	assert.Equal(t, []PointsBreakdown{{
+		Rule:        "min-total",
+		Description: "0 points - total 9.00 is below the minimum of 9.01 for earning points",
+		Points:      0,
+	}}, breakdown)
+
+	// Test case 3: A total at the minimum scores normally
+	rules.MinTotalForPoints = 9
+	points, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, 109, points)
+	assert.NotEqual(t, "min-total", breakdown[0].Rule)
+
+	// Test case 4: A total above the minimum scores normally
+	rules.MinTotalForPoints = 5
+	assert.Equal(t, 109, calculatePoints(receipt, rules))
+
+	// Test case 5: A negative minimum is rejected
+	rules.MinTotalForPoints = -1
+	assert.Error(t, rules.Validate())
+}
+
+func TestScoringTimeZone(t *testing.T) {
+	defer func(location *time.Location) { receiptLocation = location }(receiptLocation)
+
+	// Late on an odd day in UTC, which is already the next morning in Tokyo
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "23:30",
+		Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		Total:        "1.25",
+	}
+	rules := DefaultRuleSet()
+	utcPoints := calculatePoints(receipt, rules)
+
+	// Test case 1: UTC is the default and counts the odd day
+	_, breakdown := calculatePointsDetailed(receipt, rules)
+	assert.Contains(t, breakdown, PointsBreakdown{Rule: "odd-day", Description: "6 points - purchase day is odd", Points: 6})
+
+	// Test case 2: In Tokyo the purchase falls on the 2nd, an even day
+	rules.ScoringTimeZone = "Asia/Tokyo"
+	assert.Equal(t, utcPoints-rules.OddDayPoints, calculatePoints(receipt, rules))
+
+	// Test case 3: In New York it is still the evening of the 1st
+	rules.ScoringTimeZone = "America/New_York"
+	assert.Equal(t, utcPoints, calculatePoints(receipt, rules))
+
+	// Test case 4: The time window is checked in the scoring zone too, as is
+	// the weekday, so 19:30 in UTC is 14:30 in New York
+	receipt.PurchaseTime = "19:30"
+	_, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.Contains(t, breakdown, PointsBreakdown{Rule: "time-window", Description: "10 points - 14:30 is between 14:00 and 16:00", Points: 10})
+
+	// Test case 5: Receipt times are read in the receipt time zone first, so
+	// the morning of the 2nd in Tokyo is still the 1st in UTC
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	receiptLocation = tokyo
+	receipt.PurchaseDate = "2022-01-02"
+	receipt.PurchaseTime = "08:30"
+	rules.ScoringTimeZone = "UTC"
+	_, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.Contains(t, breakdown, PointsBreakdown{Rule: "odd-day", Description: "6 points - purchase day is odd", Points: 6})
+
+	// Test case 6: A purchaseDateTime is scored at the instant it names, so
+	// 23:30 on the 1st at -08:00 is 07:30 on the 2nd in UTC
+	receiptLocation = time.UTC
+	receipt.PurchaseDate = ""
+	receipt.PurchaseTime = ""
+	receipt.PurchaseDateTime = "2022-01-01T23:30:00-08:00"
+	assert.NoError(t, prepareReceipt(&receipt))
+	assert.Equal(t, "2022-01-01", receipt.PurchaseDate)
+	assert.Equal(t, "23:30", receipt.PurchaseTime)
+	assert.Equal(t, time.Date(2022, 1, 2, 7, 30, 0, 0, time.UTC), scoringTime(receipt, rules))
+	_, breakdown = calculatePointsDetailed(receipt, rules)
+	assert.NotContains(t, breakdown, PointsBreakdown{Rule: "odd-day", Description: "6 points - purchase day is odd", Points: 6})
+	split := receipt
+	split.PurchaseDateTime = ""
+	assert.Equal(t, calculatePoints(receipt, rules)+rules.OddDayPoints, calculatePoints(split, rules))
+
+	// Test case 7: Unknown zones are rejected
+	rules.ScoringTimeZone = "Mars/Olympus_Mons"
+	assert.Error(t, rules.Validate())
+}
+
+func TestRetailerBonuses(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		},
+		Total: "18.74",
+	}
+	base := calculatePoints(receipt, DefaultRuleSet())
+	rules := DefaultRuleSet()
+	rules.RetailerBonuses = map[string]int{"Target": 20, "walgreens*": 15}
+
+	// Test case 1: An exact match, whatever the casing and spacing
+	for _, retailer := range []string{"Target", "  TARGET "} {
+		receipt.Retailer = retailer
+		points, breakdown := calculatePointsDetailed(receipt, rules)
+		assert.Equal(t, calculatePoints(receipt, DefaultRuleSet())+20, points)
+		assert.Equal(t, "retailer-bonus", breakdown[len(breakdown)-1].Rule)
+	}
+
+	// Test case 2: A glob pattern
+	receipt.Retailer = "Walgreens Pharmacy"
+	assert.Equal(t, calculatePoints(receipt, DefaultRuleSet())+15, calculatePoints(receipt, rules))
+
+	// Test case 3: Retailers that don't match get nothing extra
+	receipt.Retailer = "Targets"
+	assert.Equal(t, calculatePoints(receipt, DefaultRuleSet()), calculatePoints(receipt, rules))
+
+	// Test case 4: The largest of several matching bonuses wins
+	receipt.Retailer = "Target"
+	rules.RetailerBonuses = map[string]int{"target": 20, "t*": 30}
+	assert.Equal(t, base+30, calculatePoints(receipt, rules))
+
+	// Test case 5: Malformed patterns and negative bonuses are rejected
+	rules.RetailerBonuses = map[string]int{"[target": 20}
+	assert.Error(t, rules.Validate())
+	rules.RetailerBonuses = map[string]int{"target": -5}
+	assert.Error(t, rules.Validate())
+}
+
+func TestDescriptionKeywordBonuses(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "  Organic Bananas ", Price: "1.25"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "ORGANIC whole milk", Price: "3.50"},
+		},
+		Total: "17.00",
+	}
+	base := calculatePoints(receipt, DefaultRuleSet())
+	rules := DefaultRuleSet()
+	rules.DescriptionKeywordBonuses = map[string]int{"organic": 2}
+
+	// Test case 1: Every matching item earns the bonus, whatever the casing
+	points, breakdown := calculatePointsDetailed(receipt, rules)
+	assert.Equal(t, base+4, points)
+	keywordEntries := []PointsBreakdown{}
+	for _, entry := range breakdown {
+		if entry.Rule == "description-keyword" {
+			keywordEntries = append(keywordEntries, entry)
+		}
+	}
+	assert.Equal(t, []PointsBreakdown{
+		{Rule: "description-keyword", Description: `2 points - "Organic Bananas" contains "organic"`, Points: 2},
+		{Rule: "description-keyword", Description: `2 points - "ORGANIC whole milk" contains "organic"`, Points: 2},
+	}, keywordEntries)
+
+	// Test case 2: Items without a keyword get nothing extra
+	rules.DescriptionKeywordBonuses = map[string]int{"gluten-free": 5, "Pizzas": 3}
+	assert.Equal(t, base, calculatePoints(receipt, rules))
+
+	// Test case 3: An item earns the bonus of each keyword it contains
+	rules.DescriptionKeywordBonuses = map[string]int{"cheese": 3, "PIZZA": 4, "milk": 1}
+	assert.Equal(t, base+8, calculatePoints(receipt, rules))
+
+	// Test case 4: The default ruleset has no keywords
+	assert.Empty(t, DefaultRuleSet().DescriptionKeywordBonuses)
+
+	// Test case 5: Blank keywords and negative bonuses are rejected
+	rules.DescriptionKeywordBonuses = map[string]int{" ": 2}
+	assert.Error(t, rules.Validate())
+	rules.DescriptionKeywordBonuses = map[string]int{"organic": -2}
+	assert.Error(t, rules.Validate())
+}
+
+func TestRuleSetDescribe(t *testing.T) {
+	// Test case 1: The default rules read like the challenge README
+	assert.Equal(t, []string{
+		"One point for every alphanumeric character in the retailer name.",
+		"50 points if the total is a round dollar amount with no cents.",
+		"25 points if the total is a multiple of 0.25.",
+		"5 points for every two items on the receipt.",
+		"If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up " +
+			"to the nearest integer. The result is the number of points earned.",
+		"6 points if the day in the purchase date is odd.",
+		"10 points if the time of purchase is after 2:00pm and before 4:00pm.",
+	}, DefaultRuleSet().Describe())
+
+	// Test case 2: Every point value follows the ruleset, and optional rules
+	// are described once they are configured
+	rules := DefaultRuleSet()
+	rules.RetailerCharPoints = 2
+	rules.RoundDollarPoints = 51
+	rules.QuarterMultiplePoints = 26
+	rules.ItemPairPoints = 7
+	rules.DescriptionLengthMultiple = 4
+	rules.DescriptionPriceMultiplier = 0.5
+	rules.OddDayPoints = 0
+	rules.EvenDayPoints = 8
+	rules.TimeWindowPoints = 11
+	rules.TimeWindowStart = "09:30"
+	rules.TimeWindowEnd = "17:00"
+	rules.RetailerBonuses = map[string]int{"walgreens": 15, "target*": 12}
+	rules.DescriptionKeywordBonuses = map[string]int{"organic": 2}
+	rules.WeekdayBonuses = map[string]WeekdayBonus{"saturday": {Points: 4, Multiplier: 2}, "monday": {Points: 3}}
+	rules.MaxPoints = 500
+	rules.MinTotalForPoints = 5
+	rules.ScoringTimeZone = "America/New_York"
+
+	description := strings.Join(rules.Describe(), "\n")
+	for _, expected := range []string{
+		"Receipts with a total below 5.00 earn no points.",
+		"2 points for every alphanumeric character in the retailer name.",
+		"51 points if the total is a round dollar amount with no cents.",
+		"26 points if the total is a multiple of 0.25.",
+		"7 points for every two items on the receipt.",
+		"a multiple of 4, multiply the price by 0.5",
+		"8 points if the day in the purchase date is even.",
+		"11 points if the time of purchase is after 9:30am and before 5:00pm.",
+		`12 extra points if the retailer name matches "target*".`,
+		`15 extra points if the retailer name matches "walgreens".`,
+		`2 extra points for every item whose description contains "organic".`,
+		"3 extra points if the receipt was purchased on a monday.",
+		"4 extra points if the receipt was purchased on a saturday, after which its points are multiplied by 2.",
+		"A receipt earns at most 500 points.",
+		"Purchase days and times are evaluated in the America/New_York time zone.",
+	} {
+		assert.Contains(t, description, expected)
+	}
+	assert.NotContains(t, description, "is odd")
+	assert.Less(t, strings.Index(description, "monday"), strings.Index(description, "saturday"))
+}
+
+func TestRuleSetVersion(t *testing.T) {
+	// Test case 1: Identical rules share a version
+	assert.Equal(t, DefaultRuleSet().Version(), DefaultRuleSet().Version())
+
+	// Test case 2: Changing any rule changes it
+	rules := DefaultRuleSet()
+	rules.OddDayPoints = 7
+	assert.NotEqual(t, DefaultRuleSet().Version(), rules.Version())
+}
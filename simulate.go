@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxSimulationSteps bounds how many variations one /simulate request scores
+const maxSimulationSteps = 1000
+
+// SimulateRequest sweeps one field of a receipt from From to To in Steps,
+// scoring the receipt with each value in turn
+type SimulateRequest struct {
+	Receipt   Receipt `json:"receipt"`
+	Parameter string  `json:"parameter"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Step      string  `json:"step"`
+}
+
+// SimulationResult is the points for one value of the swept field
+type SimulationResult struct {
+	Value  string `json:"value"`
+	Points int    `json:"points"`
+}
+
+type SimulateResponse struct {
+	Parameter string             `json:"parameter"`
+	Results   []SimulationResult `json:"results"`
+}
+
+// SimulateHandler scores variations of a receipt without storing anything.
+// The base receipt must be valid; the variations are scored as they are, so a
+// swept total need not match the items.
+func (s *Server) SimulateHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+	var request SimulateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Invalid simulation format")
+		return
+	}
+
+	if err := prepareReceipt(&request.Receipt); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	values, sweepErr := sweepValues(request)
+	if sweepErr != nil {
+		writeJSONError(w, sweepErr.status, sweepErr.message)
+		return
+	}
+
+	// Every variation is scored with the same rules, even if they are
+	// replaced part way through
+	rules := currentRules()
+	response := SimulateResponse{Parameter: request.Parameter, Results: make([]SimulationResult, 0, len(values))}
+	for _, value := range values {
+		receipt := request.Receipt
+		switch request.Parameter {
+		case "total":
+			receipt.Total = value
+		case "purchaseTime":
+			receipt.PurchaseTime = value
+		}
+		response.Results = append(response.Results, SimulationResult{Value: value, Points: calculatePoints(receipt, rules)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sweepValues lists the values the swept field takes, in the field's own
+// format. Totals step by an amount such as "1.00"; purchase times step by a
+// duration such as "15m".
+func sweepValues(request SimulateRequest) ([]string, *requestError) {
+	var from, to, step int64
+	var format func(int64) string
+
+	switch request.Parameter {
+	case "total":
+		digits := request.Receipt.minorUnits()
+		var err *requestError
+		if from, err = simulationAmount("from", request.From, digits); err != nil {
+			return nil, err
+		}
+		if to, err = simulationAmount("to", request.To, digits); err != nil {
+			return nil, err
+		}
+		if step, err = simulationAmount("step", request.Step, digits); err != nil {
+			return nil, err
+		}
+		format = func(amount int64) string { return formatMinorUnits(amount, digits) }
+	case "purchaseTime":
+		start, err := minutesSinceMidnight(request.From)
+		if err != nil || !timePattern.MatchString(request.From) {
+			return nil, &requestError{http.StatusBadRequest, "Invalid from. Expected HH:MM"}
+		}
+		end, err := minutesSinceMidnight(request.To)
+		if err != nil || !timePattern.MatchString(request.To) {
+			return nil, &requestError{http.StatusBadRequest, "Invalid to. Expected HH:MM"}
+		}
+		duration, err := time.ParseDuration(request.Step)
+		if err != nil || duration%time.Minute != 0 {
+			return nil, &requestError{http.StatusBadRequest, "Invalid step. Expected a whole number of minutes such as 15m"}
+		}
+		from, to, step = int64(start), int64(end), int64(duration/time.Minute)
+		format = func(minutes int64) string { return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60) }
+	default:
+		return nil, &requestError{http.StatusBadRequest, "Invalid parameter. Expected total or purchaseTime"}
+	}
+
+	if step <= 0 {
+		return nil, &requestError{http.StatusBadRequest, "Step must be positive"}
+	}
+	if from > to {
+		return nil, &requestError{http.StatusBadRequest, "From must not be after to"}
+	}
+	if steps := (to-from)/step + 1; steps > maxSimulationSteps {
+		return nil, &requestError{http.StatusBadRequest,
+			fmt.Sprintf("Simulation would take %d steps; at most %d are allowed", steps, maxSimulationSteps)}
+	}
+
+	values := []string{}
+	for value := from; value <= to; value += step {
+		values = append(values, format(value))
+	}
+	return values, nil
+}
+
+// simulationAmount parses an amount such as "1.00", with the receipt
+// currency's decimal places, into minor units
+func simulationAmount(name, value string, digits int) (int64, *requestError) {
+	amount, err := parseMinorUnits(value, digits)
+	if err != nil {
+		return 0, &requestError{http.StatusBadRequest, fmt.Sprintf("Invalid %s. Expected an amount such as %s",
+			name, formatMinorUnits(minorUnitsPerMajor(digits), digits))}
+	}
+	return amount, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	handler := http.HandlerFunc(server.SimulateHandler)
+
+	simulate := func(request SimulateRequest) *httptest.ResponseRecorder {
+		reqBody, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/simulate", bytes.NewBuffer(reqBody))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test case 1: Sweeping the total picks up the round-dollar and quarter rules
+	receipt := validReceipt()
+	rr := simulate(SimulateRequest{Receipt: receipt, Parameter: "total", From: "1.00", To: "1.50", Step: "0.25"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response SimulateResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "total", response.Parameter)
+
+	expected := []SimulationResult{}
+	for _, total := range []string{"1.00", "1.25", "1.50"} {
+		variation := receipt
+		variation.Total = total
+		expected = append(expected, SimulationResult{Value: total, Points: calculatePoints(variation, currentRules())})
+	}
+	assert.Equal(t, expected, response.Results)
+	assert.Equal(t, response.Results[1].Points+50, response.Results[0].Points)
+
+	// Test case 2: Sweeping the purchase time across the afternoon window
+	rr = simulate(SimulateRequest{Receipt: receipt, Parameter: "purchaseTime", From: "13:30", To: "16:30", Step: "1h"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	values := []string{}
+	for _, result := range response.Results {
+		values = append(values, result.Value)
+	}
+	assert.Equal(t, []string{"13:30", "14:30", "15:30", "16:30"}, values)
+	assert.Equal(t, response.Results[0].Points+10, response.Results[1].Points)
+	assert.Equal(t, response.Results[1].Points, response.Results[2].Points)
+	assert.Equal(t, response.Results[0].Points, response.Results[3].Points)
+
+	// Test case 3: Invalid sweeps are rejected
+	for _, tt := range []struct {
+		request SimulateRequest
+		message string
+	}{
+		{SimulateRequest{Receipt: receipt, Parameter: "retailer", From: "a", To: "b", Step: "1"}, "Invalid parameter. Expected total or purchaseTime"},
+		{SimulateRequest{Receipt: receipt, Parameter: "total", From: "1", To: "2.00", Step: "1.00"}, "Invalid from. Expected an amount such as 1.00"},
+		{SimulateRequest{Receipt: receipt, Parameter: "total", From: "1.00", To: "2.00", Step: "0.00"}, "Step must be positive"},
+		{SimulateRequest{Receipt: receipt, Parameter: "total", From: "2.00", To: "1.00", Step: "1.00"}, "From must not be after to"},
+		{SimulateRequest{Receipt: receipt, Parameter: "purchaseTime", From: "13:00", To: "14:00", Step: "30s"}, "Invalid step. Expected a whole number of minutes such as 15m"},
+		{SimulateRequest{Receipt: receipt, Parameter: "total", From: "0.01", To: "100.00", Step: "0.01"}, "Simulation would take 10000 steps; at most 1000 are allowed"},
+	} {
+		rr = simulate(tt.request)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, tt.message, decodeError(t, rr).Error)
+	}
+
+	// Test case 4: Totals are swept in the receipt's currency precision
+	yen := receipt
+	yen.Currency = "JPY"
+	yen.Total = "450"
+	yen.Items = []Item{{ShortDescription: "Gatorade", Price: "225"}, {ShortDescription: "Gatorade", Price: "225"}}
+	rr = simulate(SimulateRequest{Receipt: yen, Parameter: "total", From: "100", To: "300", Step: "100"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "300", response.Results[2].Value)
+	rr = simulate(SimulateRequest{Receipt: yen, Parameter: "total", From: "1.00", To: "3.00", Step: "1.00"})
+	assert.Equal(t, "Invalid from. Expected an amount such as 1", decodeError(t, rr).Error)
+
+	// Test case 5: The base receipt must be valid
+	receipt.Retailer = ""
+	rr = simulate(SimulateRequest{Receipt: receipt, Parameter: "total", From: "1.00", To: "2.00", Step: "1.00"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Missing required receipt fields", decodeError(t, rr).Error)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultSnapshotInterval is how often the in-memory store is written to
+// SNAPSHOT_PATH unless SNAPSHOT_INTERVAL is set
+const defaultSnapshotInterval = time.Minute
+
+// storeSnapshot is the JSON document written by Snapshot
+type storeSnapshot struct {
+	Receipts        []snapshotRecord  `json:"receipts"`
+	IdempotencyKeys map[string]string `json:"idempotencyKeys"`
+}
+
+type snapshotRecord struct {
+	ExportRecord
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// Snapshot writes every receipt, its points and the idempotency keys as JSON.
+// The store is copied under its locks and written after they are released.
+func (rs *ReceiptStore) Snapshot(w io.Writer) error {
+	snapshot := storeSnapshot{Receipts: []snapshotRecord{}, IdempotencyKeys: map[string]string{}}
+
+	rs.indexMu.Lock()
+	for key, id := range rs.idempotencyKeys {
+		snapshot.IdempotencyKeys[key] = id
+	}
+	for _, shard := range rs.shards {
+		shard.RLock()
+	}
+	// Read every shard at once so the snapshot is consistent
+	for _, shard := range rs.shards {
+		for id, receipt := range shard.receipts {
+			snapshot.Receipts = append(snapshot.Receipts, snapshotRecord{
+				ExportRecord: ExportRecord{ID: id, Receipt: receipt, Points: shard.points[id], Breakdown: shard.breakdowns[id]},
+				AddedAt:      shard.addedAt[id],
+			})
+		}
+	}
+	for _, shard := range rs.shards {
+		shard.RUnlock()
+	}
+	rs.indexMu.Unlock()
+
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// Restore replaces the store's contents with a snapshot written by Snapshot.
+// The store is left unchanged if the snapshot can't be decoded.
+func (rs *ReceiptStore) Restore(r io.Reader) error {
+	var snapshot storeSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	rs.indexMu.Lock()
+	defer rs.indexMu.Unlock()
+	for _, shard := range rs.shards {
+		shard.Lock()
+		defer shard.Unlock()
+	}
+
+	for _, shard := range rs.shards {
+		shard.receipts = make(map[string]Receipt)
+		shard.points = make(map[string]int)
+		shard.breakdowns = make(map[string][]PointsBreakdown)
+		shard.addedAt = make(map[string]time.Time)
+	}
+	rs.hashToID = make(map[string]string)
+	rs.idempotencyKeys = make(map[string]string)
+	rs.addOrder = newAddOrder()
+
+	// Oldest first, so the receipt cap evicts in the order they were added
+	sort.SliceStable(snapshot.Receipts, func(i, j int) bool {
+		return snapshot.Receipts[i].AddedAt.Before(snapshot.Receipts[j].AddedAt)
+	})
+	for _, record := range snapshot.Receipts {
+		shard := rs.shardFor(record.ID)
+		shard.receipts[record.ID] = record.Receipt
+		shard.points[record.ID] = record.Points
+		shard.breakdowns[record.ID] = record.breakdown()
+		shard.addedAt[record.ID] = record.AddedAt
+		if rs.dedup {
+			rs.hashToID[receiptHash(record.Receipt)] = record.ID
+		}
+		if rs.maxReceipts > 0 {
+			rs.addOrder.push(record.ID)
+		}
+	}
+	for rs.maxReceipts > 0 && rs.addOrder.len() > rs.maxReceipts {
+		id, _ := rs.addOrder.oldest()
+		rs.deleteLocked(rs.shardFor(id), id)
+	}
+	for key, id := range snapshot.IdempotencyKeys {
+		rs.idempotencyKeys[key] = id
+	}
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to path. It writes a temporary file in the
+// same directory and renames it into place, so a crash mid-write never
+// leaves a truncated snapshot behind.
+func (rs *ReceiptStore) SaveSnapshot(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := rs.Snapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// LoadSnapshot restores the snapshot at path. A missing file is not an
+// error, so the first start with a new path begins empty.
+func (rs *ReceiptStore) LoadSnapshot(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return rs.Restore(file)
+}
+
+// StartSnapshotter saves a snapshot to path every interval until ctx is done.
+// The final snapshot on shutdown is left to the caller, so it can finish
+// before the process exits.
+func (rs *ReceiptStore) StartSnapshotter(ctx context.Context, path string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := rs.SaveSnapshot(path); err != nil {
+					slog.Error("failed to save snapshot", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	store := NewReceiptStore()
+	store.dedup = true
+
+	morning := validReceipt()
+	morning.PurchaseTime = "08:13"
+	first := store.AddReceipt(context.Background(), validReceipt())
+	second, _, err := store.AddReceiptIdempotent(context.Background(), "key-1", morning)
+	assert.NoError(t, err)
+
+	// Test case 1: A fresh store restored from the snapshot has the same
+	// receipts, points and breakdowns
+	var snapshot bytes.Buffer
+	assert.NoError(t, store.Snapshot(&snapshot))
+
+	restored := NewReceiptStore()
+	restored.dedup = true
+	assert.NoError(t, restored.Restore(bytes.NewReader(snapshot.Bytes())))
+
+	for _, id := range []string{first, second} {
+		expectedReceipt, _ := store.GetReceipt(context.Background(), id)
+		expectedPoints, _ := store.GetPoints(context.Background(), id)
+		receipt, exists := restored.GetReceipt(context.Background(), id)
+		assert.True(t, exists)
+		assert.Equal(t, expectedReceipt, receipt)
+		points, _ := restored.GetPoints(context.Background(), id)
+		assert.Equal(t, expectedPoints, points)
+		expectedBreakdown, _ := store.GetBreakdown(context.Background(), id)
+		breakdown, _ := restored.GetBreakdown(context.Background(), id)
+		assert.Equal(t, expectedBreakdown, breakdown)
+	}
+	assert.Equal(t, store.Stats(context.Background()), restored.Stats(context.Background()))
+
+	// Test case 2: Idempotency keys and dedup survive the restore
+	id, replayed, err := restored.AddReceiptIdempotent(context.Background(), "key-1", morning)
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, second, id)
+	assert.Equal(t, first, restored.AddReceipt(context.Background(), validReceipt()))
+
+	// Test case 3: Restoring replaces what was in the store
+	extra := restored.AddReceipt(context.Background(), Receipt{
+		Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01",
+		Items: []Item{{ShortDescription: "Gum", Price: "1.00"}}, Total: "1.00",
+	})
+	assert.NoError(t, restored.Restore(bytes.NewReader(snapshot.Bytes())))
+	_, exists := restored.GetPoints(context.Background(), extra)
+	assert.False(t, exists)
+
+	// Test case 4: An invalid snapshot leaves the store unchanged
+	assert.Error(t, restored.Restore(strings.NewReader("not json")))
+	_, exists = restored.GetPoints(context.Background(), first)
+	assert.True(t, exists)
+}
+
+func TestSnapshotRestoreMaxReceipts(t *testing.T) {
+	store := NewReceiptStore()
+	store.idGenerator = &counterIDGenerator{}
+	for i := 0; i < 3; i++ {
+		store.AddReceipt(context.Background(), validReceipt())
+		time.Sleep(time.Millisecond)
+	}
+	var snapshot bytes.Buffer
+	assert.NoError(t, store.Snapshot(&snapshot))
+
+	// Test case 1: A capped store keeps the newest receipts of the snapshot
+	restored := NewReceiptStore()
+	restored.maxReceipts = 2
+	assert.NoError(t, restored.Restore(&snapshot))
+	assert.Equal(t, []string{"receipt-2", "receipt-3"}, restored.ReceiptIDs(context.Background()))
+
+	// Test case 2: Later adds evict in the order the receipts were added
+	counter := &counterIDGenerator{}
+	counter.next.Store(3)
+	restored.idGenerator = counter
+	restored.AddReceipt(context.Background(), validReceipt())
+	assert.Equal(t, []string{"receipt-3", "receipt-4"}, restored.ReceiptIDs(context.Background()))
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	// Test case 1: A missing snapshot file starts empty
+	store := NewReceiptStore()
+	assert.NoError(t, store.LoadSnapshot(path))
+	assert.Equal(t, 0, store.Stats(context.Background()).Receipts)
+
+	// Test case 2: A saved snapshot loads into a new store, leaving no
+	// temporary files behind
+	id := store.AddReceipt(context.Background(), validReceipt())
+	assert.NoError(t, store.SaveSnapshot(path))
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1)
+
+	restored := NewReceiptStore()
+	assert.NoError(t, restored.LoadSnapshot(path))
+	points, exists := restored.GetPoints(context.Background(), id)
+	assert.True(t, exists)
+	assert.Equal(t, calculatePoints(validReceipt(), currentRules()), points)
+
+	// Test case 3: The snapshotter saves on every tick
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	second := store.AddReceipt(context.Background(), validReceipt())
+	store.StartSnapshotter(ctx, path, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		restored := NewReceiptStore()
+		if err := restored.LoadSnapshot(path); err != nil {
+			return false
+		}
+		_, exists := restored.GetPoints(context.Background(), second)
+		return exists
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// receiptEventBuffer is how far a /receipts/stream subscriber may fall behind
+// before further events are dropped for it
+const receiptEventBuffer = 64
+
+// streamKeepAlive is how often an idle stream sends a comment, so proxies
+// don't close the connection
+const streamKeepAlive = 15 * time.Second
+
+// ReceiptBroker fans the events of processed receipts out to every
+// subscriber. Publishing never blocks: a subscriber whose buffer is full
+// misses the event rather than holding up the request that stored it.
+type ReceiptBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan ReceiptEvent]struct{}
+
+	// closed is closed by Close to end every stream
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewReceiptBroker() *ReceiptBroker {
+	return &ReceiptBroker{
+		subscribers: make(map[chan ReceiptEvent]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Close ends every open stream. http.Server.Shutdown doesn't cancel request
+// contexts and a stream never goes idle, so without it a connected client
+// would hold up shutdown until the timeout.
+func (rb *ReceiptBroker) Close() {
+	rb.closeOnce.Do(func() { close(rb.closed) })
+}
+
+// Subscribe returns a channel of events and a function that unsubscribes it.
+// The channel is never closed, so unsubscribing can't race a publish.
+func (rb *ReceiptBroker) Subscribe() (<-chan ReceiptEvent, func()) {
+	events := make(chan ReceiptEvent, receiptEventBuffer)
+	rb.mu.Lock()
+	rb.subscribers[events] = struct{}{}
+	rb.mu.Unlock()
+
+	return events, func() {
+		rb.mu.Lock()
+		delete(rb.subscribers, events)
+		rb.mu.Unlock()
+	}
+}
+
+// Publish sends the event to every subscriber with room for it
+func (rb *ReceiptBroker) Publish(event ReceiptEvent) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	for events := range rb.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// PublishStore wraps the store so that processed receipts are published to
+// the broker's subscribers
+func (rb *ReceiptBroker) PublishStore(store Store) Store {
+	return onStored(store, func(scored ScoredReceipt) {
+		rb.Publish(newReceiptEvent(scored))
+	})
+}
+
+// ReceiptStreamHandler sends a server-sent event for every receipt processed
+// while the client stays connected, or until the broker is closed. Receipts
+// stored before it connected, and events it was too slow to read, are not
+// sent.
+func (s *Server) ReceiptStreamHandler(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	controller.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.events.closed:
+			return
+		case event := <-events:
+			data, _ := json.Marshal(event)
+			_, err = fmt.Fprintf(w, "event: receipt\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		controller.Flush()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptStream(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case 1: The stream opens as server-sent events. Headers are only
+	// sent once the handler has subscribed, so nothing processed below is missed.
+	resp, err := http.Get(ts.URL + "/receipts/stream")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Test case 2: Processing a receipt sends an event with its id and points
+	body, _ := json.Marshal(validReceipt())
+	processed, err := http.Post(ts.URL+"/receipts/process", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	var response ReceiptResponse
+	assert.NoError(t, json.NewDecoder(processed.Body).Decode(&response))
+	processed.Body.Close()
+
+	events := make(chan ReceiptEvent, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var event ReceiptEvent
+				json.Unmarshal([]byte(data), &event)
+				events <- event
+				return
+			}
+		}
+	}()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, response.ID, event.ID)
+		assert.Equal(t, validReceipt().Retailer, event.Retailer)
+		assert.Equal(t, calculatePoints(validReceipt(), currentRules()), event.Points)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was streamed")
+	}
+}
+
+func TestReceiptStreamEndsOnClose(t *testing.T) {
+	server := NewServer(NewReceiptStore())
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case 1: Closing the broker ends open streams so shutdown can finish
+	resp, err := http.Get(ts.URL + "/receipts/stream")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(ended)
+	}()
+	server.events.Close()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream stayed open after the broker was closed")
+	}
+}
+
+func TestReceiptBrokerDropsForSlowSubscribers(t *testing.T) {
+	broker := NewReceiptBroker()
+	slow, unsubscribe := broker.Subscribe()
+
+	// Test case 1: Publishing to a full subscriber doesn't block
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < receiptEventBuffer+10; i++ {
+			broker.Publish(ReceiptEvent{ID: "id"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+	assert.Len(t, slow, receiptEventBuffer)
+
+	// Test case 2: Unsubscribed channels receive nothing more
+	for len(slow) > 0 {
+		<-slow
+	}
+	unsubscribe()
+	broker.Publish(ReceiptEvent{ID: "id"})
+	assert.Len(t, slow, 0)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowStore delays every write to simulate a stuck backend, giving up when
+// the request context is cancelled
+type slowStore struct {
+	Store
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (ss *slowStore) AddReceipt(ctx context.Context, receipt Receipt) string {
+	select {
+	case <-time.After(ss.delay):
+		return ss.Store.AddReceipt(ctx, receipt)
+	case <-ctx.Done():
+		close(ss.cancelled)
+		return ""
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	reqBody, _ := json.Marshal(validReceipt())
+
+	// Test case 1: Handlers that exceed the deadline get a 503
+	store := &slowStore{Store: NewReceiptStore(), delay: 200 * time.Millisecond, cancelled: make(chan struct{})}
+	router := mux.NewRouter()
+	NewServer(store).RegisterRoutes(router)
+
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	TimeoutMiddleware(20*time.Millisecond, router).ServeHTTP(rr, req)
+
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "Request timed out", decodeError(t, rr).Error)
+
+	// The store sees the cancelled request context
+	select {
+	case <-store.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("store was not cancelled")
+	}
+
+	// Test case 2: Fast handlers pass through with their headers and body
+	router = mux.NewRouter()
+	NewServer(NewReceiptStore()).RegisterRoutes(router)
+
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(reqBody))
+	rr = httptest.NewRecorder()
+	TimeoutMiddleware(time.Second, router).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.ID)
+}
+
+func TestTimeoutMiddlewareStreamingPaths(t *testing.T) {
+	// Streaming endpoints run past the deadline and write straight through
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("streamed\n"))
+	})
+
+	req, _ := http.NewRequest("GET", "/export", nil)
+	rr := httptest.NewRecorder()
+	TimeoutMiddleware(10*time.Millisecond, slow).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "streamed\n", rr.Body.String())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// TLSConfig serves HTTPS when both the certificate and key files are set
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// RedirectAddr, when set, also listens for plain HTTP there and
+	// redirects every request to HTTPS
+	RedirectAddr string `yaml:"redirectAddr"`
+}
+
+// Enabled reports whether the server should listen with TLS
+func (tc TLSConfig) Enabled() bool {
+	return tc.CertFile != "" && tc.KeyFile != ""
+}
+
+// RedirectHandler sends every request to the same host and path over HTTPS,
+// on the port of httpsAddr. 308 keeps the method and body of POSTs intact.
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
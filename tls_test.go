@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfigEnabled(t *testing.T) {
+	assert.False(t, TLSConfig{}.Enabled())
+	assert.False(t, TLSConfig{CertFile: "cert.pem"}.Enabled())
+	assert.True(t, TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}.Enabled())
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsAddr string
+		host      string
+		target    string
+	}{
+		// Test case 1: A non-default HTTPS port is kept
+		{":8443", "example.com:8080", "https://example.com:8443/receipts/abc/points?breakdown=true"},
+		// Test case 2: The default HTTPS port is left out
+		{":443", "example.com", "https://example.com/receipts/abc/points?breakdown=true"},
+		// Test case 3: IPv6 hosts are bracketed
+		{"[::1]:8443", "[::1]:8080", "https://[::1]:8443/receipts/abc/points?breakdown=true"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/receipts/abc/points?breakdown=true", nil)
+		req.Host = tt.host
+		rr := httptest.NewRecorder()
+		RedirectHandler(tt.httpsAddr).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+		assert.Equal(t, tt.target, rr.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Validation patterns from the API specification. The spec allows \s in
+// retailer names, but only plain spaces are accepted here so that newlines
+// and other control characters are rejected.
+var (
+	retailerPattern = regexp.MustCompile(`^[\w \-&]+$`)
+
+	// unicodeRetailerPattern also accepts non-ASCII letters and digits, for
+	// rulesets that score them
+	unicodeRetailerPattern = regexp.MustCompile(`^[\p{L}\p{N}_ \-&]+$`)
+
+	// time.Parse accepts some inputs that aren't strict HH:MM, so times
+	// must match this 24-hour pattern before they are parsed
+	timePattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+	// Dates must be zero-padded before time.Parse checks that they exist
+	datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+	// Client ids are restricted to characters that are safe in URLs and
+	// store keys. UUIDs match.
+	clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Sentinel errors reported by validateReceipt. Use errors.Is to check for them.
+var (
+	ErrMissingField  = errors.New("missing required field")
+	ErrNoItems       = errors.New("no items")
+	ErrBadRetailer   = errors.New("invalid retailer")
+	ErrBadDate       = errors.New("invalid purchase date")
+	ErrBadTime       = errors.New("invalid purchase time")
+	ErrBadTotal      = errors.New("invalid total")
+	ErrBadCurrency   = errors.New("invalid currency")
+	ErrBadItemPrice  = errors.New("invalid item price")
+	ErrTotalMismatch = errors.New("total does not match items")
+	ErrBadDateTime   = errors.New("invalid purchase date time")
+	ErrDateConflict  = errors.New("purchase date time conflicts with date or time")
+	ErrTooManyItems  = errors.New("too many items")
+	ErrFieldTooLong  = errors.New("field too long")
+	ErrFutureDate    = errors.New("purchase date time in the future")
+	ErrBadClientID   = errors.New("invalid client id")
+)
+
+// ValidationLimits caps the size of a receipt so that oversized input is
+// rejected before it is scored and stored
+type ValidationLimits struct {
+	MaxItems             int `yaml:"maxItems"`
+	MaxRetailerLength    int `yaml:"maxRetailerLength"`
+	MaxDescriptionLength int `yaml:"maxDescriptionLength"`
+	MaxLabelLength       int `yaml:"maxLabelLength"`
+
+	// MaxItemPrice caps each item price, in whole units of the receipt's
+	// currency. Zero leaves prices uncapped.
+	MaxItemPrice int `yaml:"maxItemPrice"`
+
+	// RejectFutureDates rejects receipts purchased after the current time.
+	// purchaseDate and purchaseTime are local times in TimeZone, both for
+	// this check and for scoring.
+	RejectFutureDates bool   `yaml:"rejectFutureDates"`
+	TimeZone          string `yaml:"timeZone"`
+}
+
+func DefaultValidationLimits() ValidationLimits {
+	return ValidationLimits{
+		MaxItems:             1000,
+		MaxRetailerLength:    256,
+		MaxDescriptionLength: 256,
+		MaxLabelLength:       64,
+		TimeZone:             "UTC",
+	}
+}
+
+// validationLimits are the caps applied by validateReceipt. Lengths are
+// counted in characters, not bytes.
+var validationLimits = DefaultValidationLimits()
+
+// receiptLocation is the loaded validationLimits.TimeZone, kept separately so
+// the zone database isn't read for every receipt. Scoring also reads
+// purchase times in it before converting them to the scoring time zone.
+var receiptLocation = time.UTC
+
+// ValidationError describes why a receipt was rejected. Message is meant for
+// the client, while Err is one of the sentinel errors above.
+type ValidationError struct {
+	Err     error
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors lists every problem validateReceipt found with a receipt.
+// errors.Is and errors.As check each of them, and the first is the one
+// reported on its own where only a single message fits.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (errs ValidationErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+func invalid(err error, field, message string) error {
+	return &ValidationError{Err: err, Field: field, Message: message}
+}
+
+// prepareReceipt fills in fields given in an alternative form and then
+// validates the receipt. Handlers use it in place of validateReceipt.
+func prepareReceipt(receipt *Receipt) error {
+	return prepareReceiptWith(receipt, currentRules())
+}
+
+// prepareReceiptWith prepares a receipt to be scored with rules rather than the
+// active ruleset, which decides the retailer names accepted and whether the
+// retailer is normalized
+func prepareReceiptWith(receipt *Receipt, rules RuleSet) error {
+	if err := resolvePurchaseDateTime(receipt); err != nil {
+		return err
+	}
+	if err := validateReceiptWith(*receipt, rules); err != nil {
+		return err
+	}
+
+	// Clients can't choose the normalized name, so it is always recomputed
+	receipt.NormalizedRetailer = ""
+	if rules.NormalizeRetailers {
+		receipt.NormalizedRetailer = normalizeRetailer(receipt.Retailer)
+	}
+	return nil
+}
+
+// normalizeRetailer trims a retailer name, collapses runs of whitespace to a
+// single space and lowercases it, so casing and spacing variants match
+func normalizeRetailer(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// resolvePurchaseDateTime derives purchaseDate and purchaseTime from a
+// combined RFC 3339 purchaseDateTime, in the timestamp's own offset so the
+// date and time are the ones printed on the receipt. Split fields sent
+// alongside it must agree. purchaseDateTime is kept, since its offset is
+// what places the purchase in time; see purchaseInstant.
+func resolvePurchaseDateTime(receipt *Receipt) error {
+	if receipt.PurchaseDateTime == "" {
+		return nil
+	}
+
+	purchasedAt, err := time.Parse(time.RFC3339, receipt.PurchaseDateTime)
+	if err != nil {
+		return invalid(ErrBadDateTime, "purchaseDateTime", "Invalid purchase date time format. Expected RFC 3339")
+	}
+
+	date := purchasedAt.Format("2006-01-02")
+	clock := purchasedAt.Format("15:04")
+	if (receipt.PurchaseDate != "" && receipt.PurchaseDate != date) ||
+		(receipt.PurchaseTime != "" && receipt.PurchaseTime != clock) {
+		return invalid(ErrDateConflict, "purchaseDateTime", "purchaseDateTime does not match purchaseDate and purchaseTime")
+	}
+
+	receipt.PurchaseDate = date
+	receipt.PurchaseTime = clock
+	return nil
+}
+
+// purchaseInstant returns when a prepared receipt was purchased. A
+// purchaseDateTime carries its own offset; split fields are read in
+// receiptLocation.
+func purchaseInstant(receipt Receipt) (time.Time, error) {
+	if receipt.PurchaseDateTime != "" {
+		return time.Parse(time.RFC3339, receipt.PurchaseDateTime)
+	}
+	return time.ParseInLocation("2006-01-02 15:04", receipt.PurchaseDate+" "+receipt.PurchaseTime, receiptLocation)
+}
+
+// validateReceipt checks a decoded receipt before it is scored and stored.
+// Every problem found is returned, in field order, as ValidationErrors.
+// Checks that depend on a field that is missing or malformed are skipped, so
+// each problem is only reported once.
+func validateReceipt(receipt Receipt) error {
+	return validateReceiptWith(receipt, currentRules())
+}
+
+// validateReceiptWith checks a receipt that will be scored with rules
+func validateReceiptWith(receipt Receipt, rules RuleSet) error {
+	var errs ValidationErrors
+	fail := func(err error, field, message string) {
+		errs = append(errs, &ValidationError{Err: err, Field: field, Message: message})
+	}
+
+	// Basic validation
+	required := []struct{ field, value string }{
+		{"retailer", receipt.Retailer},
+		{"purchaseDate", receipt.PurchaseDate},
+		{"purchaseTime", receipt.PurchaseTime},
+		{"total", receipt.Total},
+	}
+	missing := map[string]bool{}
+	for _, r := range required {
+		if r.value == "" {
+			fail(ErrMissingField, r.field, "Missing required receipt fields")
+			missing[r.field] = true
+		}
+	}
+
+	// Validate that there is at least one item and every item is complete.
+	// Prices are only summed when every item has one.
+	pricesPresent := false
+	switch {
+	case len(receipt.Items) == 0:
+		fail(ErrNoItems, "items", "Receipt must contain at least one item")
+	case len(receipt.Items) > validationLimits.MaxItems:
+		fail(ErrTooManyItems, "items",
+			fmt.Sprintf("Receipt must contain at most %d items", validationLimits.MaxItems))
+	default:
+		pricesPresent = true
+		for i, item := range receipt.Items {
+			if item.ShortDescription == "" {
+				fail(ErrMissingField, fmt.Sprintf("items[%d].shortDescription", i),
+					fmt.Sprintf("Item %d is missing shortDescription", i))
+			} else if utf8.RuneCountInString(item.ShortDescription) > validationLimits.MaxDescriptionLength {
+				fail(ErrFieldTooLong, fmt.Sprintf("items[%d].shortDescription", i),
+					fmt.Sprintf("Item %d shortDescription must be at most %d characters", i, validationLimits.MaxDescriptionLength))
+			}
+			if item.Price == "" {
+				fail(ErrMissingField, fmt.Sprintf("items[%d].price", i),
+					fmt.Sprintf("Item %d is missing price", i))
+				pricesPresent = false
+			}
+		}
+	}
+
+	// Validate retailer name
+	if !missing["retailer"] {
+		pattern := retailerPattern
+		if rules.UnicodeAlphanumeric {
+			pattern = unicodeRetailerPattern
+		}
+		if utf8.RuneCountInString(receipt.Retailer) > validationLimits.MaxRetailerLength {
+			fail(ErrFieldTooLong, "retailer",
+				fmt.Sprintf("Retailer must be at most %d characters", validationLimits.MaxRetailerLength))
+		} else if !pattern.MatchString(receipt.Retailer) {
+			fail(ErrBadRetailer, "retailer", "Invalid retailer")
+		}
+	}
+
+	// Validate date format (YYYY-MM-DD), then that the date is on the calendar
+	dateValid := false
+	if !missing["purchaseDate"] {
+		if !datePattern.MatchString(receipt.PurchaseDate) {
+			fail(ErrBadDate, "purchaseDate", "Invalid purchase date format. Expected YYYY-MM-DD")
+		} else if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
+			fail(ErrBadDate, "purchaseDate", "Invalid purchase date. "+receipt.PurchaseDate+" is not a calendar date")
+		} else {
+			dateValid = true
+		}
+	}
+
+	// Validate time format (HH:MM)
+	timeValid := false
+	if !missing["purchaseTime"] {
+		if _, err := time.Parse("15:04", receipt.PurchaseTime); !timePattern.MatchString(receipt.PurchaseTime) || err != nil {
+			fail(ErrBadTime, "purchaseTime", "Invalid purchase time format. Expected HH:MM")
+		} else {
+			timeValid = true
+		}
+	}
+
+	// Validate that the purchase isn't in the future, to the minute
+	if validationLimits.RejectFutureDates && dateValid && timeValid {
+		purchasedAt, err := purchaseInstant(receipt)
+		if err == nil && purchasedAt.After(time.Now()) {
+			fail(ErrFutureDate, "purchaseDate", "Purchase date and time must not be in the future")
+		}
+	}
+
+	if !currencyPattern.MatchString(receipt.currencyCode()) {
+		fail(ErrBadCurrency, "currency", "Invalid currency. Expected an ISO 4217 code")
+	}
+
+	// Validate total format: exactly as many decimal places as the currency
+	// has, so two for USD, none for JPY and three for BHD
+	var totalMinor int64
+	totalValid := false
+	if !missing["total"] {
+		if amount, err := receipt.minorAmount(receipt.Total); err != nil {
+			if receipt.isNegativeAmount(receipt.Total) {
+				fail(ErrBadTotal, "total", "Total must not be negative")
+			} else if errors.Is(err, errAmountTooLarge) {
+				fail(ErrBadTotal, "total", fmt.Sprintf("Total must be less than %d", maxAmountUnits))
+			} else {
+				fail(ErrBadTotal, "total", "Invalid total format")
+			}
+		} else {
+			totalMinor, totalValid = amount, true
+		}
+	}
+
+	// Validate that the total matches the sum of the item prices
+	var itemsMinor int64
+	pricesValid := pricesPresent
+	if pricesPresent {
+		digits := receipt.minorUnits()
+		maxPrice := int64(validationLimits.MaxItemPrice) * minorUnitsPerMajor(digits)
+		for i, item := range receipt.Items {
+			price, err := receipt.minorAmount(item.Price)
+			if err != nil {
+				if receipt.isNegativeAmount(item.Price) {
+					fail(ErrBadItemPrice, fmt.Sprintf("items[%d].price", i),
+						fmt.Sprintf("Item %d price must not be negative", i))
+				} else if errors.Is(err, errAmountTooLarge) {
+					fail(ErrBadItemPrice, fmt.Sprintf("items[%d].price", i),
+						fmt.Sprintf("Item %d price must be less than %d", i, maxAmountUnits))
+				} else {
+					fail(ErrBadItemPrice, fmt.Sprintf("items[%d].price", i), "Invalid item price format")
+				}
+				pricesValid = false
+				continue
+			}
+			if maxPrice > 0 && price > maxPrice {
+				fail(ErrBadItemPrice, fmt.Sprintf("items[%d].price", i),
+					fmt.Sprintf("Item %d price must be at most %s", i, formatMinorUnits(maxPrice, digits)))
+			}
+			itemsMinor += price
+		}
+	}
+	if totalValid && pricesValid && itemsMinor != totalMinor {
+		fail(ErrTotalMismatch, "total", "Total does not match sum of items")
+	}
+
+	if utf8.RuneCountInString(receipt.Label) > validationLimits.MaxLabelLength {
+		fail(ErrFieldTooLong, "label",
+			fmt.Sprintf("Label must be at most %d characters", validationLimits.MaxLabelLength))
+	}
+
+	if receipt.ClientID != "" && !clientIDPattern.MatchString(receipt.ClientID) {
+		fail(ErrBadClientID, "clientId",
+			"Invalid clientId. Expected a UUID or up to 64 letters, digits, hyphens and underscores")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// isNegativeAmount reports whether amount would be well-formed without a
+// leading minus sign, so that negative amounts get their own message rather
+// than being reported as badly formatted
+func (r Receipt) isNegativeAmount(amount string) bool {
+	rest, negative := strings.CutPrefix(amount, "-")
+	if !negative {
+		return false
+	}
+	_, err := r.minorAmount(rest)
+	return err == nil
+}
+
+// validationStatus maps an error from validateReceipt to an HTTP status code
+// and the message to send to the client.
+func validationStatus(err error) (int, string) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusBadRequest, validationErr.Message
+	}
+	return http.StatusInternalServerError, "Failed to validate receipt"
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// validReceipt returns a receipt that passes validateReceipt
+func validReceipt() Receipt {
+	return Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "4.50",
+	}
+}
+
+func TestValidateReceipt(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(r *Receipt)
+		err     error
+		field   string
+		message string
+	}{
+		{"valid", func(r *Receipt) {}, nil, "", ""},
+		{"missing retailer", func(r *Receipt) { r.Retailer = "" }, ErrMissingField, "retailer", "Missing required receipt fields"},
+		{"missing date", func(r *Receipt) { r.PurchaseDate = "" }, ErrMissingField, "purchaseDate", "Missing required receipt fields"},
+		{"missing time", func(r *Receipt) { r.PurchaseTime = "" }, ErrMissingField, "purchaseTime", "Missing required receipt fields"},
+		{"missing total", func(r *Receipt) { r.Total = "" }, ErrMissingField, "total", "Missing required receipt fields"},
+		{"no items", func(r *Receipt) { r.Items = []Item{} }, ErrNoItems, "items", "Receipt must contain at least one item"},
+		{"blank item description", func(r *Receipt) { r.Items[1].ShortDescription = "" }, ErrMissingField, "items[1].shortDescription", "Item 1 is missing shortDescription"},
+		{"blank item price", func(r *Receipt) { r.Items[0].Price = "" }, ErrMissingField, "items[0].price", "Item 0 is missing price"},
+		{"bad retailer", func(r *Receipt) { r.Retailer = "Target!" }, ErrBadRetailer, "retailer", "Invalid retailer"},
+		{"bad date", func(r *Receipt) { r.PurchaseDate = "03/20/2022" }, ErrBadDate, "purchaseDate", "Invalid purchase date format. Expected YYYY-MM-DD"},
+		{"unpadded date", func(r *Receipt) { r.PurchaseDate = "2022-1-1" }, ErrBadDate, "purchaseDate", "Invalid purchase date format. Expected YYYY-MM-DD"},
+		{"month 13", func(r *Receipt) { r.PurchaseDate = "2022-13-01" }, ErrBadDate, "purchaseDate", "Invalid purchase date. 2022-13-01 is not a calendar date"},
+		{"February 30", func(r *Receipt) { r.PurchaseDate = "2022-02-30" }, ErrBadDate, "purchaseDate", "Invalid purchase date. 2022-02-30 is not a calendar date"},
+		{"leap day", func(r *Receipt) { r.PurchaseDate = "2024-02-29" }, nil, "", ""},
+		{"bad time", func(r *Receipt) { r.PurchaseTime = "2:33pm" }, ErrBadTime, "purchaseTime", "Invalid purchase time format. Expected HH:MM"},
+		{"valid afternoon time", func(r *Receipt) { r.PurchaseTime = "14:33" }, nil, "", ""},
+		{"hour 24", func(r *Receipt) { r.PurchaseTime = "24:00" }, ErrBadTime, "purchaseTime", "Invalid purchase time format. Expected HH:MM"},
+		{"unpadded time", func(r *Receipt) { r.PurchaseTime = "1:5" }, ErrBadTime, "purchaseTime", "Invalid purchase time format. Expected HH:MM"},
+		{"minute 61", func(r *Receipt) { r.PurchaseTime = "13:61" }, ErrBadTime, "purchaseTime", "Invalid purchase time format. Expected HH:MM"},
+		{"bad total", func(r *Receipt) { r.Total = "four fifty" }, ErrBadTotal, "total", "Invalid total format"},
+		{"total without cents", func(r *Receipt) { r.Total = "4" }, ErrBadTotal, "total", "Invalid total format"},
+		{"total with one decimal", func(r *Receipt) { r.Total = "4.5" }, ErrBadTotal, "total", "Invalid total format"},
+		{"total with three decimals", func(r *Receipt) { r.Total = "4.500" }, ErrBadTotal, "total", "Invalid total format"},
+		{"negative total", func(r *Receipt) { r.Total = "-4.50" }, ErrBadTotal, "total", "Total must not be negative"},
+		{"malformed negative total", func(r *Receipt) { r.Total = "-4.5" }, ErrBadTotal, "total", "Invalid total format"},
+		{"total in exponent form", func(r *Receipt) { r.Total = "4.5e0" }, ErrBadTotal, "total", "Invalid total format"},
+		{"bad item price", func(r *Receipt) { r.Items[0].Price = "2.5" }, ErrBadItemPrice, "items[0].price", "Invalid item price format"},
+		{"negative item price", func(r *Receipt) {
+			r.Items[1].Price = "-5.00"
+			r.Total = "2.25"
+		}, ErrBadItemPrice, "items[1].price", "Item 1 price must not be negative"},
+		{"negative yen price", func(r *Receipt) {
+			r.Currency = "JPY"
+			r.Total = "450"
+			r.Items[0].Price = "-225"
+			r.Items[1].Price = "225"
+		}, ErrBadItemPrice, "items[0].price", "Item 0 price must not be negative"},
+		{"18-digit item price", func(r *Receipt) { r.Items[0].Price = "9000000000000000.00" }, ErrBadItemPrice, "items[0].price", "Item 0 price must be less than 1000000000"},
+		{"price past int64", func(r *Receipt) { r.Items[0].Price = "99999999999999999999.00" }, ErrBadItemPrice, "items[0].price", "Item 0 price must be less than 1000000000"},
+		{"total at the amount cap", func(r *Receipt) { r.Total = "1000000000.00" }, ErrBadTotal, "total", "Total must be less than 1000000000"},
+		{"largest yen amount", func(r *Receipt) {
+			r.Currency = "JPY"
+			r.Total = "999999999"
+			r.Items[0].Price = "999999774"
+			r.Items[1].Price = "225"
+		}, nil, "", ""},
+		{"zero item price", func(r *Receipt) {
+			r.Items[0].Price = "0.00"
+			r.Total = "2.25"
+		}, nil, "", ""},
+		{"zero total", func(r *Receipt) {
+			r.Items[0].Price = "0.00"
+			r.Items[1].Price = "0.00"
+			r.Total = "0.00"
+		}, nil, "", ""},
+		{"lowercase currency", func(r *Receipt) { r.Currency = "usd" }, ErrBadCurrency, "currency", "Invalid currency. Expected an ISO 4217 code"},
+		{"currency symbol", func(r *Receipt) { r.Currency = "$" }, ErrBadCurrency, "currency", "Invalid currency. Expected an ISO 4217 code"},
+		{"comma decimals for euros", func(r *Receipt) {
+			r.Currency = "EUR"
+			r.Total = "4,50"
+			r.Items[0].Price = "2,25"
+		}, nil, "", ""},
+		{"comma decimals for dollars", func(r *Receipt) { r.Total = "4,50" }, ErrBadTotal, "total", "Invalid total format"},
+		{"comma decimal item price for dollars", func(r *Receipt) {
+			r.Currency = "USD"
+			r.Items[0].Price = "2,25"
+		}, ErrBadItemPrice, "items[0].price", "Invalid item price format"},
+		{"total mismatch", func(r *Receipt) { r.Total = "4.51" }, ErrTotalMismatch, "total", "Total does not match sum of items"},
+		{"whole yen", func(r *Receipt) {
+			r.Currency = "JPY"
+			r.Total = "450"
+			r.Items[0].Price = "225"
+			r.Items[1].Price = "225"
+		}, nil, "", ""},
+		{"yen with decimals", func(r *Receipt) {
+			r.Currency = "JPY"
+			r.Items[0].Price = "225"
+			r.Items[1].Price = "225"
+		}, ErrBadTotal, "total", "Invalid total format"},
+		{"three decimal dinars", func(r *Receipt) {
+			r.Currency = "BHD"
+			r.Total = "4.500"
+			r.Items[0].Price = "2.250"
+			r.Items[1].Price = "2.250"
+		}, nil, "", ""},
+		{"dinar price with two decimals", func(r *Receipt) {
+			r.Currency = "BHD"
+			r.Total = "4.500"
+		}, ErrBadItemPrice, "items[0].price", "Invalid item price format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := validReceipt()
+			tt.modify(&receipt)
+
+			err := validateReceipt(receipt)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+
+			var validationErr *ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, tt.field, validationErr.Field)
+
+			status, message := validationStatus(err)
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Equal(t, tt.message, message)
+		})
+	}
+}
+
+func TestValidateReceiptAccumulatesErrors(t *testing.T) {
+	// Test case 1: Every independent problem is reported, in field order
+	receipt := validReceipt()
+	receipt.Retailer = "Target!"
+	receipt.PurchaseDate = "2022-02-30"
+	receipt.PurchaseTime = ""
+	receipt.Items[1].ShortDescription = ""
+	receipt.Total = "4.5"
+
+	err := validateReceipt(receipt)
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{"purchaseTime", "items[1].shortDescription", "retailer", "purchaseDate", "total"}, fields)
+	assert.ErrorIs(t, err, ErrMissingField)
+	assert.ErrorIs(t, err, ErrBadRetailer)
+	assert.ErrorIs(t, err, ErrBadDate)
+	assert.ErrorIs(t, err, ErrBadTotal)
+	assert.Equal(t, "Invalid purchase date. 2022-02-30 is not a calendar date", errs[3].Message)
+
+	// Test case 2: The first problem is the one validationStatus reports
+	status, message := validationStatus(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "Missing required receipt fields", message)
+
+	// Test case 3: Checks that depend on a bad field are skipped
+	receipt = validReceipt()
+	receipt.Items[0].Price = "2.5"
+	receipt.Total = "9.99"
+	err = validateReceipt(receipt)
+	assert.Equal(t, "Invalid item price format", err.Error())
+
+	// Test case 4: Every unparseable price is reported against its own item
+	receipt = validReceipt()
+	receipt.Items[0].Price = "2.5"
+	receipt.Items[1].Price = "abc"
+	assert.True(t, errors.As(validateReceipt(receipt), &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "items[0].price", errs[0].Field)
+	assert.Equal(t, "items[1].price", errs[1].Field)
+}
+
+func TestValidateReceiptLimits(t *testing.T) {
+	// receiptWithItems returns a valid receipt with n one-cent items
+	receiptWithItems := func(n int) Receipt {
+		receipt := validReceipt()
+		receipt.Items = make([]Item, n)
+		for i := range receipt.Items {
+			receipt.Items[i] = Item{ShortDescription: "Gum", Price: "0.01"}
+		}
+		receipt.Total = fmt.Sprintf("%d.%02d", n/100, n%100)
+		return receipt
+	}
+
+	// Test case 1: Item count at and over the cap
+	assert.NoError(t, validateReceipt(receiptWithItems(1000)))
+	err := validateReceipt(receiptWithItems(1001))
+	assert.ErrorIs(t, err, ErrTooManyItems)
+	assert.Equal(t, "Receipt must contain at most 1000 items", err.Error())
+
+	// Test case 2: Retailer length at and over the cap, counted in characters
+	receipt := validReceipt()
+	receipt.Retailer = strings.Repeat("a", 256)
+	assert.NoError(t, validateReceipt(receipt))
+	receipt.Retailer = strings.Repeat("a", 257)
+	err = validateReceipt(receipt)
+	assert.ErrorIs(t, err, ErrFieldTooLong)
+	assert.Equal(t, "retailer", err.(ValidationErrors)[0].Field)
+	assert.Equal(t, "Retailer must be at most 256 characters", err.Error())
+
+	// Test case 3: Description length at and over the cap
+	receipt = validReceipt()
+	receipt.Items[1].ShortDescription = strings.Repeat("é", 256)
+	assert.NoError(t, validateReceipt(receipt))
+	receipt.Items[1].ShortDescription = strings.Repeat("é", 257)
+	err = validateReceipt(receipt)
+	assert.ErrorIs(t, err, ErrFieldTooLong)
+	assert.Equal(t, "items[1].shortDescription", err.(ValidationErrors)[0].Field)
+	assert.Equal(t, "Item 1 shortDescription must be at most 256 characters", err.Error())
+
+	// Test case 4: The caps are configurable
+	defer func(limits ValidationLimits) { validationLimits = limits }(validationLimits)
+	validationLimits.MaxItems = 2
+	assert.NoError(t, validateReceipt(receiptWithItems(2)))
+	assert.ErrorIs(t, validateReceipt(receiptWithItems(3)), ErrTooManyItems)
+
+	// Test case 5: Item prices can be capped, in whole units of the currency
+	validationLimits.MaxItemPrice = 2
+	receipt = validReceipt()
+	err = validateReceipt(receipt)
+	assert.ErrorIs(t, err, ErrBadItemPrice)
+	assert.Equal(t, "items[0].price", err.(ValidationErrors)[0].Field)
+	assert.Equal(t, "Item 0 price must be at most 2.00; Item 1 price must be at most 2.00", err.Error())
+	receipt.Items[0].Price, receipt.Items[1].Price, receipt.Total = "2.00", "0.00", "2.00"
+	assert.NoError(t, validateReceipt(receipt))
+	receipt.Currency, receipt.Items[0].Price, receipt.Items[1].Price, receipt.Total = "JPY", "3", "0", "3"
+	assert.Equal(t, "Item 0 price must be at most 2", validateReceipt(receipt).Error())
+}
+
+func TestValidateReceiptFutureDates(t *testing.T) {
+	defer func(limits ValidationLimits, location *time.Location) {
+		validationLimits, receiptLocation = limits, location
+	}(validationLimits, receiptLocation)
+
+	location, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	receiptAt := func(at time.Time) Receipt {
+		receipt := validReceipt()
+		receipt.PurchaseDate = at.In(location).Format("2006-01-02")
+		receipt.PurchaseTime = at.In(location).Format("15:04")
+		return receipt
+	}
+	future := receiptAt(time.Now().Add(time.Hour))
+
+	// Test case 1: Future receipts are accepted by default
+	assert.NoError(t, validateReceipt(future))
+
+	validationLimits.RejectFutureDates = true
+	receiptLocation = location
+
+	// Test case 2: Past receipts are accepted
+	assert.NoError(t, validateReceipt(validReceipt()))
+	assert.NoError(t, validateReceipt(receiptAt(time.Now().Add(-time.Hour))))
+
+	// Test case 3: A receipt from the current minute is accepted
+	assert.NoError(t, validateReceipt(receiptAt(time.Now())))
+
+	// Test case 4: Future receipts are rejected
+	err = validateReceipt(future)
+	assert.ErrorIs(t, err, ErrFutureDate)
+	assert.Equal(t, "purchaseDate", err.(ValidationErrors)[0].Field)
+	assert.Equal(t, "Purchase date and time must not be in the future", err.Error())
+
+	// Test case 5: Receipt times are read in the configured location, so an
+	// hour ago in Tokyo is still hours ahead when read as UTC
+	receiptLocation = time.UTC
+	assert.ErrorIs(t, validateReceipt(receiptAt(time.Now().Add(-time.Hour))), ErrFutureDate)
+}
+
+func TestValidateReceiptUnicodeRetailer(t *testing.T) {
+	defer setActiveRules(currentRules())
+
+	receipt := validReceipt()
+	receipt.Retailer = "Café 北京"
+
+	// Test case 1: Non-ASCII retailers are rejected by default
+	assert.ErrorIs(t, validateReceipt(receipt), ErrBadRetailer)
+
+	// Test case 2: They are accepted when the rules score them
+	rules := DefaultRuleSet()
+	rules.UnicodeAlphanumeric = true
+	setActiveRules(rules)
+	assert.NoError(t, validateReceipt(receipt))
+
+	receipt.Retailer = "Café!"
+	assert.ErrorIs(t, validateReceipt(receipt), ErrBadRetailer)
+}
+
+func TestPrepareReceiptPurchaseDateTime(t *testing.T) {
+	// Test case 1: The combined form fills in the split fields and is kept
+	receipt := validReceipt()
+	receipt.PurchaseDate = ""
+	receipt.PurchaseTime = ""
+	receipt.PurchaseDateTime = "2022-03-20T14:33:00-07:00"
+	assert.NoError(t, prepareReceipt(&receipt))
+	expected := validReceipt()
+	expected.PurchaseDateTime = "2022-03-20T14:33:00-07:00"
+	assert.Equal(t, expected, receipt)
+
+	// Test case 2: Both forms are accepted when they agree
+	receipt = validReceipt()
+	receipt.PurchaseDateTime = "2022-03-20T14:33:59Z"
+	assert.NoError(t, prepareReceipt(&receipt))
+	expected.PurchaseDateTime = "2022-03-20T14:33:59Z"
+	assert.Equal(t, expected, receipt)
+
+	// Test case 3: Conflicting forms are rejected
+	for _, dateTime := range []string{"2022-03-21T14:33:00Z", "2022-03-20T14:34:00Z"} {
+		receipt = validReceipt()
+		receipt.PurchaseDateTime = dateTime
+		err := prepareReceipt(&receipt)
+		assert.ErrorIs(t, err, ErrDateConflict, dateTime)
+		_, message := validationStatus(err)
+		assert.Equal(t, "purchaseDateTime does not match purchaseDate and purchaseTime", message)
+	}
+
+	// Test case 4: The combined form must be RFC 3339
+	receipt = validReceipt()
+	receipt.PurchaseDateTime = "2022-03-20 14:33"
+	assert.ErrorIs(t, prepareReceipt(&receipt), ErrBadDateTime)
+}
+
+func TestPrepareReceiptNormalizeRetailer(t *testing.T) {
+	defer setActiveRules(currentRules())
+
+	// Test case 1: Off by default, and a client-supplied value is dropped
+	receipt := validReceipt()
+	receipt.Retailer = "  M&M   Corner Market "
+	receipt.NormalizedRetailer = "anything"
+	assert.NoError(t, prepareReceipt(&receipt))
+	assert.Equal(t, "", receipt.NormalizedRetailer)
+
+	// Test case 2: Trimmed, collapsed and lowercased when enabled, leaving the
+	// original name untouched
+	rules := DefaultRuleSet()
+	rules.NormalizeRetailers = true
+	setActiveRules(rules)
+	assert.NoError(t, prepareReceipt(&receipt))
+	assert.Equal(t, "m&m corner market", receipt.NormalizedRetailer)
+	assert.Equal(t, "  M&M   Corner Market ", receipt.Retailer)
+
+	// Test case 3: Rules passed to prepareReceiptWith override the active ones
+	assert.NoError(t, prepareReceiptWith(&receipt, DefaultRuleSet()))
+	assert.Equal(t, "", receipt.NormalizedRetailer)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Delivery settings for webhook events
+const (
+	webhookAttempts = 3
+	webhookBackoff  = 500 * time.Millisecond
+	webhookTimeout  = 5 * time.Second
+
+	// The breaker opens after this many consecutive failed attempts and
+	// lets a single attempt through once the cooldown has passed
+	webhookBreakerThreshold = 5
+	webhookBreakerCooldown  = 30 * time.Second
+)
+
+// Circuit breaker states, as reported by /debug/stats
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops webhook deliveries while the endpoint keeps failing,
+// so a webhook that is down doesn't cost every receipt its retries
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+
+	// probing is set while the one attempt allowed by a half-open breaker is
+	// in flight
+	probing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow reports whether an attempt may be made. Once the cooldown has passed,
+// an open breaker becomes half-open and allows one attempt to test recovery.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+		cb.state = breakerHalfOpen
+	}
+	switch cb.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+	}
+	return true
+}
+
+// record closes the breaker after a success, and opens it after a failed
+// probe or once failures reach the threshold
+func (cb *circuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+	if err == nil {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// WebhookBreakerStats describes the webhook circuit breaker
+type WebhookBreakerStats struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+func (cb *circuitBreaker) stats() WebhookBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state := cb.state
+	if state == breakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+		state = breakerHalfOpen
+	}
+	return WebhookBreakerStats{State: state, ConsecutiveFailures: cb.failures}
+}
+
+// ReceiptEvent is posted to the webhook for every processed receipt
+type ReceiptEvent struct {
+	ID          string    `json:"id"`
+	Retailer    string    `json:"retailer"`
+	Points      int       `json:"points"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
+
+// WebhookNotifier posts receipt events to a URL in the background
+type WebhookNotifier struct {
+	url      string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	breaker  *circuitBreaker
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:      url,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: webhookAttempts,
+		backoff:  webhookBackoff,
+		breaker:  newCircuitBreaker(webhookBreakerThreshold, webhookBreakerCooldown),
+	}
+}
+
+// BreakerStats reports the state of the delivery circuit breaker
+func (wn *WebhookNotifier) BreakerStats() WebhookBreakerStats {
+	return wn.breaker.stats()
+}
+
+// Notify delivers the event asynchronously so it never delays the request
+func (wn *WebhookNotifier) Notify(event ReceiptEvent) {
+	go wn.deliver(event)
+}
+
+// deliver posts the event, retrying with exponential backoff. Failures are
+// only logged, and the event is dropped while the circuit breaker is open.
+func (wn *WebhookNotifier) deliver(event ReceiptEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode webhook event", "id", event.ID, "error", err)
+		return
+	}
+
+	backoff := wn.backoff
+	for attempt := 1; attempt <= wn.attempts; attempt++ {
+		if !wn.breaker.allow() {
+			slog.Warn("webhook circuit breaker is open, dropping event", "id", event.ID, "attempt", attempt)
+			return
+		}
+		err = wn.post(body)
+		wn.breaker.record(err)
+		if err == nil {
+			return
+		}
+		slog.Warn("webhook delivery failed", "id", event.ID, "attempt", attempt, "error", err)
+		if attempt < wn.attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	slog.Error("giving up on webhook delivery", "id", event.ID, "attempts", wn.attempts, "error", err)
+}
+
+func (wn *WebhookNotifier) post(body []byte) error {
+	resp, err := wn.client.Post(wn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// newReceiptEvent describes a newly stored receipt for webhooks and streams
+func newReceiptEvent(scored ScoredReceipt) ReceiptEvent {
+	return ReceiptEvent{
+		ID:          scored.ID,
+		Retailer:    scored.Receipt.Retailer,
+		Points:      scored.Points,
+		ProcessedAt: time.Now().UTC(),
+	}
+}
+
+// NotifyStore wraps the store so that processed receipts are sent to the webhook
+func (wn *WebhookNotifier) NotifyStore(store Store) Store {
+	return onStored(store, func(scored ScoredReceipt) {
+		wn.Notify(newReceiptEvent(scored))
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var attempts atomic.Int32
+	events := make(chan ReceiptEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first two deliveries so the retry is exercised
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event ReceiptEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+
+	notifier := NewWebhookNotifier(webhook.URL)
+	notifier.backoff = time.Millisecond
+	store := notifier.NotifyStore(NewReceiptStore())
+
+	id := store.AddReceipt(context.Background(), validReceipt())
+
+	select {
+	case event := <-events:
+		assert.Equal(t, id, event.ID)
+		assert.Equal(t, validReceipt().Retailer, event.Retailer)
+		assert.Equal(t, calculatePoints(validReceipt(), currentRules()), event.Points)
+		assert.False(t, event.ProcessedAt.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	assert.Equal(t, int32(3), attempts.Load())
+}
+
+func TestWebhookNotifierGivesUp(t *testing.T) {
+	var attempts atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+
+	notifier := NewWebhookNotifier(webhook.URL)
+	notifier.backoff = time.Millisecond
+
+	// Delivery runs synchronously here so the attempts can be counted
+	notifier.deliver(ReceiptEvent{ID: "id"})
+	assert.Equal(t, int32(webhookAttempts), attempts.Load())
+}
+
+func TestWebhookStoreSkipsReplays(t *testing.T) {
+	var attempts atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+	}))
+	defer webhook.Close()
+
+	notifier := NewWebhookNotifier(webhook.URL)
+	store := notifier.NotifyStore(NewReceiptStore())
+
+	// Replayed idempotent submissions don't send a second event
+	_, _, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+	_, replayed, err := store.AddReceiptIdempotent(context.Background(), "key-1", validReceipt())
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+
+	assert.Eventually(t, func() bool { return attempts.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestWebhookCircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	var healthy atomic.Bool
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer webhook.Close()
+
+	cooldown := 50 * time.Millisecond
+	notifier := NewWebhookNotifier(webhook.URL)
+	notifier.backoff = time.Millisecond
+	notifier.breaker = newCircuitBreaker(webhookAttempts, cooldown)
+	debugStats := NewDebugStats()
+	debugStats.TrackWebhook(notifier)
+
+	// Test case 1: Consecutive failures open the breaker
+	notifier.deliver(ReceiptEvent{ID: "first"})
+	assert.Equal(t, int32(webhookAttempts), attempts.Load())
+	assert.Equal(t, &WebhookBreakerStats{State: breakerOpen, ConsecutiveFailures: webhookAttempts}, debugStats.Snapshot().Webhook)
+
+	// Test case 2: Deliveries are dropped without an attempt while it is open
+	notifier.deliver(ReceiptEvent{ID: "second"})
+	assert.Equal(t, int32(webhookAttempts), attempts.Load())
+
+	// Test case 3: After the cooldown a single failed probe opens it again
+	time.Sleep(cooldown)
+	assert.Equal(t, breakerHalfOpen, notifier.BreakerStats().State)
+	notifier.deliver(ReceiptEvent{ID: "third"})
+	assert.Equal(t, int32(webhookAttempts+1), attempts.Load())
+	assert.Equal(t, breakerOpen, notifier.BreakerStats().State)
+
+	// Test case 4: A successful probe closes it
+	healthy.Store(true)
+	time.Sleep(cooldown)
+	notifier.deliver(ReceiptEvent{ID: "fourth"})
+	assert.Equal(t, int32(webhookAttempts+2), attempts.Load())
+	assert.Equal(t, WebhookBreakerStats{State: breakerClosed}, notifier.BreakerStats())
+}